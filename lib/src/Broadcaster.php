@@ -17,6 +17,8 @@ class Broadcaster extends BaseBroadcaster
     protected string $appId;
     protected string $appKey;
     protected string $secret;
+    protected int $ack = 0;
+    protected int $ackTimeoutMs = 0;
 
     /**
      * @param array<string, mixed> $config
@@ -38,6 +40,20 @@ class Broadcaster extends BaseBroadcaster
         $this->appId = $config['app_id'];
         $this->appKey = $config['key'];
         $this->secret = $config['secret'];
+
+        $ack = $config['ack'] ?? 'none';
+        $this->ack = match ($ack) {
+            'none' => 0,
+            'local' => 1,
+            'quorum' => 2,
+            default => throw new InvalidArgumentException('Pogo WebSocket ack must be one of none, local, or quorum.'),
+        };
+
+        $ackTimeout = $config['ack_timeout'] ?? 0;
+        if (!is_int($ackTimeout) || $ackTimeout < 0) {
+            throw new InvalidArgumentException('Pogo WebSocket ack_timeout must be a non-negative number of milliseconds.');
+        }
+        $this->ackTimeoutMs = $ackTimeout;
     }
 
     /**
@@ -168,27 +184,47 @@ class Broadcaster extends BaseBroadcaster
             return;
         }
 
+        $socket = $payload['socket'] ?? null;
+        unset($payload['socket']);
+        $idempotencyKey = $this->pullIdempotencyKey($payload);
+
         $payloadJson = $this->encodeBroadcastPayload($payload);
         if ($payloadJson === false) {
             $this->throwBroadcastError('payload_encode_failed', $channels, (string) $event);
         }
 
         $eventStr = (string) $event;
+        $validChannels = [];
+        foreach ($channels as $channel) {
+            $s = (string) $channel;
+            if ($s !== '') {
+                $validChannels[] = $s;
+            }
+        }
 
-        if ($this->hasBroadcastMulti()) {
-            $validChannels = [];
-            foreach ($channels as $channel) {
-                $s = (string) $channel;
-                if ($s !== '') {
-                    $validChannels[] = $s;
-                }
+        if ($this->hasPublishWithOptions()) {
+            if (empty($validChannels)) {
+                return;
             }
 
+            $options = $this->nativePublishOptions(is_string($socket) ? $socket : null, $idempotencyKey);
+            $failed = array_filter(
+                $this->publishWithOptions($validChannels, $eventStr, $payloadJson, $options),
+                fn (int $status): bool => !$this->publishSucceeded($status)
+            );
+            if (!empty($failed)) {
+                $this->throwBroadcastError('publish_failed', array_map('strval', array_keys($failed)), $eventStr, 'pogo_websocket_publish_with_options', reset($failed));
+            }
+
+            return;
+        }
+
+        if ($this->hasBroadcastMulti()) {
             if (!empty($validChannels)) {
                 $channelsJson = json_encode($validChannels);
                 if ($channelsJson !== false) {
-                    $result = $this->broadcastMulti($channelsJson, $eventStr, $payloadJson);
-                    if ($result === 0) {
+                    $result = $this->broadcastMulti($channelsJson, $eventStr, $payloadJson, $idempotencyKey);
+                    if ($this->publishSucceeded($result)) {
                         return;
                     }
                     $this->throwBroadcastError('broadcast_multi_failed', $validChannels, $eventStr, 'pogo_websocket_broadcast_multi', $result);
@@ -205,22 +241,63 @@ class Broadcaster extends BaseBroadcaster
         foreach ($channels as $channel) {
             $channelStr = (string) $channel;
             if ($channelStr !== '' && $eventStr !== '') {
-                $result = $this->publish($channelStr, $eventStr, $payloadJson);
-                if ($result !== 0) {
+                $result = $this->publish($channelStr, $eventStr, $payloadJson, $idempotencyKey);
+                if (!$this->publishSucceeded($result)) {
                     $this->throwBroadcastError('publish_failed', [$channelStr], $eventStr, 'pogo_websocket_publish', $result);
                 }
             }
         }
     }
 
+    protected function hasPublishWithOptions(): bool
+    {
+        return function_exists('pogo_websocket_publish_with_options');
+    }
+
+    /**
+     * @param  array<string>  $channels
+     * @param  array<string, mixed>  $options
+     * @return array<string, int>
+     */
+    protected function publishWithOptions(array $channels, string $event, string $payloadJson, array $options): array
+    {
+        return pogo_websocket_publish_with_options($this->appId, $channels, $event, $payloadJson, $options);
+    }
+
+    /**
+     * Options for pogo_websocket_publish_with_options; `socket_id` makes `->toOthers()` work.
+     *
+     * @return array<string, mixed>
+     */
+    protected function nativePublishOptions(?string $socketId, ?string $idempotencyKey): array
+    {
+        $options = [];
+        if ($socketId !== null && $socketId !== '') {
+            $options['socket_id'] = $socketId;
+        }
+        if ($this->ack !== 0) {
+            $options['ack'] = $this->ack;
+            $options['ack_timeout_ms'] = $this->ackTimeoutMs;
+        }
+        if ($idempotencyKey !== null) {
+            $options['idempotency_key'] = $idempotencyKey;
+        }
+
+        return $options;
+    }
+
     protected function hasBroadcastMulti(): bool
     {
         return function_exists('pogo_websocket_broadcast_multi');
     }
 
-    protected function broadcastMulti(string $channelsJson, string $event, string $payloadJson): int
+    protected function broadcastMulti(string $channelsJson, string $event, string $payloadJson, ?string $idempotencyKey = null): int
     {
-        return pogo_websocket_broadcast_multi($this->appId, $channelsJson, $event, $payloadJson);
+        if ($this->ack === 0 && $idempotencyKey === null) {
+            return pogo_websocket_broadcast_multi($this->appId, $channelsJson, $event, $payloadJson);
+        }
+
+        return pogo_websocket_broadcast_multi($this->appId, $channelsJson, $event, $payloadJson, $this->ack, $this->ackTimeoutMs, $idempotencyKey);
     }
 
     protected function hasPublish(): bool
@@ -228,9 +305,34 @@ class Broadcaster extends BaseBroadcaster
         return function_exists('pogo_websocket_publish');
     }
 
-    protected function publish(string $channel, string $event, string $payloadJson): int
+    protected function publish(string $channel, string $event, string $payloadJson, ?string $idempotencyKey = null): int
+    {
+        if ($this->ack === 0 && $idempotencyKey === null) {
+            return pogo_websocket_publish($this->appId, $channel, $event, $payloadJson);
+        }
+
+        return pogo_websocket_publish($this->appId, $channel, $event, $payloadJson, $this->ack, $this->ackTimeoutMs, $idempotencyKey);
+    }
+
+    /**
+     * A deduplicated publish (status 12) was already delivered by an earlier attempt.
+     */
+    protected function publishSucceeded(int $result): bool
     {
-        return pogo_websocket_publish($this->appId, $channel, $event, $payloadJson);
+        return $result === 0 || $result === 12;
+    }
+
+    /**
+     * Remove the optional `idempotency_key` from the payload, like Laravel does with `socket`.
+     *
+     * @param  array<mixed>  $payload
+     */
+    protected function pullIdempotencyKey(array &$payload): ?string
+    {
+        $key = $payload['idempotency_key'] ?? null;
+        unset($payload['idempotency_key']);
+
+        return is_string($key) && $key !== '' ? $key : null;
     }
 
     /**
@@ -265,6 +367,12 @@ class Broadcaster extends BaseBroadcaster
             7 => 'invalid_channels_json',
             8 => 'broker_queue_full',
             9 => 'shard_queue_full',
+            10 => 'ack_timeout',
+            11 => 'invalid_schedule',
+            12 => 'deduplicated',
+            13 => 'invalid_target',
+            14 => 'invalid_options',
+            15 => 'ack_unsupported',
             default => 'unknown',
         };
     }
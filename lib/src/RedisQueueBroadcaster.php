@@ -0,0 +1,96 @@
+<?php
+
+namespace Pogo\WebSocket;
+
+use Illuminate\Contracts\Redis\Factory as RedisFactory;
+use InvalidArgumentException;
+
+/**
+ * Pushes broadcasts onto the Redis list consumed by `redis_ingest_list`, so
+ * queue workers outside the FrankenPHP process can broadcast without HTTP.
+ */
+class RedisQueueBroadcaster extends Broadcaster
+{
+    protected ?RedisFactory $redis;
+    protected ?string $connection;
+    protected string $list;
+
+    /**
+     * @param array<string, mixed> $config
+     */
+    public function __construct(array $config = [], ?RedisFactory $redis = null)
+    {
+        parent::__construct($config);
+
+        $list = $config['list'] ?? 'pogo:broadcasts';
+        if (!is_string($list) || $list === '') {
+            throw new InvalidArgumentException('Pogo WebSocket Redis list must be a non-empty string.');
+        }
+
+        $connection = $config['connection'] ?? null;
+        $this->connection = is_string($connection) ? $connection : null;
+        $this->list = $list;
+        $this->redis = $redis;
+    }
+
+    /**
+     * Broadcast the given event.
+     *
+     * @param  array<string>  $channels
+     * @param  string  $event
+     * @param  array<mixed>  $payload
+     * @return void
+     */
+    public function broadcast(array $channels, $event, array $payload = [])
+    {
+        $eventStr = (string) $event;
+        $validChannels = [];
+        foreach ($channels as $channel) {
+            $s = (string) $channel;
+            if ($s !== '') {
+                $validChannels[] = $s;
+            }
+        }
+        if (empty($validChannels)) {
+            return;
+        }
+
+        $socket = $payload['socket'] ?? null;
+        unset($payload['socket']);
+        $idempotencyKey = $this->pullIdempotencyKey($payload);
+
+        $payloadJson = $this->encodeBroadcastPayload($payload);
+        if ($payloadJson === false) {
+            $this->throwBroadcastError('payload_encode_failed', $validChannels, $eventStr);
+        }
+
+        $job = [
+            'name' => $eventStr,
+            'data' => $payloadJson,
+            'channels' => $validChannels,
+        ];
+        if (is_string($socket) && $socket !== '') {
+            $job['socket_id'] = $socket;
+        }
+        if ($idempotencyKey !== null) {
+            $job['idempotency_key'] = $idempotencyKey;
+        }
+
+        $jobJson = json_encode($job);
+        if ($jobJson === false) {
+            $this->throwBroadcastError('job_encode_failed', $validChannels, $eventStr);
+        }
+
+        $this->pushJob($jobJson);
+    }
+
+    /**
+     * Push with a raw command so the connection's key prefix (Laravel's default
+     * `<app>_database_`) is not applied: the module pops the list name as is.
+     */
+    protected function pushJob(string $jobJson): void
+    {
+        $redis = $this->redis ?? app(RedisFactory::class);
+        $redis->connection($this->connection)->executeRaw(['RPUSH', $this->list, $jobJson]);
+    }
+}
@@ -16,6 +16,13 @@ class ServiceProvider extends BaseServiceProvider
             return new Broadcaster($configArray);
         });
 
+        Broadcast::extend('pogo-redis', function ($app, $config) {
+            /** @var array<string, mixed> $configArray */
+            $configArray = is_array($config) ? $config : [];
+
+            return new RedisQueueBroadcaster($configArray);
+        });
+
         if ($this->app->runningInConsole()) {
             $this->commands([
                 Console\InstallCommand::class,
@@ -135,7 +135,7 @@ class BroadcasterTest extends TestCase
                 return true;
             }
 
-            protected function broadcastMulti(string $channelsJson, string $event, string $payloadJson): int
+            protected function broadcastMulti(string $channelsJson, string $event, string $payloadJson, ?string $idempotencyKey = null): int
             {
                 return 1;
             }
@@ -159,7 +159,7 @@ class BroadcasterTest extends TestCase
                 return true;
             }
 
-            protected function broadcastMulti(string $channelsJson, string $event, string $payloadJson): int
+            protected function broadcastMulti(string $channelsJson, string $event, string $payloadJson, ?string $idempotencyKey = null): int
             {
                 $this->multiCalls++;
 
@@ -171,7 +171,7 @@ class BroadcasterTest extends TestCase
                 return true;
             }
 
-            protected function publish(string $channel, string $event, string $payloadJson): int
+            protected function publish(string $channel, string $event, string $payloadJson, ?string $idempotencyKey = null): int
             {
                 $this->publishCalls++;
 
@@ -185,6 +185,67 @@ class BroadcasterTest extends TestCase
         $this->assertSame(0, $broadcaster->publishCalls);
     }
 
+    public function testDeduplicatedBroadcastPassesKeyAndSucceeds()
+    {
+        $broadcaster = new class (['app_id' => 'test-app', 'key' => 'test-key', 'secret' => 'super-secret']) extends Broadcaster {
+            public ?string $idempotencyKey = null;
+
+            public string $payloadJson = '';
+
+            protected function hasBroadcastMulti(): bool
+            {
+                return true;
+            }
+
+            protected function broadcastMulti(string $channelsJson, string $event, string $payloadJson, ?string $idempotencyKey = null): int
+            {
+                $this->idempotencyKey = $idempotencyKey;
+                $this->payloadJson = $payloadJson;
+
+                return 12;
+            }
+        };
+
+        $broadcaster->broadcast(['test-channel'], 'test-event', ['foo' => 'bar', 'idempotency_key' => 'order-42']);
+
+        $this->assertSame('order-42', $broadcaster->idempotencyKey);
+        $this->assertSame(['foo' => 'bar'], json_decode($broadcaster->payloadJson, true));
+    }
+
+    public function testPublishWithOptionsPassesSocketAndReportsFailedChannels()
+    {
+        $broadcaster = new class (['app_id' => 'test-app', 'key' => 'test-key', 'secret' => 'super-secret', 'ack' => 'local']) extends Broadcaster {
+            /** @var array<string, mixed> */
+            public array $options = [];
+
+            public string $payloadJson = '';
+
+            protected function hasPublishWithOptions(): bool
+            {
+                return true;
+            }
+
+            protected function publishWithOptions(array $channels, string $event, string $payloadJson, array $options): array
+            {
+                $this->options = $options;
+                $this->payloadJson = $payloadJson;
+
+                return ['orders' => 0, 'private-user.1' => 9];
+            }
+        };
+
+        try {
+            $broadcaster->broadcast(['orders', 'private-user.1'], 'order.shipped', ['id' => 1, 'socket' => '1.1']);
+            $this->fail('Expected BroadcastException');
+        } catch (BroadcastException $e) {
+            $this->assertStringContainsString('channels=private-user.1 ', $e->getMessage());
+            $this->assertStringContainsString('status=9(shard_queue_full)', $e->getMessage());
+        }
+
+        $this->assertSame(['socket_id' => '1.1', 'ack' => 1, 'ack_timeout_ms' => 0], $broadcaster->options);
+        $this->assertSame(['id' => 1], json_decode($broadcaster->payloadJson, true));
+    }
+
     public function testNonBenchmarkAndFailedPayloadEncodingKeepExistingBehavior()
     {
         $broadcaster = new class (['app_id' => 'test-app', 'key' => 'test-key', 'secret' => 'super-secret']) extends Broadcaster {
@@ -233,5 +294,13 @@ class BroadcasterTest extends TestCase
 
         $this->assertSame('broker_queue_full', $broadcaster->reasonFor(8));
         $this->assertSame('shard_queue_full', $broadcaster->reasonFor(9));
+        $this->assertSame('ack_timeout', $broadcaster->reasonFor(10));
+        $this->assertSame('ack_unsupported', $broadcaster->reasonFor(15));
+    }
+
+    public function testConstructorRejectsUnknownAckMode()
+    {
+        $this->expectException(InvalidArgumentException::class);
+        new Broadcaster(['app_id' => 'test-app', 'key' => 'test-key', 'secret' => 'super-secret', 'ack' => 'all']);
     }
 }
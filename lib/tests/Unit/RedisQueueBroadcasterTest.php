@@ -0,0 +1,76 @@
+<?php
+
+namespace Pogo\WebSocket\Tests\Unit;
+
+use Illuminate\Contracts\Redis\Factory as RedisFactory;
+use PHPUnit\Framework\TestCase;
+use Pogo\WebSocket\RedisQueueBroadcaster;
+
+class RedisQueueBroadcasterTest extends TestCase
+{
+    public function testBroadcastPushesIngestJob()
+    {
+        $broadcaster = new class (['app_id' => 'test-app', 'key' => 'test-key', 'secret' => 'super-secret']) extends RedisQueueBroadcaster {
+            /** @var array<string> */
+            public array $pushed = [];
+
+            protected function pushJob(string $jobJson): void
+            {
+                $this->pushed[] = $jobJson;
+            }
+        };
+
+        $broadcaster->broadcast(['orders', 'private-user.1'], 'order.shipped', ['id' => 1, 'socket' => '1.1', 'idempotency_key' => 'order-1']);
+
+        $this->assertCount(1, $broadcaster->pushed);
+        $job = json_decode($broadcaster->pushed[0], true);
+        $this->assertSame('order.shipped', $job['name']);
+        $this->assertSame(['orders', 'private-user.1'], $job['channels']);
+        $this->assertSame('1.1', $job['socket_id']);
+        $this->assertSame('order-1', $job['idempotency_key']);
+        $this->assertSame(['id' => 1], json_decode($job['data'], true));
+    }
+
+    public function testPushJobIgnoresConnectionKeyPrefix()
+    {
+        $connection = new class () {
+            public string $prefix = 'laravel_database_';
+            /** @var array<string, array<string>> */
+            public array $lists = [];
+
+            public function rpush(string $key, string $value): int
+            {
+                $this->lists[$this->prefix.$key][] = $value;
+
+                return count($this->lists[$this->prefix.$key]);
+            }
+
+            /**
+             * @param  array<string>  $parameters
+             */
+            public function executeRaw(array $parameters): int
+            {
+                [, $key, $value] = $parameters;
+                $this->lists[$key][] = $value;
+
+                return count($this->lists[$key]);
+            }
+        };
+        $redis = new class ($connection) implements RedisFactory {
+            public function __construct(private object $connection)
+            {
+            }
+
+            public function connection($name = null)
+            {
+                return $this->connection;
+            }
+        };
+
+        $broadcaster = new RedisQueueBroadcaster(['app_id' => 'test-app', 'key' => 'test-key', 'secret' => 'super-secret'], $redis);
+        $broadcaster->broadcast(['orders'], 'order.shipped', ['id' => 1]);
+
+        $this->assertSame(['pogo:broadcasts'], array_keys($connection->lists));
+        $this->assertSame(['orders'], json_decode($connection->lists['pogo:broadcasts'][0], true)['channels']);
+    }
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const KeyVersionPrimary = "primary"
+
+// AppCredential is one app key and secret pair. A zero ExpiresAt never expires.
+type AppCredential struct {
+	Version   string
+	Key       string
+	Secret    string
+	ExpiresAt time.Time
+}
+
+// KeyRing holds the primary credential, used for signing, and previous ones
+// that are still accepted for verification until they expire, so a secret can
+// be rotated without failing signatures made with the old one.
+type KeyRing struct {
+	credentials []AppCredential
+	metrics     *Metrics
+	now         func() time.Time
+}
+
+// NewKeyRing versions the primary credential "primary" and the others
+// "previous_1", "previous_2", ... in the order given.
+func NewKeyRing(metrics *Metrics, primary AppCredential, previous ...AppCredential) *KeyRing {
+	primary.Version = KeyVersionPrimary
+	credentials := append(make([]AppCredential, 0, len(previous)+1), primary)
+	for i, credential := range previous {
+		credential.Version = fmt.Sprintf("previous_%d", i+1)
+		credentials = append(credentials, credential)
+	}
+	return &KeyRing{credentials: credentials, metrics: metrics, now: time.Now}
+}
+
+func (k *KeyRing) Primary() AppCredential {
+	return k.credentials[0]
+}
+
+func (k *KeyRing) active() []AppCredential {
+	now := k.now()
+	active := make([]AppCredential, 0, len(k.credentials))
+	for _, credential := range k.credentials {
+		if credential.Secret == "" || (!credential.ExpiresAt.IsZero() && !now.Before(credential.ExpiresAt)) {
+			continue
+		}
+		active = append(active, credential)
+	}
+	return active
+}
+
+// HasKey reports whether key belongs to an unexpired credential.
+func (k *KeyRing) HasKey(key string) bool {
+	for _, credential := range k.active() {
+		if credential.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks a hex HMAC-SHA256 signature made by any unexpired credential
+// with the given key, and counts which version matched under scope.
+func (k *KeyRing) Verify(scope, key, signature, stringToSign string) bool {
+	for _, credential := range k.active() {
+		if credential.Key != key || !validSignature(credential.Secret, signature, stringToSign) {
+			continue
+		}
+		if k.metrics != nil {
+			k.metrics.KeyVerifications.WithLabelValues(scope, credential.Version).Inc()
+		}
+		return true
+	}
+	return false
+}
+
+// VerifyAuth checks a Pusher "key:signature" auth string.
+func (k *KeyRing) VerifyAuth(scope, auth, stringToSign string) bool {
+	key, signature, ok := strings.Cut(auth, ":")
+	if !ok || strings.Contains(signature, ":") {
+		return false
+	}
+	return k.Verify(scope, key, signature, stringToSign)
+}
+
+// Signatures signs payload with every unexpired credential, primary first.
+func (k *KeyRing) Signatures(payload []byte) []KeySignature {
+	active := k.active()
+	signatures := make([]KeySignature, 0, len(active))
+	for _, credential := range active {
+		mac := hmac.New(sha256.New, []byte(credential.Secret))
+		mac.Write(payload)
+		signatures = append(signatures, KeySignature{Version: credential.Version, Signature: hex.EncodeToString(mac.Sum(nil))})
+	}
+	return signatures
+}
+
+type KeySignature struct {
+	Version   string
+	Signature string
+}
+
+// PreviousSecret is a rotated-out secret still accepted until ExpiresAt
+// (RFC 3339). Key defaults to the current app key.
+type PreviousSecret struct {
+	Secret    string `json:"secret"`
+	Key       string `json:"key,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+func previousCredentials(directive, defaultKey string, secrets []PreviousSecret) ([]AppCredential, error) {
+	credentials := make([]AppCredential, 0, len(secrets))
+	for _, previous := range secrets {
+		if previous.Secret == "" {
+			return nil, fmt.Errorf("%s requires a secret", directive)
+		}
+		credential := AppCredential{Key: previous.Key, Secret: previous.Secret}
+		if credential.Key == "" {
+			credential.Key = defaultKey
+		}
+		if previous.ExpiresAt != "" {
+			expiresAt, err := time.Parse(time.RFC3339, previous.ExpiresAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s expiry: %v", directive, err)
+			}
+			credential.ExpiresAt = expiresAt
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, nil
+}
@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func hmacHex(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestKeyRingAcceptsPreviousSecretsUntilExpiry(t *testing.T) {
+	metrics := NewMetrics(prometheus.NewRegistry())
+	now := time.Now()
+	keys := NewKeyRing(metrics,
+		AppCredential{Key: "key", Secret: "new"},
+		AppCredential{Key: "key", Secret: "old", ExpiresAt: now.Add(time.Hour)},
+		AppCredential{Key: "old-key", Secret: "older"},
+	)
+
+	if !keys.VerifyAuth("channel", "key:"+hmacHex("old", "1.1:private-a"), "1.1:private-a") {
+		t.Fatal("Expected a signature made with the previous secret to verify")
+	}
+	if !keys.VerifyAuth("channel", "old-key:"+hmacHex("older", "1.1:private-a"), "1.1:private-a") {
+		t.Fatal("Expected a rotated app key to verify with its own secret")
+	}
+	if keys.VerifyAuth("channel", "key:"+hmacHex("older", "1.1:private-a"), "1.1:private-a") {
+		t.Fatal("Expected a secret to verify only under its own key")
+	}
+	if got := counterValue(t, metrics.KeyVerifications.WithLabelValues("channel", "previous_1")); got != 1 {
+		t.Fatalf("previous_1 verifications = %v, want 1", got)
+	}
+
+	keys.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if keys.VerifyAuth("channel", "key:"+hmacHex("old", "1.1:private-a"), "1.1:private-a") {
+		t.Fatal("Expected an expired secret to be rejected")
+	}
+	if !keys.Verify("http_api", "key", hmacHex("new", "payload"), "payload") {
+		t.Fatal("Expected the primary secret to verify")
+	}
+}
+
+func TestWorkerAuthProviderVerifiesSigninWithKeyRing(t *testing.T) {
+	auth := NewWorkerAuthProvider(zap.NewNop(), nil, nil, "key", "/auth", 1024, 1, "new")
+	auth.SetKeyRing(NewKeyRing(nil, AppCredential{Key: "key", Secret: "new"}, AppCredential{Key: "key", Secret: "old"}))
+
+	client := &Client{ID: "1.1"}
+	userData := `{"id":"42"}`
+	if res := auth.AuthenticateUser(client, "key:"+hmacHex("old", "1.1::user::"+userData), userData); !res.Allowed {
+		t.Fatal("Expected signin signed with the previous secret to be allowed")
+	}
+	if res := auth.AuthenticateUser(client, "other:"+hmacHex("old", "1.1::user::"+userData), userData); res.Allowed {
+		t.Fatal("Expected signin with an unknown key to be denied")
+	}
+}
+
+func TestWebhookManagerSignsWithEveryActiveSecret(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+	}))
+	defer ts.Close()
+
+	wm := NewWebhookManager(zap.NewNop(), ts.URL, "new")
+	wm.SetKeyRing(NewKeyRing(nil, AppCredential{Secret: "new"}, AppCredential{Secret: "old"}))
+	defer wm.Close()
+	wm.Notify("channel_occupied", "private-a")
+
+	select {
+	case header := <-headers:
+		versions := header.Get("X-FrankenPHP-WS-Signatures")
+		if !strings.HasPrefix(versions, "primary="+header.Get("X-Pusher-Signature")+",previous_1=") {
+			t.Fatalf("signatures header = %q, X-Pusher-Signature = %q", versions, header.Get("X-Pusher-Signature"))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for webhook")
+	}
+}
@@ -24,8 +24,13 @@ type RequestDispatcher interface {
 }
 
 const (
-	CBThreshold    = 5
-	CBResetTimeout = 10 * time.Second
+	CBThreshold        = 5
+	CBMinRequests      = 10
+	CBResetTimeout     = 10 * time.Second
+	CBRatioInterval    = time.Minute
+	DefaultAuthTimeout = 3 * time.Second
+
+	DefaultUserAuthPath = "/broadcasting/user-auth"
 )
 
 type responseCapturer struct {
@@ -58,6 +63,9 @@ var capturerPool = sync.Pool{
 type AuthResult struct {
 	Allowed  bool
 	UserData json.RawMessage
+	// Unavailable marks a denial caused by the auth backend being busy or
+	// failing rather than by an answer, so re-authorization keeps the subscription.
+	Unavailable bool
 }
 
 type AuthProvider interface {
@@ -71,61 +79,158 @@ type channelAuthResponse struct {
 }
 
 type WorkerAuthProvider struct {
-	appKey      string
+	keys        *KeyRing
 	worker      RequestDispatcher
 	authPath    string
+	userPath    string
 	logger      *zap.Logger
 	metrics     *Metrics
 	breaker     *gobreaker.CircuitBreaker[AuthResult]
 	maxAuthBody int
 	sem         chan struct{}
-	secret      string
+	timeout     time.Duration
+	cache       *authResultCache
+	batcher     *authBatcher
 }
 
-func NewWorkerAuthProvider(logger *zap.Logger, metrics *Metrics, worker RequestDispatcher, appKey string, authPath string, maxAuthBody int, maxConcurrent int, secret string) *WorkerAuthProvider {
-	st := gobreaker.Settings{
+// BreakerConfig tunes the auth circuit breaker. With FailureRatio set it trips
+// once that share of at least MinRequests calls in Interval has failed,
+// otherwise after Threshold consecutive failures. After ResetTimeout it lets
+// HalfOpenRequests probes through, and closes once they all succeed.
+type BreakerConfig struct {
+	Threshold        int
+	FailureRatio     float64
+	MinRequests      int
+	HalfOpenRequests int
+	ResetTimeout     time.Duration
+	Interval         time.Duration
+}
+
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		Threshold:        CBThreshold,
+		MinRequests:      CBMinRequests,
+		HalfOpenRequests: 1,
+		ResetTimeout:     CBResetTimeout,
+	}
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	defaults := DefaultBreakerConfig()
+	if c.Threshold <= 0 {
+		c.Threshold = defaults.Threshold
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = defaults.MinRequests
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = defaults.HalfOpenRequests
+	}
+	if c.ResetTimeout <= 0 {
+		c.ResetTimeout = defaults.ResetTimeout
+	}
+	// A ratio over the provider's whole lifetime would never trip on a new outage.
+	if c.FailureRatio > 0 && c.Interval <= 0 {
+		c.Interval = CBRatioInterval
+	}
+	return c
+}
+
+func newAuthBreaker(logger *zap.Logger, metrics *Metrics, config BreakerConfig) *gobreaker.CircuitBreaker[AuthResult] {
+	config = config.withDefaults()
+	if metrics != nil {
+		metrics.AuthBreakerState.Set(float64(gobreaker.StateClosed))
+	}
+	return gobreaker.NewCircuitBreaker[AuthResult](gobreaker.Settings{
 		Name:        "FrankenPHP-Auth-Worker",
-		MaxRequests: 1,
-		Interval:    0,
-		Timeout:     CBResetTimeout,
+		MaxRequests: uint32(config.HalfOpenRequests),
+		Interval:    config.Interval,
+		Timeout:     config.ResetTimeout,
 		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			return counts.ConsecutiveFailures >= CBThreshold
+			if config.FailureRatio > 0 {
+				return counts.Requests >= uint32(config.MinRequests) &&
+					float64(counts.TotalFailures)/float64(counts.Requests) >= config.FailureRatio
+			}
+			return counts.ConsecutiveFailures >= uint32(config.Threshold)
 		},
-	}
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			if metrics != nil {
+				metrics.AuthBreakerState.Set(float64(to))
+			}
+			logger.Warn("Auth: circuit breaker state changed", zap.String("from", from.String()), zap.String("to", to.String()))
+		},
+	})
+}
 
+func NewWorkerAuthProvider(logger *zap.Logger, metrics *Metrics, worker RequestDispatcher, appKey string, authPath string, maxAuthBody int, maxConcurrent int, secret string) *WorkerAuthProvider {
 	if maxConcurrent <= 0 {
 		maxConcurrent = 100
 	}
 
 	return &WorkerAuthProvider{
-		appKey:      appKey,
+		keys:        NewKeyRing(metrics, AppCredential{Key: appKey, Secret: secret}),
 		logger:      logger,
 		metrics:     metrics,
 		worker:      worker,
 		authPath:    authPath,
-		breaker:     gobreaker.NewCircuitBreaker[AuthResult](st),
+		breaker:     newAuthBreaker(logger, metrics, DefaultBreakerConfig()),
 		maxAuthBody: maxAuthBody,
 		sem:         make(chan struct{}, maxConcurrent),
-		secret:      secret,
+		timeout:     DefaultAuthTimeout,
+	}
+}
+
+// EnableUserAuth sends pusher:signin requests that carry no signature to the
+// worker at path, e.g. /broadcasting/user-auth.
+func (ap *WorkerAuthProvider) EnableUserAuth(path string) {
+	ap.userPath = path
+}
+
+// SetBreakerConfig replaces the default circuit breaker policy. Call it before
+// the provider is used.
+func (ap *WorkerAuthProvider) SetBreakerConfig(config BreakerConfig) {
+	ap.breaker = newAuthBreaker(ap.logger, ap.metrics, config)
+}
+
+// SetKeyRing replaces the single app key and secret with a rotation key ring.
+// Call it before the provider is used.
+func (ap *WorkerAuthProvider) SetKeyRing(keys *KeyRing) {
+	ap.keys = keys
+}
+
+// EnableResultCache caches worker answers per identity and channel. Call it
+// before the provider is used.
+func (ap *WorkerAuthProvider) EnableResultCache(config AuthCacheConfig) {
+	ap.cache = newAuthResultCache(config)
+}
+
+func (ap *WorkerAuthProvider) InvalidateAuthCache(channel, userID string) int {
+	if ap.cache == nil {
+		return 0
 	}
+	return ap.cache.invalidate(channel, userID)
 }
 
-// AuthenticateUser performs local HMAC verification of the pusher:signin event.
+// AuthenticateUser performs local HMAC verification of the pusher:signin event,
+// or asks the worker to sign the user in when the client sent no signature.
 // Ref: https://pusher.com/docs/channels/server_api/authenticating-users/
 func (ap *WorkerAuthProvider) AuthenticateUser(client *Client, authSig string, userData string) AuthResult {
-	if ap.secret == "" {
+	if ap.keys.Primary().Secret == "" {
 		ap.logger.Warn("Auth: user authentication failed, no secret configured")
 		return AuthResult{Allowed: false}
 	}
+	if authSig == "" && ap.worker != nil && ap.userPath != "" {
+		return ap.execute(client, func() (AuthResult, error) {
+			return ap.doAuthenticateUser(client)
+		})
+	}
 
 	// Format: key:signature
-	parts := strings.Split(authSig, ":")
-	if len(parts) != 2 {
+	key, _, ok := strings.Cut(authSig, ":")
+	if !ok {
 		return AuthResult{Allowed: false}
 	}
-
-	// We ignore the key part (parts[0]) here and validate using our configured secret.
-	if parts[0] != ap.appKey {
+	if !ap.keys.HasKey(key) {
 		ap.logger.Warn("Auth: user signature app key mismatch", zap.String("id", client.ID))
 		return AuthResult{Allowed: false}
 	}
@@ -133,7 +238,7 @@ func (ap *WorkerAuthProvider) AuthenticateUser(client *Client, authSig string, u
 	// String to sign: socket_id + "::user::" + user_data
 	toSign := fmt.Sprintf("%s::user::%s", client.ID, userData)
 
-	if validSignature(ap.secret, parts[1], toSign) {
+	if ap.keys.VerifyAuth("signin", authSig, toSign) {
 		// Valid
 		return AuthResult{Allowed: true, UserData: json.RawMessage(userData)}
 	}
@@ -154,6 +259,41 @@ func (ap *WorkerAuthProvider) Authorize(client *Client, channel string, auth str
 		return AuthResult{Allowed: false}
 	}
 
+	cacheKey, cacheable := "", false
+	if ap.cache != nil {
+		cacheKey, cacheable = ap.cache.key(client, channel)
+		if cacheable {
+			if result, ok := ap.cache.get(cacheKey); ok {
+				if ap.metrics != nil {
+					ap.metrics.AuthCacheLookups.WithLabelValues("hit").Inc()
+				}
+				return result
+			}
+			if ap.metrics != nil {
+				ap.metrics.AuthCacheLookups.WithLabelValues("miss").Inc()
+			}
+		}
+	}
+
+	var result AuthResult
+	if ap.batcher != nil {
+		result = ap.authorizeBatched(client, channel)
+	} else {
+		result = ap.execute(client, func() (AuthResult, error) {
+			return ap.doAuthorize(client, channel)
+		})
+	}
+
+	// Only answers from the worker are cached, not dispatch failures.
+	if cacheable && !result.Unavailable {
+		ap.cache.put(cacheKey, client, channel, result)
+	}
+	return result
+}
+
+// execute runs a worker call under the concurrency limit and circuit breaker.
+// Calls that could not get an answer from the worker are marked Unavailable.
+func (ap *WorkerAuthProvider) execute(client *Client, call func() (AuthResult, error)) AuthResult {
 	select {
 	case ap.sem <- struct{}{}:
 		defer func() { <-ap.sem }()
@@ -162,23 +302,16 @@ func (ap *WorkerAuthProvider) Authorize(client *Client, channel string, auth str
 			ap.metrics.AuthFailures.WithLabelValues("concurrency_limit").Inc()
 		}
 		ap.logger.Warn("Auth: concurrency limit reached", zap.String("id", client.ID))
-		return AuthResult{Allowed: false}
+		return AuthResult{Allowed: false, Unavailable: true}
 	}
 
-	result, err := ap.breaker.Execute(func() (AuthResult, error) { // Change return type to AuthResult
-		return ap.doAuthorize(client, channel)
-	})
-
+	result, err := ap.breaker.Execute(call)
 	if err != nil {
-		switch err {
-		case gobreaker.ErrOpenState:
-			ap.metrics.BreakerTripped.Inc()
-		case gobreaker.ErrTooManyRequests:
+		if ap.metrics != nil && (err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests) {
 			ap.metrics.BreakerTripped.Inc()
 		}
-		return result
+		result.Unavailable = true
 	}
-
 	return result
 }
 
@@ -207,19 +340,43 @@ func (ap *WorkerAuthProvider) authorizeProvidedSignature(client *Client, channel
 }
 
 func (ap *WorkerAuthProvider) doAuthorize(client *Client, channel string) (AuthResult, error) {
-	start := time.Now()
-	defer func() { ap.metrics.AuthDuration.Observe(time.Since(start).Seconds()) }()
-
-	bodyData := map[string]string{
+	body, err := ap.dispatch(client, ap.authPath, channel, map[string]string{
 		"channel_name": channel,
 		"socket_id":    client.ID,
+	})
+	if body == nil {
+		return AuthResult{Allowed: false}, err
+	}
+	return ap.validateWorkerAuthResponse(client, channel, body), nil
+}
+
+func (ap *WorkerAuthProvider) doAuthenticateUser(client *Client) (AuthResult, error) {
+	body, err := ap.dispatch(client, ap.userPath, "", map[string]string{
+		"socket_id": client.ID,
+	})
+	if body == nil {
+		return AuthResult{Allowed: false}, err
 	}
-	jsonBytes, _ := json.Marshal(bodyData)
+	return ap.validateWorkerUserResponse(client, body), nil
+}
+
+// dispatch posts payload to the worker with the client's handshake headers and
+// returns the body of a 200 answer. A nil body is a denial, or a failure that
+// counts against the circuit breaker when err is set.
+func (ap *WorkerAuthProvider) dispatch(client *Client, path string, channel string, payload any) ([]byte, error) {
+	start := time.Now()
+	defer func() {
+		if ap.metrics != nil {
+			ap.metrics.AuthDuration.Observe(time.Since(start).Seconds())
+		}
+	}()
 
-	req, err := http.NewRequest("POST", ap.authPath, bytes.NewBuffer(jsonBytes))
+	jsonBytes, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest("POST", path, bytes.NewBuffer(jsonBytes))
 	if err != nil {
 		ap.logger.Error("Auth: failed to create request", zap.Error(err))
-		return AuthResult{Allowed: false}, nil
+		return nil, nil
 	}
 
 	for k, v := range client.Headers {
@@ -229,7 +386,7 @@ func (ap *WorkerAuthProvider) doAuthorize(client *Client, channel string) (AuthR
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("X-FrankenPHP-WS-Channel", channel)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), ap.timeout)
 	defer cancel()
 
 	rr := capturerPool.Get().(*responseCapturer)
@@ -247,38 +404,42 @@ func (ap *WorkerAuthProvider) doAuthorize(client *Client, channel string) (AuthR
 
 	if ap.worker == nil {
 		ap.logger.Error("Auth: worker not initialized")
-		return AuthResult{Allowed: false}, errors.New("worker not initialized")
+		return nil, errors.New("worker not initialized")
 	}
 
 	err = ap.worker.SendRequest(rr, req.WithContext(ctx))
 
 	if err != nil {
-		ap.metrics.AuthFailures.WithLabelValues("dispatch_error").Inc()
+		if ap.metrics != nil {
+			ap.metrics.AuthFailures.WithLabelValues("dispatch_error").Inc()
+		}
 		ap.logger.Error("Auth: worker dispatch failed", zap.Error(err))
-		return AuthResult{Allowed: false}, err
+		return nil, err
 	}
 
 	if rr.overflow {
-		ap.metrics.AuthFailures.WithLabelValues("body_overflow").Inc()
+		if ap.metrics != nil {
+			ap.metrics.AuthFailures.WithLabelValues("body_overflow").Inc()
+		}
 		ap.logger.Warn("Auth: response body too large")
-		return AuthResult{Allowed: false}, nil
+		return nil, nil
 	}
 
 	if rr.status >= 500 {
-		ap.metrics.AuthFailures.WithLabelValues("worker_error").Inc()
+		if ap.metrics != nil {
+			ap.metrics.AuthFailures.WithLabelValues("worker_error").Inc()
+		}
 		ap.logger.Warn("Auth: worker error", zap.Int("status", rr.status))
-		return AuthResult{Allowed: false}, errors.New("worker 500")
+		return nil, errors.New("worker 500")
 	}
 
 	if rr.status != 200 {
-		return AuthResult{Allowed: false}, nil
+		return nil, nil
 	}
 
 	body := make([]byte, rr.body.Len())
 	copy(body, rr.body.Bytes())
-
-	result := ap.validateWorkerAuthResponse(client, channel, body)
-	return result, nil
+	return body, nil
 }
 
 func (ap *WorkerAuthProvider) validateWorkerAuthResponse(client *Client, channel string, body []byte) AuthResult {
@@ -318,12 +479,42 @@ func (ap *WorkerAuthProvider) validateWorkerAuthResponse(client *Client, channel
 	return AuthResult{Allowed: true, UserData: body}
 }
 
-func (ap *WorkerAuthProvider) validateChannelSignature(socketID, channel, auth, channelData string) bool {
-	parts := strings.Split(auth, ":")
-	if len(parts) != 2 || parts[0] != ap.appKey {
-		return false
+type userAuthResponse struct {
+	Auth     string `json:"auth"`
+	UserData string `json:"user_data"`
+}
+
+func (ap *WorkerAuthProvider) validateWorkerUserResponse(client *Client, body []byte) AuthResult {
+	var response userAuthResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		if ap.metrics != nil {
+			ap.metrics.AuthFailures.WithLabelValues("invalid_response_json").Inc()
+		}
+		ap.logger.Warn("Auth: worker returned invalid user auth JSON", zap.String("id", client.ID), zap.Error(err))
+		return AuthResult{Allowed: false}
+	}
+
+	if response.Auth == "" || response.UserData == "" {
+		if ap.metrics != nil {
+			ap.metrics.AuthFailures.WithLabelValues("missing_signature").Inc()
+		}
+		ap.logger.Warn("Auth: worker user auth response missing auth or user_data", zap.String("id", client.ID))
+		return AuthResult{Allowed: false}
+	}
+
+	if !ap.keys.VerifyAuth("signin", response.Auth, fmt.Sprintf("%s::user::%s", client.ID, response.UserData)) {
+		if ap.metrics != nil {
+			ap.metrics.AuthFailures.WithLabelValues("invalid_signature").Inc()
+		}
+		ap.logger.Warn("Auth: worker user auth signature mismatch", zap.String("id", client.ID))
+		return AuthResult{Allowed: false}
 	}
-	return validSignature(ap.secret, parts[1], channelStringToSign(socketID, channel, channelData))
+
+	return AuthResult{Allowed: true, UserData: json.RawMessage(response.UserData)}
+}
+
+func (ap *WorkerAuthProvider) validateChannelSignature(socketID, channel, auth, channelData string) bool {
+	return ap.keys.VerifyAuth("channel", auth, channelStringToSign(socketID, channel, channelData))
 }
 
 func channelStringToSign(socketID, channel, channelData string) string {
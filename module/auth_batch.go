@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAuthBatchWindow = 5 * time.Millisecond
+	DefaultAuthBatchSize   = 10
+)
+
+// AuthBatchConfig coalesces the worker authorizations a connection asks for
+// within Window into one request to Path, of at most MaxSize channels.
+type AuthBatchConfig struct {
+	Path    string
+	Window  time.Duration
+	MaxSize int
+}
+
+// AuthBatcher is implemented by auth providers that coalesce concurrent
+// Authorize calls of a connection, so clients should not wait for one
+// subscription to be authorized before reading the next.
+type AuthBatcher interface {
+	BatchesAuthorization() bool
+}
+
+type authBatch struct {
+	client   *Client
+	channels []string
+	results  map[string]AuthResult
+	flushed  bool
+	done     chan struct{}
+}
+
+type authBatcher struct {
+	config AuthBatchConfig
+
+	mu      sync.Mutex
+	pending map[*Client]*authBatch
+}
+
+// EnableBatching coalesces worker authorizations per connection. Call it
+// before the provider is used.
+func (ap *WorkerAuthProvider) EnableBatching(config AuthBatchConfig) {
+	if config.Window <= 0 {
+		config.Window = DefaultAuthBatchWindow
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultAuthBatchSize
+	}
+	ap.batcher = &authBatcher{config: config, pending: make(map[*Client]*authBatch)}
+}
+
+func (ap *WorkerAuthProvider) BatchesAuthorization() bool {
+	return ap.batcher != nil && ap.worker != nil
+}
+
+// AuthBatchLimit is the most channels one batch carries; a connection keeps at
+// most that many authorizations pending.
+func (ap *WorkerAuthProvider) AuthBatchLimit() int {
+	if ap.batcher == nil {
+		return DefaultAuthBatchSize
+	}
+	return ap.batcher.config.MaxSize
+}
+
+// authorizeBatched joins the connection's open batch, or opens one that is
+// sent when the window ends or it is full, and waits for its answer.
+func (ap *WorkerAuthProvider) authorizeBatched(client *Client, channel string) AuthResult {
+	b := ap.batcher
+	b.mu.Lock()
+	batch, ok := b.pending[client]
+	if !ok {
+		batch = &authBatch{client: client, done: make(chan struct{})}
+		b.pending[client] = batch
+		time.AfterFunc(b.config.Window, func() { ap.flushBatch(batch) })
+	}
+	if !slices.Contains(batch.channels, channel) {
+		batch.channels = append(batch.channels, channel)
+	}
+	full := len(batch.channels) >= b.config.MaxSize
+	b.mu.Unlock()
+
+	if full {
+		ap.flushBatch(batch)
+	}
+	<-batch.done
+	return batch.results[channel]
+}
+
+func (ap *WorkerAuthProvider) flushBatch(batch *authBatch) {
+	b := ap.batcher
+	b.mu.Lock()
+	if batch.flushed {
+		b.mu.Unlock()
+		return
+	}
+	batch.flushed = true
+	if b.pending[batch.client] == batch {
+		delete(b.pending, batch.client)
+	}
+	b.mu.Unlock()
+
+	defer close(batch.done)
+	if ap.metrics != nil {
+		ap.metrics.AuthBatchSize.Observe(float64(len(batch.channels)))
+	}
+
+	// A lone channel goes to the regular auth endpoint.
+	if len(batch.channels) == 1 {
+		channel := batch.channels[0]
+		batch.results = map[string]AuthResult{channel: ap.execute(batch.client, func() (AuthResult, error) {
+			return ap.doAuthorize(batch.client, channel)
+		})}
+		return
+	}
+
+	var results map[string]AuthResult
+	outcome := ap.execute(batch.client, func() (AuthResult, error) {
+		var err error
+		results, err = ap.doAuthorizeBatch(batch.client, batch.channels)
+		return AuthResult{Allowed: results != nil}, err
+	})
+	if results == nil || outcome.Unavailable {
+		results = make(map[string]AuthResult, len(batch.channels))
+		for _, channel := range batch.channels {
+			results[channel] = AuthResult{Unavailable: outcome.Unavailable}
+		}
+	}
+	batch.results = results
+}
+
+// doAuthorizeBatch posts {"socket_id", "channel_name": [...]} and expects an
+// object with the usual {"auth", "channel_data"} answer per allowed channel.
+// Channels missing from the answer, or null, are denied.
+func (ap *WorkerAuthProvider) doAuthorizeBatch(client *Client, channels []string) (map[string]AuthResult, error) {
+	body, err := ap.dispatch(client, ap.batcher.config.Path, "", map[string]any{
+		"channel_name": channels,
+		"socket_id":    client.ID,
+	})
+	if body == nil {
+		return nil, err
+	}
+
+	var answers map[string]json.RawMessage
+	if err := json.Unmarshal(body, &answers); err != nil {
+		if ap.metrics != nil {
+			ap.metrics.AuthFailures.WithLabelValues("invalid_response_json").Inc()
+		}
+		return nil, nil
+	}
+	results := make(map[string]AuthResult, len(channels))
+	for _, channel := range channels {
+		answer, ok := answers[channel]
+		if !ok || string(answer) == "null" {
+			results[channel] = AuthResult{Allowed: false}
+			continue
+		}
+		results[channel] = ap.validateWorkerAuthResponse(client, channel, answer)
+	}
+	return results, nil
+}
@@ -0,0 +1,167 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// batchWorker answers batch requests with a signature per channel, leaving
+// private-forbidden out, and single requests like the regular endpoint.
+type batchWorker struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (w *batchWorker) SendRequest(rw http.ResponseWriter, r *http.Request) error {
+	var payload struct {
+		SocketID    string          `json:"socket_id"`
+		ChannelName json.RawMessage `json:"channel_name"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+	w.mu.Lock()
+	w.requests = append(w.requests, r.URL.Path)
+	w.mu.Unlock()
+
+	sign := func(channel string) channelAuthResponse {
+		return channelAuthResponse{Auth: "test-key:" + hmacHex("secret", channelStringToSign(payload.SocketID, channel, ""))}
+	}
+	var channels []string
+	if err := json.Unmarshal(payload.ChannelName, &channels); err != nil {
+		var channel string
+		_ = json.Unmarshal(payload.ChannelName, &channel)
+		return json.NewEncoder(rw).Encode(sign(channel))
+	}
+	answers := map[string]*channelAuthResponse{}
+	for _, channel := range channels {
+		if channel != "private-forbidden" {
+			answer := sign(channel)
+			answers[channel] = &answer
+		}
+	}
+	return json.NewEncoder(rw).Encode(answers)
+}
+
+func (w *batchWorker) paths() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.requests...)
+}
+
+func TestWorkerAuthProviderBatchesConcurrentAuthorizations(t *testing.T) {
+	worker := &batchWorker{}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	auth := NewWorkerAuthProvider(zap.NewNop(), metrics, worker, "test-key", "/auth", 4096, 1, "secret")
+	auth.EnableBatching(AuthBatchConfig{Path: "/auth/batch", Window: 20 * time.Millisecond})
+	client := &Client{ID: "1.1", Headers: make(http.Header)}
+
+	channels := []string{"private-a", "private-b", "private-forbidden"}
+	results := make([]AuthResult, len(channels))
+	var wg sync.WaitGroup
+	for i, channel := range channels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = auth.Authorize(client, channel, "", "")
+		}()
+	}
+	wg.Wait()
+
+	if paths := worker.paths(); len(paths) != 1 || paths[0] != "/auth/batch" {
+		t.Fatalf("worker requests = %v, want one batch request", paths)
+	}
+	if !results[0].Allowed || !results[1].Allowed {
+		t.Fatalf("results = %+v, want private-a and private-b allowed", results)
+	}
+	if results[2].Allowed || results[2].Unavailable {
+		t.Fatalf("private-forbidden result = %+v, want denied", results[2])
+	}
+
+	if res := auth.Authorize(client, "private-c", "", ""); !res.Allowed {
+		t.Fatal("Expected a lone authorization to succeed")
+	}
+	if paths := worker.paths(); paths[len(paths)-1] != "/auth" {
+		t.Fatalf("lone authorization went to %s, want the regular endpoint", paths[len(paths)-1])
+	}
+}
+
+func TestClientBatchesSubscriptionsAndKeepsOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker := &batchWorker{}
+	auth := NewWorkerAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), worker, "test-key", "/auth", 4096, 10, "secret")
+	auth.EnableBatching(AuthBatchConfig{Path: "/auth/batch", Window: 20 * time.Millisecond})
+	hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), auth, nil, &MockBroker{}, 100, 1, DefaultPingPeriod, DefaultDeliveryConfig())
+	go hub.Run()
+
+	client := &Client{ID: "1.1", hub: hub, conn: NewMockWSConnection(), send: make(chan any, 16), Headers: make(http.Header)}
+	hub.Register(client)
+	defer hub.Unregister(client)
+	<-client.send
+
+	client.handleMessage([]byte(`{"event":"pusher:subscribe","data":{"channel":"private-a"}}`))
+	client.handleMessage([]byte(`{"event":"pusher:subscribe","data":{"channel":"private-b"}}`))
+	client.waitAuthorizations()
+	if paths := worker.paths(); len(paths) != 1 || paths[0] != "/auth/batch" {
+		t.Fatalf("worker requests = %v, want both subscriptions in one batch", paths)
+	}
+
+	// Work queued behind a pending authorization applies after it.
+	var applied []string
+	release := make(chan struct{})
+	client.authorizeAsync(func() func() {
+		<-release
+		return func() { applied = append(applied, "subscribe") }
+	})
+	client.inOrder(func() func() {
+		return func() { applied = append(applied, "unsubscribe") }
+	})
+	close(release)
+	client.waitAuthorizations()
+	if strings.Join(applied, ",") != "subscribe,unsubscribe" {
+		t.Fatalf("applied = %v, want subscribe before unsubscribe", applied)
+	}
+}
+
+func TestClientCapsPendingAuthorizations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	auth := NewWorkerAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), &batchWorker{}, "test-key", "/auth", 4096, 10, "secret")
+	auth.EnableBatching(AuthBatchConfig{Path: "/auth/batch", MaxSize: 2})
+	hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), auth, nil, &MockBroker{}, 100, 1, DefaultPingPeriod, DefaultDeliveryConfig())
+	client := &Client{ID: "1.1", hub: hub, conn: NewMockWSConnection(), send: make(chan any, 16), Headers: make(http.Header)}
+
+	release := make(chan struct{})
+	pending := func() func() {
+		<-release
+		return func() {}
+	}
+	client.authorizeAsync(pending)
+	client.authorizeAsync(pending)
+
+	third := make(chan struct{})
+	go func() {
+		client.authorizeAsync(pending)
+		close(third)
+	}()
+	select {
+	case <-third:
+		t.Fatal("Expected the read loop to wait while a batch worth of authorizations is pending")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-third:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the read loop to resume once authorizations applied")
+	}
+	client.waitAuthorizations()
+}
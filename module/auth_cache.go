@@ -0,0 +1,201 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAuthCacheNegativeTTL = 5 * time.Second
+	maxAuthCacheEntries         = 100000
+)
+
+// Sources for the identity half of an auth cache key.
+const (
+	AuthCacheIdentityHeaders = "headers"
+	AuthCacheIdentityHeader  = "header"
+	AuthCacheIdentityCookie  = "cookie"
+	AuthCacheIdentityUser    = "user"
+)
+
+// AuthCacheConfig enables caching of auth worker answers. Identity defaults to
+// the Cookie and Authorization headers the worker sees; Name selects the header
+// or cookie for the header and cookie sources.
+type AuthCacheConfig struct {
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	Identity    string
+	Name        string
+}
+
+// AuthCacheInvalidator is implemented by auth providers that cache results.
+// Empty arguments match every entry. An entry only carries a user ID when the
+// client had signed in or joined a presence channel, so with the headers,
+// header, and cookie identities a user's private-channel entries are reached
+// by channel, not by user ID.
+type AuthCacheInvalidator interface {
+	InvalidateAuthCache(channel, userID string) int
+}
+
+type authCacheEntry struct {
+	result  AuthResult
+	channel string
+	userID  string
+	expires time.Time
+}
+
+type authResultCache struct {
+	config AuthCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]authCacheEntry
+}
+
+func newAuthResultCache(config AuthCacheConfig) *authResultCache {
+	if config.Identity == "" {
+		config.Identity = AuthCacheIdentityHeaders
+	}
+	if config.NegativeTTL == 0 {
+		config.NegativeTTL = min(DefaultAuthCacheNegativeTTL, config.TTL)
+	}
+	return &authResultCache{config: config, entries: make(map[string]authCacheEntry)}
+}
+
+// key returns the cache key for a client and channel. Clients without an
+// identity are never cached, so anonymous connections cannot share answers.
+func (c *authResultCache) key(client *Client, channel string) (string, bool) {
+	var identity string
+	switch c.config.Identity {
+	case AuthCacheIdentityHeader:
+		identity = client.Headers.Get(c.config.Name)
+	case AuthCacheIdentityCookie:
+		if cookie, err := (&http.Request{Header: client.Headers}).Cookie(c.config.Name); err == nil {
+			identity = cookie.Value
+		}
+	case AuthCacheIdentityUser:
+		identity = client.UserID()
+	default:
+		cookie, authorization := client.Headers.Get("Cookie"), client.Headers.Get("Authorization")
+		if cookie != "" || authorization != "" {
+			identity = cookie + "\x00" + authorization
+		}
+	}
+	if identity == "" {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(c.config.Identity + "\x00" + identity + "\x00" + channel))
+	return hex.EncodeToString(sum[:]), true
+}
+
+func (c *authResultCache) get(key string) (AuthResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return AuthResult{}, false
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(c.entries, key)
+		return AuthResult{}, false
+	}
+	return entry.result, true
+}
+
+func (c *authResultCache) put(key string, client *Client, channel string, result AuthResult) {
+	ttl := c.config.TTL
+	if !result.Allowed {
+		ttl = c.config.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	// Unset for private channels of clients that have not signed in.
+	userID := client.UserID()
+	if presenceUser := presenceUserID(result.UserData); presenceUser != "" {
+		userID = presenceUser
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxAuthCacheEntries {
+		c.sweepLocked(now)
+	}
+	c.entries[key] = authCacheEntry{result: result, channel: channel, userID: userID, expires: now.Add(ttl)}
+}
+
+func (c *authResultCache) invalidate(channel, userID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, entry := range c.entries {
+		if (channel == "" || entry.channel == channel) && (userID == "" || entry.userID == userID) {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *authResultCache) sweepLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	// Still full: drop arbitrary entries, they are only a cache.
+	for key := range c.entries {
+		if len(c.entries) < maxAuthCacheEntries {
+			break
+		}
+		delete(c.entries, key)
+	}
+}
+
+// presenceUserID reads the member user ID from a presence auth response.
+func presenceUserID(userData json.RawMessage) string {
+	var response PresenceAuthResponse
+	if err := json.Unmarshal(userData, &response); err != nil || response.ChannelData == "" {
+		return ""
+	}
+	var data PresenceChannelData
+	if err := json.Unmarshal([]byte(response.ChannelData), &data); err != nil {
+		return ""
+	}
+	userID := strings.Trim(string(data.UserID), `"`)
+	if userID == "null" {
+		return ""
+	}
+	return userID
+}
+
+// invalidateAuthCache clears cached auth results on the hub's auth provider.
+func (h *Hub) invalidateAuthCache(channel, userID string) int {
+	invalidator, ok := h.auth.(AuthCacheInvalidator)
+	if !ok {
+		return 0
+	}
+	return invalidator.InvalidateAuthCache(channel, userID)
+}
+
+// invalidateAuthCacheOnHubs clears cached auth results on every cluster node
+// and reports how many were removed, and whether a node did not answer.
+func invalidateAuthCacheOnHubs(ctx context.Context, hubs []*Hub, channel, userID string) (int, bool) {
+	snapshots, partial := collectClusterSnapshots(ctx, hubs, ClusterQuery{
+		Kind:    ClusterInvalidateAuth,
+		Channel: channel,
+		UserID:  userID,
+	})
+	invalidated := 0
+	for _, snapshot := range snapshots {
+		invalidated += snapshot.Invalidated
+	}
+	return invalidated, partial
+}
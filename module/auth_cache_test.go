@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func newCachedWorkerAuth(worker *MockWorker, config AuthCacheConfig) *WorkerAuthProvider {
+	auth := NewWorkerAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), worker, "test-key", "/auth", 1024, 100, "secret")
+	auth.EnableResultCache(config)
+	return auth
+}
+
+func TestWorkerAuthCachesResultsPerIdentity(t *testing.T) {
+	worker := &MockWorker{}
+	auth := newCachedWorkerAuth(worker, AuthCacheConfig{TTL: time.Minute})
+
+	alice := &Client{ID: "1.1", Headers: http.Header{"Cookie": {"session=alice"}}}
+	for _, id := range []string{"1.1", "1.2"} {
+		alice.ID = id
+		if res := auth.Authorize(alice, "presence-room", "", ""); !res.Allowed {
+			t.Fatalf("socket %s: expected presence auth to be allowed", id)
+		}
+	}
+	if calls := worker.GetCalls(); calls != 1 {
+		t.Fatalf("worker calls = %d, want 1 for a reconnect with the same cookie", calls)
+	}
+	if got := counterValue(t, auth.metrics.AuthCacheLookups.WithLabelValues("hit")); got != 1 {
+		t.Fatalf("cache hits = %v, want 1", got)
+	}
+
+	bob := &Client{ID: "2.1", Headers: http.Header{"Cookie": {"session=bob"}}}
+	auth.Authorize(bob, "presence-room", "", "")
+	anonymous := &Client{ID: "3.1"}
+	auth.Authorize(anonymous, "presence-room", "", "")
+	auth.Authorize(anonymous, "presence-room", "", "")
+	if calls := worker.GetCalls(); calls != 4 {
+		t.Fatalf("worker calls = %d, want 4: other identities and anonymous clients are not served from the cache", calls)
+	}
+
+	if removed := auth.InvalidateAuthCache("", "1"); removed != 2 {
+		t.Fatalf("invalidated = %d, want both entries of presence user 1", removed)
+	}
+	auth.Authorize(alice, "presence-room", "", "")
+	if calls := worker.GetCalls(); calls != 5 {
+		t.Fatalf("worker calls after invalidation = %d, want 5", calls)
+	}
+}
+
+func TestWorkerAuthCacheWithoutMetricsOrSignin(t *testing.T) {
+	worker := &MockWorker{}
+	auth := NewWorkerAuthProvider(zap.NewNop(), nil, worker, "test-key", "/auth", 1024, 100, "secret")
+	auth.EnableResultCache(AuthCacheConfig{TTL: time.Minute})
+
+	client := &Client{ID: "1.1", Headers: http.Header{"Cookie": {"session=alice"}}}
+	for range 2 {
+		if res := auth.Authorize(client, "private-orders", "", ""); !res.Allowed {
+			t.Fatal("Expected private auth to be allowed")
+		}
+	}
+	if calls := worker.GetCalls(); calls != 1 {
+		t.Fatalf("worker calls = %d, want the second answer from the cache", calls)
+	}
+
+	// The client never signed in, so its private entry has no user to match.
+	if removed := auth.InvalidateAuthCache("", "1"); removed != 0 {
+		t.Fatalf("invalidated by user = %d, want 0 for a client that did not sign in", removed)
+	}
+	if removed := auth.InvalidateAuthCache("private-orders", ""); removed != 1 {
+		t.Fatalf("invalidated by channel = %d, want 1", removed)
+	}
+}
+
+func TestWorkerAuthCachesDenialsBriefly(t *testing.T) {
+	worker := &MockWorker{Secret: "other-secret"}
+	auth := newCachedWorkerAuth(worker, AuthCacheConfig{TTL: time.Minute, NegativeTTL: 50 * time.Millisecond, Identity: AuthCacheIdentityHeader, Name: "Authorization"})
+	client := &Client{ID: "1.1", Headers: http.Header{"Authorization": {"Bearer abc"}}}
+
+	for range 2 {
+		if res := auth.Authorize(client, "private-room", "", ""); res.Allowed {
+			t.Fatal("Expected worker answer with a bad signature to be denied")
+		}
+	}
+	if calls := worker.GetCalls(); calls != 1 {
+		t.Fatalf("worker calls = %d, want the denial cached", calls)
+	}
+	time.Sleep(60 * time.Millisecond)
+	auth.Authorize(client, "private-room", "", "")
+	if calls := worker.GetCalls(); calls != 2 {
+		t.Fatalf("worker calls after negative TTL = %d, want 2", calls)
+	}
+
+	// Dispatch failures are not answers and are never cached.
+	worker.SetFail(true)
+	auth.Authorize(client, "private-other", "", "")
+	worker.SetFail(false)
+	worker.Secret = "secret"
+	if res := auth.Authorize(client, "private-other", "", ""); !res.Allowed {
+		t.Fatal("Expected retry after a worker failure to reach the worker")
+	}
+}
+
+func TestPusherHTTPAPIInvalidatesAuthCache(t *testing.T) {
+	module, _, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+
+	worker := &MockWorker{}
+	auth := newCachedWorkerAuth(worker, AuthCacheConfig{TTL: time.Minute})
+	module.hub.auth = auth
+	client := &Client{ID: "1.1", Headers: http.Header{"Cookie": {"session=alice"}}}
+	auth.Authorize(client, "private-a", "", "")
+	auth.Authorize(client, "private-b", "", "")
+
+	rr := performSignedPusherRequest(t, module, http.MethodDelete, "/apps/test-app/auth_cache?channel=private-a", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("invalidate status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Invalidated int `json:"invalidated"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode invalidate response: %v", err)
+	}
+	if response.Invalidated != 1 {
+		t.Fatalf("invalidated = %d, want 1", response.Invalidated)
+	}
+
+	auth.Authorize(client, "private-a", "", "")
+	auth.Authorize(client, "private-b", "", "")
+	if calls := worker.GetCalls(); calls != 3 {
+		t.Fatalf("worker calls = %d, want only private-a to be re-authorized", calls)
+	}
+}
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -14,7 +15,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/y-l-g/websocket/module/internal/protocol"
 	"go.uber.org/zap"
 )
 
@@ -74,6 +77,35 @@ func TestAuthorizeWithoutSignatureRequiresWorker(t *testing.T) {
 	}
 }
 
+func TestAuthenticateUserWithoutSignatureAsksWorker(t *testing.T) {
+	worker := &MockWorker{}
+	auth := NewWorkerAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), worker, "test-key", "/auth", 1024, 100, "secret")
+	client := &Client{ID: "1.1"}
+
+	if res := auth.AuthenticateUser(client, "", ""); res.Allowed || worker.GetCalls() != 0 {
+		t.Fatal("Expected unsigned signin to be denied without asking the worker when user auth is off")
+	}
+
+	auth.EnableUserAuth(DefaultUserAuthPath)
+	res := auth.AuthenticateUser(client, "", "")
+	if !res.Allowed || signedInUserID(res.UserData) != "1" {
+		t.Fatalf("worker signin = %+v, want user 1", res)
+	}
+
+	worker.Secret = "other-secret"
+	if res := auth.AuthenticateUser(client, "", ""); res.Allowed || res.Unavailable {
+		t.Fatal("Expected a worker answer with a bad signature to be denied")
+	}
+
+	worker.SetFail(true)
+	for range CBThreshold {
+		auth.AuthenticateUser(client, "", "")
+	}
+	if res := auth.Authorize(client, "private-orders", "", ""); !res.Unavailable {
+		t.Fatal("Expected signin failures to open the breaker shared with channel auth")
+	}
+}
+
 func (m *MockWorker) SendRequest(w http.ResponseWriter, r *http.Request) error {
 	if m.Delay > 0 {
 		time.Sleep(m.Delay)
@@ -101,6 +133,15 @@ func (m *MockWorker) SendRequest(w http.ResponseWriter, r *http.Request) error {
 	socketID := payload["socket_id"]
 	channel := payload["channel_name"]
 	response := map[string]string{}
+	if channel == "" {
+		// User authentication, as for Laravel's /broadcasting/user-auth.
+		userData := `{"id":"1","user_info":{"name":"Test User"}}`
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(socketID + "::user::" + userData))
+		response["auth"] = appKey + ":" + hex.EncodeToString(mac.Sum(nil))
+		response["user_data"] = userData
+		return json.NewEncoder(w).Encode(response)
+	}
 	channelData := ""
 	if strings.HasPrefix(channel, "presence-") {
 		channelData = `{"user_id":"1","user_info":{"name":"Test User"}}`
@@ -171,6 +212,71 @@ func TestCircuitBreakerIntegration(t *testing.T) {
 	}
 }
 
+func TestCircuitBreakerFailureRatioAndHalfOpenProbes(t *testing.T) {
+	metrics := NewMetrics(prometheus.NewRegistry())
+	worker := &MockWorker{}
+	auth := NewWorkerAuthProvider(zap.NewNop(), metrics, worker, "test-key", "/auth", 1024, 100, "secret")
+	auth.SetBreakerConfig(BreakerConfig{FailureRatio: 0.5, MinRequests: 4, HalfOpenRequests: 2, ResetTimeout: 50 * time.Millisecond})
+	client := &Client{ID: "1.1", Headers: make(http.Header)}
+
+	for _, fail := range []bool{false, false, true, true} {
+		worker.SetFail(fail)
+		auth.Authorize(client, "private-a", "", "")
+	}
+	if got := gaugeValue(t, metrics.AuthBreakerState); got != 2 {
+		t.Fatalf("breaker state = %v after half the requests failed, want open (2)", got)
+	}
+
+	worker.Reset()
+	if res := auth.Authorize(client, "private-a", "", ""); res.Allowed || !res.Unavailable || worker.GetCalls() != 0 {
+		t.Fatalf("open breaker result = %+v with %d worker calls", res, worker.GetCalls())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	for range 2 {
+		if res := auth.Authorize(client, "private-a", "", ""); !res.Allowed {
+			t.Fatal("Expected half-open probes to reach the worker")
+		}
+	}
+	if got := gaugeValue(t, metrics.AuthBreakerState); got != 0 {
+		t.Fatalf("breaker state = %v after successful probes, want closed (0)", got)
+	}
+}
+
+type unavailableAuth struct{ MockAuthProvider }
+
+func (a *unavailableAuth) Authorize(client *Client, channel string, auth string, channelData string) AuthResult {
+	return AuthResult{Unavailable: true}
+}
+
+func TestClientGetsRetriableErrorWhenAuthUnavailable(t *testing.T) {
+	for _, retry := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		delivery := DefaultDeliveryConfig()
+		delivery.RetryUnavailableAuth = retry
+		hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), &unavailableAuth{}, nil, &MockBroker{}, 100, 1, DefaultPingPeriod, delivery)
+		conn := NewMockWSConnection()
+		client := &Client{ID: "1.1", hub: hub, conn: conn, send: make(chan any, 4)}
+
+		client.handleMessage([]byte(`{"event":"pusher:subscribe","data":{"channel":"private-a","auth":"x"}}`))
+		messages := drainSent(client)
+		if !retry {
+			if len(messages) != 1 || !strings.Contains(messages[0], `"code":4009`) || conn.CloseCalled {
+				t.Fatalf("client received %v (closed=%v), want a 4009 error on an open connection", messages, conn.CloseCalled)
+			}
+			cancel()
+			continue
+		}
+
+		// pusher-js only retries on a close code, so the connection is closed with 4101.
+		want := "[Control:" + string(websocket.FormatCloseMessage(protocol.ErrorAuthUnavailable, "Authorization unavailable, retry later")) + "]"
+		if len(messages) != 0 || !conn.CloseCalled || len(conn.WriteMsgs) != 1 || conn.WriteMsgs[0] != want {
+			t.Fatalf("client received %v, writes %q (closed=%v), want a 4101 close", messages, conn.WriteMsgs, conn.CloseCalled)
+		}
+		cancel()
+	}
+}
+
 func TestAuthConcurrencyLimit(t *testing.T) {
 	logger := zap.NewNop()
 	metrics := NewMetrics(prometheus.NewRegistry())
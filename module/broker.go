@@ -19,6 +19,25 @@ type Broker interface {
 	Close() error
 }
 
+type BrokerState string
+
+const (
+	BrokerConnecting   BrokerState = "connecting"
+	BrokerConnected    BrokerState = "connected"
+	BrokerReconnecting BrokerState = "reconnecting"
+)
+
+// StatefulBroker is implemented by brokers that report connectivity changes.
+// The handler is set before Subscribe and may be called from any goroutine.
+type StatefulBroker interface {
+	SetStateHandler(handler func(BrokerState))
+}
+
+// PeerHealthReporter is implemented by brokers that hold direct links to other nodes.
+type PeerHealthReporter interface {
+	PeerHealth() []PeerHealth
+}
+
 // MemoryBroker implements a simple in-process event bus.
 type MemoryBroker struct {
 	bus    chan *BroadcastMessage
@@ -85,7 +104,12 @@ func SerializeBroadcast(msg *BroadcastMessage) ([]byte, error) {
 	return json.Marshal(msg)
 }
 
+// DeserializeBroadcast accepts both JSON messages and binary envelopes, so nodes
+// can switch formats during a rolling deploy.
 func DeserializeBroadcast(data []byte) (*BroadcastMessage, error) {
+	if isBroadcastEnvelope(data) {
+		return decodeBroadcastEnvelope(data)
+	}
 	var msg BroadcastMessage
 	err := json.Unmarshal(data, &msg)
 	return &msg, err
@@ -21,33 +21,76 @@ func init() {
 }
 
 type WebsocketModule struct {
-	AppID              string   `json:"app_id,omitempty"`
-	AppKey             string   `json:"app_key,omitempty"`
-	AppSecret          string   `json:"app_secret,omitempty"`
-	AuthPath           string   `json:"auth_path,omitempty"`
-	AuthScript         string   `json:"auth_script,omitempty"`
-	NumWorkers         int      `json:"num_workers,omitempty"`
-	MaxConnections     int      `json:"max_connections,omitempty"`
-	MaxAuthBody        int      `json:"max_auth_body,omitempty"`
-	MaxConcurrentAuth  int      `json:"max_concurrent_auth,omitempty"`
-	NumShards          int      `json:"num_shards,omitempty"`
-	HandshakeRate      float64  `json:"handshake_rate,omitempty"`
-	HandshakeBurst     int      `json:"handshake_burst,omitempty"`
-	OutboundQueueSize  int      `json:"outbound_queue_size,omitempty"`
-	BrokerQueueSize    int      `json:"broker_queue_size,omitempty"`
-	ShardQueueSize     int      `json:"shard_queue_size,omitempty"`
-	WriteBurstSize     int      `json:"write_burst_size,omitempty"`
-	ClientMsgRateLimit float64  `json:"client_msg_rate_limit,omitempty"`
-	ClientMsgRateBurst int      `json:"client_msg_rate_burst,omitempty"`
-	EnableCompression  bool     `json:"enable_compression,omitempty"`
-	AllowedOrigins     []string `json:"allowed_origins,omitempty"`
-	WebhookURL         string   `json:"webhook_url,omitempty"`
-	WebhookSecret      string   `json:"webhook_secret,omitempty"`
-	RedisHost          string   `json:"redis_host,omitempty"`
-	RedisPassword      string   `json:"redis_password,omitempty"`
-	RedisDB            int      `json:"redis_db,omitempty"`
-	RedisTLS           bool     `json:"redis_tls,omitempty"`
-	ShutdownTimeout    string   `json:"shutdown_timeout,omitempty"`
+	AppID              string           `json:"app_id,omitempty"`
+	AppKey             string           `json:"app_key,omitempty"`
+	AppSecret          string           `json:"app_secret,omitempty"`
+	AppPrevSecrets     []PreviousSecret `json:"app_previous_secrets,omitempty"`
+	AuthPath           string           `json:"auth_path,omitempty"`
+	UserAuthPath       string           `json:"user_auth_path,omitempty"`
+	AuthBatchPath      string           `json:"auth_batch_path,omitempty"`
+	AuthBatchWindow    string           `json:"auth_batch_window,omitempty"`
+	AuthBatchSize      int              `json:"auth_batch_size,omitempty"`
+	ChannelPolicies    []ChannelRule    `json:"channel_policies,omitempty"`
+	AuthScript         string           `json:"auth_script,omitempty"`
+	AuthURL            string           `json:"auth_url,omitempty"`
+	AuthForwardHeaders []string         `json:"auth_forward_headers,omitempty"`
+	AuthForwardCookies []string         `json:"auth_forward_cookies,omitempty"`
+	AuthTimeout        string           `json:"auth_timeout,omitempty"`
+	AuthConnectTimeout string           `json:"auth_connect_timeout,omitempty"`
+	BreakerThreshold   int              `json:"auth_breaker_threshold,omitempty"`
+	BreakerRatio       float64          `json:"auth_breaker_failure_ratio,omitempty"`
+	BreakerMinRequests int              `json:"auth_breaker_min_requests,omitempty"`
+	BreakerHalfOpen    int              `json:"auth_breaker_half_open_requests,omitempty"`
+	BreakerReset       string           `json:"auth_breaker_reset,omitempty"`
+	BreakerInterval    string           `json:"auth_breaker_interval,omitempty"`
+	AuthRetryable      bool             `json:"auth_retry_unavailable,omitempty"`
+	NumWorkers         int              `json:"num_workers,omitempty"`
+	MaxConnections     int              `json:"max_connections,omitempty"`
+	MaxAuthBody        int              `json:"max_auth_body,omitempty"`
+	MaxConcurrentAuth  int              `json:"max_concurrent_auth,omitempty"`
+	NumShards          int              `json:"num_shards,omitempty"`
+	HandshakeRate      float64          `json:"handshake_rate,omitempty"`
+	HandshakeBurst     int              `json:"handshake_burst,omitempty"`
+	OutboundQueueSize  int              `json:"outbound_queue_size,omitempty"`
+	BrokerQueueSize    int              `json:"broker_queue_size,omitempty"`
+	ShardQueueSize     int              `json:"shard_queue_size,omitempty"`
+	WriteBurstSize     int              `json:"write_burst_size,omitempty"`
+	ClientMsgRateLimit float64          `json:"client_msg_rate_limit,omitempty"`
+	ClientMsgRateBurst int              `json:"client_msg_rate_burst,omitempty"`
+	EnableCompression  bool             `json:"enable_compression,omitempty"`
+	AllowedOrigins     []string         `json:"allowed_origins,omitempty"`
+	WebhookURL         string           `json:"webhook_url,omitempty"`
+	WebhookSecret      string           `json:"webhook_secret,omitempty"`
+	WebhookPrevSecrets []PreviousSecret `json:"webhook_previous_secrets,omitempty"`
+	RedisHost          string           `json:"redis_host,omitempty"`
+	RedisPassword      string           `json:"redis_password,omitempty"`
+	RedisDB            int              `json:"redis_db,omitempty"`
+	RedisTLS           bool             `json:"redis_tls,omitempty"`
+	RedisEnvelope      string           `json:"redis_envelope,omitempty"`
+	RedisCompressAt    int              `json:"redis_compress_threshold,omitempty"`
+	RedisOutboxSize    int              `json:"redis_outbox_size,omitempty"`
+	RedisOutboxMaxAge  string           `json:"redis_outbox_max_age,omitempty"`
+	MeshListen         string           `json:"mesh_listen,omitempty"`
+	MeshPeers          []string         `json:"mesh_peers,omitempty"`
+	MeshDNS            []string         `json:"mesh_dns,omitempty"`
+	MeshSecret         string           `json:"mesh_secret,omitempty"`
+	IngestList         string           `json:"redis_ingest_list,omitempty"`
+	IngestDeadLetter   string           `json:"redis_ingest_dead_letter,omitempty"`
+	ShutdownTimeout    string           `json:"shutdown_timeout,omitempty"`
+	IdempotencyWindow  string           `json:"idempotency_window,omitempty"`
+	JWTSecret          string           `json:"jwt_secret,omitempty"`
+	JWTPublicKeys      []string         `json:"jwt_public_keys,omitempty"`
+	JWTJWKSFile        string           `json:"jwt_jwks_file,omitempty"`
+	JWTIssuer          string           `json:"jwt_issuer,omitempty"`
+	JWTAudience        string           `json:"jwt_audience,omitempty"`
+	AuthCacheTTL       string           `json:"auth_cache_ttl,omitempty"`
+	AuthCacheNegTTL    string           `json:"auth_cache_negative_ttl,omitempty"`
+	AuthCacheIdentity  string           `json:"auth_cache_identity,omitempty"`
+	AuthCacheIDName    string           `json:"auth_cache_identity_name,omitempty"`
+	ReauthInterval     string           `json:"reauth_interval,omitempty"`
+	MaxConnsPerUser    int              `json:"max_connections_per_user,omitempty"`
+	MaxSubsPerConn     int              `json:"max_subscriptions_per_connection,omitempty"`
+	MaxPresenceMembers int              `json:"max_presence_members,omitempty"`
 
 	PingPeriod string `json:"ping_period,omitempty"`
 	WriteWait  string `json:"write_wait,omitempty"`
@@ -57,6 +100,18 @@ type WebsocketModule struct {
 	writeWaitDuration  time.Duration
 	pongWaitDuration   time.Duration
 	shutdownTimeout    time.Duration
+	redisOutboxMaxAge  time.Duration
+	idempotencyWindow  time.Duration
+	authTimeout        time.Duration
+	authConnectTimeout time.Duration
+	authBreaker        BreakerConfig
+	authBatchWindow    time.Duration
+	channelPolicy      *ChannelPolicy
+	authCacheTTL       time.Duration
+	authCacheNegTTL    time.Duration
+	reauthInterval     time.Duration
+	keys               *KeyRing
+	webhookKeys        *KeyRing
 
 	hub                *Hub
 	metrics            *Metrics
@@ -103,18 +158,36 @@ func (m *WebsocketModule) Provision(ctx caddy.Context) error {
 		return err
 	}
 
-	authProvider := NewWorkerAuthProvider(
-		m.logger,
-		m.metrics,
-		m.workerHandle,
-		m.AppKey,
-		m.AuthPath,
-		m.MaxAuthBody,
-		m.MaxConcurrentAuth,
-		m.AppSecret,
-	)
+	workerAuth, err := m.setupWorkerAuth()
+	if err != nil {
+		return err
+	}
+	workerAuth.SetKeyRing(m.keys)
+	if m.authCacheTTL > 0 {
+		workerAuth.EnableResultCache(AuthCacheConfig{
+			TTL:         m.authCacheTTL,
+			NegativeTTL: m.authCacheNegTTL,
+			Identity:    m.AuthCacheIdentity,
+			Name:        m.AuthCacheIDName,
+		})
+	}
+	var authProvider AuthProvider = workerAuth
+	if m.jwtAuthEnabled() {
+		jwtProvider, err := NewJWTAuthProvider(m.logger, m.metrics, JWTAuthConfig{
+			Secret:         m.JWTSecret,
+			PublicKeyFiles: m.JWTPublicKeys,
+			JWKSFile:       m.JWTJWKSFile,
+			Issuer:         m.JWTIssuer,
+			Audience:       m.JWTAudience,
+		}, authProvider)
+		if err != nil {
+			return err
+		}
+		authProvider = jwtProvider
+	}
 
 	m.webhook = NewWebhookManager(m.logger, m.WebhookURL, m.WebhookSecret, m.metrics)
+	m.webhook.SetKeyRing(m.webhookKeys)
 
 	broker, err := m.setupBroker()
 	if err != nil {
@@ -130,6 +203,14 @@ func (m *WebsocketModule) Provision(ctx caddy.Context) error {
 		BrokerQueueSize:    m.BrokerQueueSize,
 		ShardQueueSize:     m.ShardQueueSize,
 		ShutdownTimeout:    m.shutdownTimeout,
+		IdempotencyWindow:  m.idempotencyWindow,
+		ReauthInterval:     m.reauthInterval,
+
+		MaxConnectionsPerUser:         m.MaxConnsPerUser,
+		MaxSubscriptionsPerConnection: m.MaxSubsPerConn,
+		MaxPresenceMembers:            m.MaxPresenceMembers,
+		RetryUnavailableAuth:          m.AuthRetryable,
+		ChannelPolicy:                 m.channelPolicy,
 	}
 	m.hub = NewHub(m.AppID, m.logger, m.ctx, m.metrics, authProvider, m.webhook, broker, m.MaxConnections, m.NumShards, m.pingPeriodDuration, delivery)
 	m.metrics.SetDeliveryConfig(delivery.withDefaults())
@@ -140,6 +221,13 @@ func (m *WebsocketModule) Provision(ctx caddy.Context) error {
 
 	go m.hub.Run()
 
+	if m.IngestList != "" {
+		if redisBroker, ok := broker.(*RedisBroker); ok {
+			ingest := NewRedisIngest(m.logger, m.metrics, redisBroker.client, m.AppID, m.IngestList, m.IngestDeadLetter)
+			go ingest.Run(m.ctx)
+		}
+	}
+
 	m.upgrader = websocket.Upgrader{
 		ReadBufferSize:    1024,
 		WriteBufferSize:   1024,
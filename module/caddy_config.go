@@ -31,12 +31,53 @@ func (m *WebsocketModule) validateAndDefaults() error {
 	if m.AuthScript == "" && m.AuthPath != "" {
 		return fmt.Errorf("the 'auth_script' directive is required when auth_path is configured")
 	}
+	if m.AuthScript != "" && m.UserAuthPath == "" {
+		m.UserAuthPath = DefaultUserAuthPath
+	}
+	if m.AuthScript == "" && m.UserAuthPath != "" {
+		return fmt.Errorf("the 'auth_script' directive is required when user_auth_path is configured")
+	}
+	if m.AuthScript == "" && (m.AuthBatchPath != "" || m.AuthBatchWindow != "" || m.AuthBatchSize != 0) {
+		return fmt.Errorf("the 'auth_script' directive is required when auth batching is configured")
+	}
+	if m.AuthBatchPath == "" && (m.AuthBatchWindow != "" || m.AuthBatchSize != 0) {
+		return fmt.Errorf("auth_batch_window and auth_batch_size require auth_batch_path")
+	}
+	if m.AuthBatchSize < 0 {
+		return fmt.Errorf("auth_batch_size must not be negative")
+	}
+	if m.AuthURL != "" && m.AuthScript != "" {
+		return fmt.Errorf("auth_url and auth_script are mutually exclusive")
+	}
+	if m.AuthURL == "" && (len(m.AuthForwardHeaders) > 0 || len(m.AuthForwardCookies) > 0 || m.AuthConnectTimeout != "") {
+		return fmt.Errorf("auth_forward_headers, auth_forward_cookies and auth_connect_timeout require auth_url")
+	}
+	if m.AuthURL != "" {
+		parsed, err := url.Parse(m.AuthURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("auth_url must be an absolute http or https URL")
+		}
+	}
 	if m.AppSecret == "" {
 		m.AppSecret = appSecretFromEnv()
 	}
 	if m.AppSecret == "" {
 		return fmt.Errorf("the 'app_secret' directive is required")
 	}
+	previous, err := previousCredentials("app_previous_secret", m.AppKey, m.AppPrevSecrets)
+	if err != nil {
+		return err
+	}
+	m.keys = NewKeyRing(m.metrics, AppCredential{Key: m.AppKey, Secret: m.AppSecret}, previous...)
+
+	if len(m.WebhookPrevSecrets) > 0 && m.WebhookSecret == "" {
+		return fmt.Errorf("webhook_previous_secret requires webhook_secret")
+	}
+	previous, err = previousCredentials("webhook_previous_secret", "", m.WebhookPrevSecrets)
+	if err != nil {
+		return err
+	}
+	m.webhookKeys = NewKeyRing(m.metrics, AppCredential{Secret: m.WebhookSecret}, previous...)
 
 	if m.NumWorkers == 0 {
 		m.NumWorkers = 2
@@ -50,6 +91,9 @@ func (m *WebsocketModule) validateAndDefaults() error {
 	if m.MaxConnections < 1 {
 		return fmt.Errorf("max_connections must be greater than 0")
 	}
+	if m.MaxConnsPerUser < 0 || m.MaxSubsPerConn < 0 || m.MaxPresenceMembers < 0 {
+		return fmt.Errorf("max_connections_per_user, max_subscriptions_per_connection and max_presence_members must not be negative")
+	}
 	if m.MaxAuthBody == 0 {
 		m.MaxAuthBody = 16 * 1024
 	}
@@ -68,6 +112,36 @@ func (m *WebsocketModule) validateAndDefaults() error {
 	if m.RedisDB < 0 {
 		return fmt.Errorf("redis_db must not be negative")
 	}
+	if m.RedisEnvelope == "" {
+		m.RedisEnvelope = EnvelopeFormatJSON
+	}
+	if m.RedisEnvelope != EnvelopeFormatJSON && m.RedisEnvelope != EnvelopeFormatBinary {
+		return fmt.Errorf("redis_envelope must be %q or %q", EnvelopeFormatJSON, EnvelopeFormatBinary)
+	}
+	if m.RedisCompressAt < 0 {
+		return fmt.Errorf("redis_compress_threshold must not be negative")
+	}
+	if m.RedisOutboxSize < 0 {
+		return fmt.Errorf("redis_outbox_size must not be negative")
+	}
+	if m.MeshListen != "" && m.RedisHost != "" {
+		return fmt.Errorf("mesh_listen and redis_host are mutually exclusive")
+	}
+	if m.MeshListen == "" && (len(m.MeshPeers) > 0 || len(m.MeshDNS) > 0) {
+		return fmt.Errorf("mesh_peers and mesh_dns require mesh_listen")
+	}
+	if m.IngestList == "" && m.IngestDeadLetter != "" {
+		return fmt.Errorf("redis_ingest_dead_letter requires redis_ingest_list")
+	}
+	if m.IngestList != "" && m.RedisHost == "" {
+		return fmt.Errorf("redis_ingest_list requires redis_host")
+	}
+	if !m.jwtAuthEnabled() && (m.JWTIssuer != "" || m.JWTAudience != "") {
+		return fmt.Errorf("jwt_issuer and jwt_audience require jwt_secret, jwt_public_key or jwt_jwks_file")
+	}
+	if m.MeshListen != "" && m.MeshSecret == "" {
+		m.MeshSecret = m.AppSecret
+	}
 
 	if m.NumShards == 0 {
 		m.NumShards = runtime.NumCPU() * 2
@@ -150,7 +224,6 @@ func (m *WebsocketModule) validateAndDefaults() error {
 		m.allowedOriginHosts = nil
 	}
 
-	var err error
 	if m.PingPeriod == "" {
 		m.pingPeriodDuration = DefaultPingPeriod
 	} else {
@@ -190,9 +263,146 @@ func (m *WebsocketModule) validateAndDefaults() error {
 		}
 	}
 
+	if m.IdempotencyWindow == "" {
+		m.idempotencyWindow = DefaultIdempotencyWindow
+	} else {
+		m.idempotencyWindow, err = time.ParseDuration(m.IdempotencyWindow)
+		if err != nil {
+			return fmt.Errorf("invalid idempotency_window: %v", err)
+		}
+		if m.idempotencyWindow <= 0 {
+			return fmt.Errorf("idempotency_window must be greater than 0")
+		}
+	}
+
+	if m.AuthTimeout != "" {
+		m.authTimeout, err = time.ParseDuration(m.AuthTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid auth_timeout: %v", err)
+		}
+		if m.authTimeout <= 0 {
+			return fmt.Errorf("auth_timeout must be greater than 0")
+		}
+	}
+	if m.AuthConnectTimeout != "" {
+		m.authConnectTimeout, err = time.ParseDuration(m.AuthConnectTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid auth_connect_timeout: %v", err)
+		}
+		if m.authConnectTimeout <= 0 {
+			return fmt.Errorf("auth_connect_timeout must be greater than 0")
+		}
+	}
+
+	if m.BreakerThreshold < 0 || m.BreakerMinRequests < 0 || m.BreakerHalfOpen < 0 {
+		return fmt.Errorf("auth_breaker_threshold, min_requests and auth_breaker_half_open_requests must not be negative")
+	}
+	if m.BreakerRatio < 0 || m.BreakerRatio > 1 {
+		return fmt.Errorf("auth_breaker_failure_ratio must be between 0 and 1")
+	}
+	m.authBreaker = BreakerConfig{
+		Threshold:        m.BreakerThreshold,
+		FailureRatio:     m.BreakerRatio,
+		MinRequests:      m.BreakerMinRequests,
+		HalfOpenRequests: m.BreakerHalfOpen,
+	}
+	if m.BreakerReset != "" {
+		m.authBreaker.ResetTimeout, err = time.ParseDuration(m.BreakerReset)
+		if err != nil {
+			return fmt.Errorf("invalid auth_breaker_reset: %v", err)
+		}
+		if m.authBreaker.ResetTimeout <= 0 {
+			return fmt.Errorf("auth_breaker_reset must be greater than 0")
+		}
+	}
+	if m.BreakerInterval != "" {
+		m.authBreaker.Interval, err = time.ParseDuration(m.BreakerInterval)
+		if err != nil {
+			return fmt.Errorf("invalid auth_breaker_interval: %v", err)
+		}
+		if m.authBreaker.Interval <= 0 {
+			return fmt.Errorf("auth_breaker_interval must be greater than 0")
+		}
+	}
+
+	m.channelPolicy, err = NewChannelPolicy(m.ChannelPolicies)
+	if err != nil {
+		return err
+	}
+
+	if m.AuthBatchWindow != "" {
+		m.authBatchWindow, err = time.ParseDuration(m.AuthBatchWindow)
+		if err != nil {
+			return fmt.Errorf("invalid auth_batch_window: %v", err)
+		}
+		if m.authBatchWindow <= 0 || m.authBatchWindow > time.Second {
+			return fmt.Errorf("auth_batch_window must be greater than 0 and at most 1s")
+		}
+	}
+
+	if m.AuthCacheTTL != "" {
+		m.authCacheTTL, err = time.ParseDuration(m.AuthCacheTTL)
+		if err != nil {
+			return fmt.Errorf("invalid auth_cache_ttl: %v", err)
+		}
+		if m.authCacheTTL <= 0 {
+			return fmt.Errorf("auth_cache_ttl must be greater than 0")
+		}
+	}
+	if m.AuthCacheNegTTL != "" {
+		m.authCacheNegTTL, err = time.ParseDuration(m.AuthCacheNegTTL)
+		if err != nil {
+			return fmt.Errorf("invalid auth_cache_negative_ttl: %v", err)
+		}
+		if m.authCacheNegTTL <= 0 {
+			return fmt.Errorf("auth_cache_negative_ttl must be greater than 0")
+		}
+	}
+	if m.authCacheTTL == 0 && (m.AuthCacheNegTTL != "" || m.AuthCacheIdentity != "") {
+		return fmt.Errorf("auth_cache_negative_ttl and auth_cache_identity require auth_cache_ttl")
+	}
+	switch m.AuthCacheIdentity {
+	case "", AuthCacheIdentityHeaders, AuthCacheIdentityUser:
+		if m.AuthCacheIDName != "" {
+			return fmt.Errorf("auth_cache_identity %s takes no name", m.AuthCacheIdentity)
+		}
+	case AuthCacheIdentityHeader, AuthCacheIdentityCookie:
+		if m.AuthCacheIDName == "" {
+			return fmt.Errorf("auth_cache_identity %s requires a name", m.AuthCacheIdentity)
+		}
+	default:
+		return fmt.Errorf("auth_cache_identity must be headers, header <name>, cookie <name>, or user")
+	}
+
+	if m.ReauthInterval != "" {
+		m.reauthInterval, err = time.ParseDuration(m.ReauthInterval)
+		if err != nil {
+			return fmt.Errorf("invalid reauth_interval: %v", err)
+		}
+		if m.reauthInterval < time.Second {
+			return fmt.Errorf("reauth_interval must be at least 1s")
+		}
+	}
+
+	if m.RedisOutboxMaxAge == "" {
+		m.redisOutboxMaxAge = DefaultOutboxMaxAge
+	} else {
+		m.redisOutboxMaxAge, err = time.ParseDuration(m.RedisOutboxMaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid redis_outbox_max_age: %v", err)
+		}
+		if m.redisOutboxMaxAge <= 0 {
+			return fmt.Errorf("redis_outbox_max_age must be greater than 0")
+		}
+	}
+
 	return nil
 }
 
+func (m *WebsocketModule) jwtAuthEnabled() bool {
+	return m.JWTSecret != "" || len(m.JWTPublicKeys) > 0 || m.JWTJWKSFile != ""
+}
+
 func normalizeOriginHost(raw string) (string, bool) {
 	if raw == "" || strings.Contains(raw, "/") || strings.Contains(raw, "?") || strings.Contains(raw, "#") {
 		return "", false
@@ -286,6 +496,26 @@ func appSecretFromEnv() string {
 	return os.Getenv("REVERB_APP_SECRET")
 }
 
+// parsePreviousSecret reads `<secret> [key <app_key>] [expires <RFC 3339 time>]`.
+func parsePreviousSecret(d *caddyfile.Dispenser, allowKey bool) (PreviousSecret, error) {
+	args := d.RemainingArgs()
+	if len(args) == 0 || len(args)%2 == 0 {
+		return PreviousSecret{}, d.ArgErr()
+	}
+	previous := PreviousSecret{Secret: args[0]}
+	for i := 1; i < len(args); i += 2 {
+		switch {
+		case args[i] == "key" && allowKey:
+			previous.Key = args[i+1]
+		case args[i] == "expires":
+			previous.ExpiresAt = args[i+1]
+		default:
+			return PreviousSecret{}, d.Errf("unknown %s option %q", d.Val(), args[i])
+		}
+	}
+	return previous, nil
+}
+
 func (m *WebsocketModule) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
 		for d.NextBlock(0) {
@@ -305,16 +535,122 @@ func (m *WebsocketModule) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 				m.AppSecret = d.Val()
+			case "app_previous_secret":
+				previous, err := parsePreviousSecret(d, true)
+				if err != nil {
+					return err
+				}
+				m.AppPrevSecrets = append(m.AppPrevSecrets, previous)
 			case "auth_path":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				m.AuthPath = d.Val()
+			case "user_auth_path":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.UserAuthPath = d.Val()
+			case "channel_policy":
+				rule, err := parseChannelRule(d)
+				if err != nil {
+					return err
+				}
+				m.ChannelPolicies = append(m.ChannelPolicies, rule)
+			case "auth_batch_path":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.AuthBatchPath = d.Val()
+			case "auth_batch_window":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.AuthBatchWindow = d.Val()
+			case "auth_batch_size":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				if _, err := fmt.Sscanf(d.Val(), "%d", &m.AuthBatchSize); err != nil {
+					return d.Errf("invalid number: %v", err)
+				}
 			case "auth_script":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				m.AuthScript = d.Val()
+			case "auth_url":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.AuthURL = d.Val()
+			case "auth_forward_headers":
+				headers := d.RemainingArgs()
+				if len(headers) == 0 {
+					return d.ArgErr()
+				}
+				m.AuthForwardHeaders = append(m.AuthForwardHeaders, headers...)
+			case "auth_forward_cookies":
+				cookies := d.RemainingArgs()
+				if len(cookies) == 0 {
+					return d.ArgErr()
+				}
+				m.AuthForwardCookies = append(m.AuthForwardCookies, cookies...)
+			case "auth_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.AuthTimeout = d.Val()
+			case "auth_connect_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.AuthConnectTimeout = d.Val()
+			case "auth_breaker_threshold", "auth_breaker_half_open_requests":
+				directive := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var n int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &n); err != nil {
+					return d.Errf("invalid number: %v", err)
+				}
+				if directive == "auth_breaker_threshold" {
+					m.BreakerThreshold = n
+				} else {
+					m.BreakerHalfOpen = n
+				}
+			case "auth_breaker_failure_ratio":
+				// auth_breaker_failure_ratio <ratio> [min_requests <n>]
+				args := d.RemainingArgs()
+				if len(args) != 1 && (len(args) != 3 || args[1] != "min_requests") {
+					return d.ArgErr()
+				}
+				if _, err := fmt.Sscanf(args[0], "%g", &m.BreakerRatio); err != nil {
+					return d.Errf("invalid ratio: %v", err)
+				}
+				if len(args) == 3 {
+					if _, err := fmt.Sscanf(args[2], "%d", &m.BreakerMinRequests); err != nil {
+						return d.Errf("invalid number: %v", err)
+					}
+				}
+			case "auth_breaker_reset":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.BreakerReset = d.Val()
+			case "auth_breaker_interval":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.BreakerInterval = d.Val()
+			case "auth_retry_unavailable":
+				m.AuthRetryable = true
+				if d.NextArg() {
+					if _, err := fmt.Sscanf(d.Val(), "%t", &m.AuthRetryable); err != nil {
+						return d.Errf("invalid boolean: %v", err)
+					}
+				}
 			case "num_workers":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -333,6 +669,23 @@ func (m *WebsocketModule) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.Errf("invalid number: %v", err)
 				}
 				m.MaxConnections = c
+			case "max_connections_per_user", "max_subscriptions_per_connection", "max_presence_members":
+				directive := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var limit int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &limit); err != nil {
+					return d.Errf("invalid number: %v", err)
+				}
+				switch directive {
+				case "max_connections_per_user":
+					m.MaxConnsPerUser = limit
+				case "max_subscriptions_per_connection":
+					m.MaxSubsPerConn = limit
+				default:
+					m.MaxPresenceMembers = limit
+				}
 			case "max_auth_body":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -474,6 +827,61 @@ func (m *WebsocketModule) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 				m.ShutdownTimeout = d.Val()
+			case "idempotency_window":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.IdempotencyWindow = d.Val()
+			case "jwt_secret":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.JWTSecret = d.Val()
+			case "jwt_public_key":
+				files := d.RemainingArgs()
+				if len(files) == 0 {
+					return d.ArgErr()
+				}
+				m.JWTPublicKeys = append(m.JWTPublicKeys, files...)
+			case "jwt_jwks_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.JWTJWKSFile = d.Val()
+			case "jwt_issuer":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.JWTIssuer = d.Val()
+			case "jwt_audience":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.JWTAudience = d.Val()
+			case "auth_cache_ttl":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.AuthCacheTTL = d.Val()
+			case "auth_cache_negative_ttl":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.AuthCacheNegTTL = d.Val()
+			case "auth_cache_identity":
+				args := d.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				m.AuthCacheIdentity = args[0]
+				if len(args) == 2 {
+					m.AuthCacheIDName = args[1]
+				}
+			case "reauth_interval":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.ReauthInterval = d.Val()
 			case "webhook_url":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -484,6 +892,12 @@ func (m *WebsocketModule) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 				m.WebhookSecret = d.Val()
+			case "webhook_previous_secret":
+				previous, err := parsePreviousSecret(d, false)
+				if err != nil {
+					return err
+				}
+				m.WebhookPrevSecrets = append(m.WebhookPrevSecrets, previous)
 			case "redis_host":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -508,6 +922,62 @@ func (m *WebsocketModule) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 						return d.Errf("invalid boolean: %v", err)
 					}
 				}
+			case "redis_envelope":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.RedisEnvelope = d.Val()
+			case "redis_compress_threshold":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				if _, err := fmt.Sscanf(d.Val(), "%d", &m.RedisCompressAt); err != nil {
+					return d.Errf("invalid number: %v", err)
+				}
+			case "redis_outbox_size":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				if _, err := fmt.Sscanf(d.Val(), "%d", &m.RedisOutboxSize); err != nil {
+					return d.Errf("invalid number: %v", err)
+				}
+			case "redis_outbox_max_age":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.RedisOutboxMaxAge = d.Val()
+			case "mesh_listen":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.MeshListen = d.Val()
+			case "mesh_peers":
+				peers := d.RemainingArgs()
+				if len(peers) == 0 {
+					return d.ArgErr()
+				}
+				m.MeshPeers = append(m.MeshPeers, peers...)
+			case "mesh_dns":
+				names := d.RemainingArgs()
+				if len(names) == 0 {
+					return d.ArgErr()
+				}
+				m.MeshDNS = append(m.MeshDNS, names...)
+			case "mesh_secret":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.MeshSecret = d.Val()
+			case "redis_ingest_list":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.IngestList = d.Val()
+			case "redis_ingest_dead_letter":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.IngestDeadLetter = d.Val()
 			default:
 				return d.Errf("unrecognized directive %q", d.Val())
 			}
@@ -515,3 +985,49 @@ func (m *WebsocketModule) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	}
 	return nil
 }
+
+// parseChannelRule parses
+//
+//	channel_policy <pattern...> {
+//	    deny
+//	    client_events [true|false]
+//	    max_subscribers <n>
+//	    max_payload <bytes>
+//	}
+func parseChannelRule(d *caddyfile.Dispenser) (ChannelRule, error) {
+	rule := ChannelRule{Patterns: d.RemainingArgs()}
+	if len(rule.Patterns) == 0 {
+		return rule, d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "deny":
+			rule.Deny = true
+		case "client_events":
+			allowed := true
+			if d.NextArg() {
+				if _, err := fmt.Sscanf(d.Val(), "%t", &allowed); err != nil {
+					return rule, d.Errf("invalid boolean: %v", err)
+				}
+			}
+			rule.ClientEvents = &allowed
+		case "max_subscribers", "max_payload":
+			option := d.Val()
+			if !d.NextArg() {
+				return rule, d.ArgErr()
+			}
+			var n int
+			if _, err := fmt.Sscanf(d.Val(), "%d", &n); err != nil {
+				return rule, d.Errf("invalid number: %v", err)
+			}
+			if option == "max_subscribers" {
+				rule.MaxSubscribers = n
+			} else {
+				rule.MaxPayload = n
+			}
+		default:
+			return rule, d.Errf("unrecognized channel_policy option %q", d.Val())
+		}
+	}
+	return rule, nil
+}
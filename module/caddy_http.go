@@ -113,6 +113,10 @@ func (m *WebsocketModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next
 		m.serveHealth(w)
 		return nil
 	}
+	if r.URL.Path == "/pogo/ready" {
+		m.serveReady(w)
+		return nil
+	}
 
 	if strings.HasPrefix(r.URL.Path, "/apps/") {
 		m.servePusherAPI(w, r)
@@ -130,7 +134,7 @@ func (m *WebsocketModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next
 		return next.ServeHTTP(w, r)
 	}
 
-	if key := appKeyFromPath(r.URL.Path); key != m.AppKey {
+	if key := appKeyFromPath(r.URL.Path); !m.appKeys().HasKey(key) {
 		http.Error(w, "Invalid app key", http.StatusForbidden)
 		return nil
 	}
@@ -161,6 +165,7 @@ func (m *WebsocketModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next
 		conn:           conn,
 		send:           make(chan any, m.OutboundQueueSize),
 		Headers:        headers,
+		connectToken:   connectToken(r),
 		ctx:            ctx,
 		cancel:         cancel,
 		PingPeriod:     m.pingPeriodDuration,
@@ -181,11 +186,32 @@ func (m *WebsocketModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next
 	return nil
 }
 
+// connectToken reads the token a client passes when connecting, from the token
+// query parameter (browsers cannot set WebSocket headers) or a bearer header.
+func connectToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
 func isSupportedProtocol(raw string) bool {
 	version, err := strconv.Atoi(raw)
 	return err == nil && version >= 5
 }
 
+// appKeys returns the configured key ring, or one holding only AppKey and
+// AppSecret when the module was not provisioned.
+func (m *WebsocketModule) appKeys() *KeyRing {
+	if m.keys != nil {
+		return m.keys
+	}
+	return NewKeyRing(m.metrics, AppCredential{Key: m.AppKey, Secret: m.AppSecret})
+}
+
 func appKeyFromPath(path string) string {
 	key := strings.TrimPrefix(path, "/app/")
 	if key == path {
@@ -198,21 +224,32 @@ func appKeyFromPath(path string) string {
 }
 
 func (m *WebsocketModule) serveHealth(w http.ResponseWriter) {
-	status := "ok"
+	if m.hub == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "hub_not_initialized"})
+		return
+	}
+
+	health := m.hub.Health()
 	code := http.StatusOK
+	if health.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, health)
+}
 
+// serveReady reports the hub's health and fails while the broker is not
+// connected, so load balancers can drain nodes that lost Redis without
+// restarting them.
+func (m *WebsocketModule) serveReady(w http.ResponseWriter) {
 	if m.hub == nil {
-		status = "hub_not_initialized"
-		code = http.StatusServiceUnavailable
-	} else if !m.hub.IsHealthy() {
-		status = m.hub.HealthError()
-		if status == "" {
-			status = "hub_unhealthy"
-		}
-		code = http.StatusServiceUnavailable
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "hub_not_initialized"})
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	_, _ = w.Write([]byte(`{"status":"` + status + `"}`))
+	health := m.hub.Health()
+	code := http.StatusOK
+	if !health.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, health)
 }
@@ -18,10 +18,68 @@ func (m *WebsocketModule) setupWorkers() error {
 	return nil
 }
 
+// setupWorkerAuth returns the provider that authorizes subscriptions sent
+// without a signature, through the auth worker or the auth_url upstream.
+func (m *WebsocketModule) setupWorkerAuth() (*WorkerAuthProvider, error) {
+	if m.AuthURL != "" {
+		m.logger.Info("Using upstream auth endpoint", zap.String("url", m.AuthURL))
+		provider, err := NewHTTPAuthProvider(m.logger, m.metrics, HTTPAuthConfig{
+			URL:            m.AuthURL,
+			Headers:        m.AuthForwardHeaders,
+			Cookies:        m.AuthForwardCookies,
+			Timeout:        m.authTimeout,
+			ConnectTimeout: m.authConnectTimeout,
+		}, m.AppKey, m.MaxAuthBody, m.MaxConcurrentAuth, m.AppSecret)
+		if err != nil {
+			return nil, err
+		}
+		provider.SetBreakerConfig(m.authBreaker)
+		return provider, nil
+	}
+
+	provider := NewWorkerAuthProvider(
+		m.logger,
+		m.metrics,
+		m.workerHandle,
+		m.AppKey,
+		m.AuthPath,
+		m.MaxAuthBody,
+		m.MaxConcurrentAuth,
+		m.AppSecret,
+	)
+	if m.authTimeout > 0 {
+		provider.timeout = m.authTimeout
+	}
+	provider.SetBreakerConfig(m.authBreaker)
+	provider.EnableUserAuth(m.UserAuthPath)
+	if m.AuthBatchPath != "" {
+		provider.EnableBatching(AuthBatchConfig{
+			Path:    m.AuthBatchPath,
+			Window:  m.authBatchWindow,
+			MaxSize: m.AuthBatchSize,
+		})
+	}
+	return provider, nil
+}
+
 func (m *WebsocketModule) setupBroker() (Broker, error) {
 	if m.RedisHost != "" {
-		m.logger.Info("Using Redis Broker", zap.String("host", m.RedisHost), zap.Int("db", m.RedisDB), zap.Bool("tls", m.RedisTLS))
-		return NewRedisBroker(m.logger, m.AppID, m.RedisHost, m.RedisPassword, m.RedisDB, m.RedisTLS, m.BrokerQueueSize), nil
+		m.logger.Info("Using Redis Broker", zap.String("host", m.RedisHost), zap.Int("db", m.RedisDB), zap.Bool("tls", m.RedisTLS), zap.String("envelope", m.RedisEnvelope))
+		broker := NewRedisBroker(m.logger, m.AppID, m.RedisHost, m.RedisPassword, m.RedisDB, m.RedisTLS, m.BrokerQueueSize)
+		return broker.
+			WithMetrics(m.metrics).
+			WithEnvelope(m.RedisEnvelope, m.RedisCompressAt).
+			WithOutbox(m.RedisOutboxSize, m.redisOutboxMaxAge, m.metrics), nil
+	}
+	if m.MeshListen != "" {
+		m.logger.Info("Using Mesh Broker", zap.String("listen", m.MeshListen), zap.Strings("peers", m.MeshPeers), zap.Strings("dns", m.MeshDNS))
+		return NewMeshBroker(m.logger, m.metrics, m.AppID, MeshConfig{
+			Listen:    m.MeshListen,
+			Peers:     m.MeshPeers,
+			DNS:       m.MeshDNS,
+			Secret:    m.MeshSecret,
+			QueueSize: m.BrokerQueueSize,
+		})
 	}
 	m.logger.Info("Using Memory Broker")
 	return NewMemoryBroker(m.logger, m.metrics, m.BrokerQueueSize), nil
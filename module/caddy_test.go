@@ -1,8 +1,12 @@
 package websocket
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"go.uber.org/zap"
@@ -116,6 +120,9 @@ func TestWebsocketModuleAuthScriptDefaultsAuthPath(t *testing.T) {
 	if m.AuthPath != "/broadcasting/auth" {
 		t.Fatalf("AuthPath = %q, want /broadcasting/auth", m.AuthPath)
 	}
+	if m.UserAuthPath != DefaultUserAuthPath {
+		t.Fatalf("UserAuthPath = %q, want %s", m.UserAuthPath, DefaultUserAuthPath)
+	}
 }
 
 func TestWebsocketModuleRejectsAuthPathWithoutWorker(t *testing.T) {
@@ -209,6 +216,230 @@ func TestWebsocketModuleParsesShutdownTimeout(t *testing.T) {
 	}
 }
 
+func TestWebsocketModuleParsesIdempotencyWindow(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		idempotency_window 30s
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if m.idempotencyWindow != 30*time.Second {
+		t.Fatalf("idempotencyWindow = %s, want 30s", m.idempotencyWindow)
+	}
+}
+
+func TestWebsocketModuleParsesJWTAuth(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		jwt_secret jwt-secret
+		jwt_public_key /etc/keys/a.pem /etc/keys/b.pem
+		jwt_jwks_file /etc/keys/jwks.json
+		jwt_issuer https://app.example
+		jwt_audience websocket
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if m.JWTSecret != "jwt-secret" || len(m.JWTPublicKeys) != 2 || m.JWTJWKSFile != "/etc/keys/jwks.json" {
+		t.Fatalf("jwt keys = %q/%v/%q", m.JWTSecret, m.JWTPublicKeys, m.JWTJWKSFile)
+	}
+	if m.JWTIssuer != "https://app.example" || m.JWTAudience != "websocket" {
+		t.Fatalf("jwt claims = %q/%q", m.JWTIssuer, m.JWTAudience)
+	}
+
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", JWTIssuer: "https://app.example"}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected jwt_issuer without a key to be rejected")
+	}
+}
+
+func TestWebsocketModuleParsesAuthURL(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		auth_url https://auth.internal/broadcasting/auth
+		auth_forward_headers Authorization X-Tenant
+		auth_forward_cookies laravel_session
+		auth_timeout 2s
+		auth_connect_timeout 500ms
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if len(m.AuthForwardHeaders) != 2 || len(m.AuthForwardCookies) != 1 {
+		t.Fatalf("forwarded headers %v and cookies %v", m.AuthForwardHeaders, m.AuthForwardCookies)
+	}
+	if m.authTimeout != 2*time.Second || m.authConnectTimeout != 500*time.Millisecond {
+		t.Fatalf("auth timeouts = %s/%s, want 2s/500ms", m.authTimeout, m.authConnectTimeout)
+	}
+
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", AuthURL: "https://auth.internal", AuthScript: "auth.php"}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected auth_url with auth_script to be rejected")
+	}
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", AuthURL: "auth.internal/broadcasting/auth"}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected auth_url without a scheme to be rejected")
+	}
+}
+
+func TestWebsocketModuleParsesPreviousSecrets(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret new-secret
+		app_previous_secret old-secret expires 2030-01-01T00:00:00Z
+		app_previous_secret older-secret key old-key
+		webhook_secret hook-new
+		webhook_previous_secret hook-old
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if len(m.AppPrevSecrets) != 2 || m.AppPrevSecrets[1].Key != "old-key" || m.AppPrevSecrets[0].ExpiresAt == "" {
+		t.Fatalf("previous secrets = %+v", m.AppPrevSecrets)
+	}
+	if !m.keys.HasKey("pogo-key") || !m.keys.HasKey("old-key") || len(m.webhookKeys.active()) != 2 {
+		t.Fatal("Expected previous secrets in the app and webhook key rings")
+	}
+
+	for _, config := range []string{
+		"app_previous_secret old expires tomorrow",
+		"app_previous_secret old ttl 1h",
+		"webhook_previous_secret old key other",
+	} {
+		d := caddyfile.NewTestDispenser("pogo_websocket {\n app_id a\n app_key k\n app_secret s\n webhook_secret w\n " + config + "\n}")
+		var m WebsocketModule
+		if m.UnmarshalCaddyfile(d) == nil && m.validateAndDefaults() == nil {
+			t.Fatalf("Expected %q to be rejected", config)
+		}
+	}
+}
+
+func TestWebsocketModuleParsesReauthInterval(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		reauth_interval 5m
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if m.reauthInterval != 5*time.Minute {
+		t.Fatalf("reauth interval = %s, want 5m", m.reauthInterval)
+	}
+
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", ReauthInterval: "10ms"}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected a sub-second reauth_interval to be rejected")
+	}
+}
+
+func TestWebsocketModuleParsesAuthCache(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		auth_cache_ttl 30s
+		auth_cache_negative_ttl 2s
+		auth_cache_identity cookie laravel_session
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if m.authCacheTTL != 30*time.Second || m.authCacheNegTTL != 2*time.Second {
+		t.Fatalf("auth cache ttl = %s/%s, want 30s/2s", m.authCacheTTL, m.authCacheNegTTL)
+	}
+	if m.AuthCacheIdentity != AuthCacheIdentityCookie || m.AuthCacheIDName != "laravel_session" {
+		t.Fatalf("auth cache identity = %q %q", m.AuthCacheIdentity, m.AuthCacheIDName)
+	}
+
+	for _, invalid := range [][2]string{{"", AuthCacheIdentityUser}, {"30s", AuthCacheIdentityHeader}, {"30s", "ip"}} {
+		m := WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", AuthCacheTTL: invalid[0], AuthCacheIdentity: invalid[1]}
+		if err := m.validateAndDefaults(); err == nil {
+			t.Fatalf("Expected auth_cache_ttl %q with identity %q to be rejected", invalid[0], invalid[1])
+		}
+	}
+}
+
+func TestWebsocketModuleParsesRedisTransportOptions(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		redis_host localhost:6379
+		redis_envelope binary
+		redis_compress_threshold 4096
+		redis_outbox_size 500
+		redis_outbox_max_age 5s
+		redis_ingest_list pogo:broadcasts
+		redis_ingest_dead_letter pogo:broadcasts:failed
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if m.RedisEnvelope != EnvelopeFormatBinary || m.RedisCompressAt != 4096 {
+		t.Fatalf("redis envelope = %q/%d, want binary/4096", m.RedisEnvelope, m.RedisCompressAt)
+	}
+	if m.RedisOutboxSize != 500 || m.redisOutboxMaxAge != 5*time.Second {
+		t.Fatalf("redis outbox = %d/%s, want 500/5s", m.RedisOutboxSize, m.redisOutboxMaxAge)
+	}
+	if m.IngestList != "pogo:broadcasts" || m.IngestDeadLetter != "pogo:broadcasts:failed" {
+		t.Fatalf("redis ingest = %q/%q", m.IngestList, m.IngestDeadLetter)
+	}
+
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", RedisEnvelope: "msgpack"}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected unknown redis_envelope to be rejected")
+	}
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", IngestList: "pogo:broadcasts"}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected redis_ingest_list without redis_host to be rejected")
+	}
+}
+
 func TestWebsocketModuleProtocolParsing(t *testing.T) {
 	for _, proto := range []string{"5", "7", "10"} {
 		if !isSupportedProtocol(proto) {
@@ -508,3 +739,166 @@ func TestWebsocketModuleRejectsRemovedFanoutEnvOverrides(t *testing.T) {
 		})
 	}
 }
+
+func TestWebsocketModuleHealthReportsDetails(t *testing.T) {
+	module, _, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+
+	deadline := time.Now().Add(time.Second)
+	for !module.hub.IsReady() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rr := httptest.NewRecorder()
+	module.serveHealth(rr)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("health status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+
+	var health map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+		t.Fatalf("health JSON invalid: %v", err)
+	}
+	if health["status"] != "ok" || health["ready"] != true || health["broker_state"] != "connected" || health["connections"] != float64(0) {
+		t.Fatalf("unexpected health body: %v", health)
+	}
+	if _, ok := health["shard_queue_saturation"]; !ok {
+		t.Fatalf("health body missing shard_queue_saturation: %v", health)
+	}
+
+	module.hub.setBrokerState(BrokerReconnecting)
+	rr = httptest.NewRecorder()
+	module.serveHealth(rr)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"ready":false`) {
+		t.Fatalf("liveness during broker outage = %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	module.serveReady(rr)
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"broker_state":"reconnecting"`) {
+		t.Fatalf("readiness during broker outage = %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestWebsocketModuleParsesLimits(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		max_connections_per_user 5
+		max_subscriptions_per_connection 100
+		max_presence_members 100
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if m.MaxConnsPerUser != 5 || m.MaxSubsPerConn != 100 || m.MaxPresenceMembers != 100 {
+		t.Fatalf("limits = %d/%d/%d, want 5/100/100", m.MaxConnsPerUser, m.MaxSubsPerConn, m.MaxPresenceMembers)
+	}
+
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", MaxPresenceMembers: -1}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected a negative max_presence_members to be rejected")
+	}
+}
+
+func TestWebsocketModuleParsesAuthBreaker(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		auth_breaker_failure_ratio 0.5 min_requests 20
+		auth_breaker_half_open_requests 3
+		auth_breaker_reset 30s
+		auth_retry_unavailable
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	want := BreakerConfig{FailureRatio: 0.5, MinRequests: 20, HalfOpenRequests: 3, ResetTimeout: 30 * time.Second}
+	if m.authBreaker != want || !m.AuthRetryable {
+		t.Fatalf("breaker = %+v, retry = %v", m.authBreaker, m.AuthRetryable)
+	}
+
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", BreakerRatio: 1.5}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected a failure ratio above 1 to be rejected")
+	}
+}
+
+func TestWebsocketModuleParsesAuthBatching(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		auth_script public/frankenphp-worker.php
+		auth_batch_path /broadcasting/auth/batch
+		auth_batch_window 10ms
+		auth_batch_size 20
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if m.AuthBatchPath != "/broadcasting/auth/batch" || m.authBatchWindow != 10*time.Millisecond || m.AuthBatchSize != 20 {
+		t.Fatalf("batching = %q %s %d", m.AuthBatchPath, m.authBatchWindow, m.AuthBatchSize)
+	}
+
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", AuthBatchPath: "/batch"}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected auth_batch_path without auth_script to be rejected")
+	}
+}
+
+func TestWebsocketModuleParsesChannelPolicy(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		channel_policy public.*
+		channel_policy presence-chat.* {
+			client_events
+			max_payload 4096
+		}
+		channel_policy private-room.* {
+			max_subscribers 5000
+			client_events false
+		}
+		channel_policy * {
+			deny
+		}
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if len(m.ChannelPolicies) != 4 {
+		t.Fatalf("parsed %d channel policies, want 4", len(m.ChannelPolicies))
+	}
+	if m.channelPolicy.MaxSubscribers("private-room.1") != 5000 || m.channelPolicy.AllowsClientEvents("private-room.1") {
+		t.Fatalf("private-room rule = %+v", m.ChannelPolicies[2])
+	}
+	if !m.channelPolicy.AllowsClientEvents("presence-chat.1") || m.channelPolicy.AllowsPayload("presence-chat.1", 4097) {
+		t.Fatalf("presence-chat rule = %+v", m.ChannelPolicies[1])
+	}
+	if m.channelPolicy.AllowsSubscribe("news") || !m.channelPolicy.AllowsSubscribe("public.news") {
+		t.Fatal("Expected only public.* to be subscribable among unmatched public channels")
+	}
+}
@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"fmt"
+	"path"
+)
+
+// ChannelRule applies to channels matching any of its glob patterns, e.g.
+// "public.*" or "presence-chat.*". ClientEvents defaults to allowed, and zero
+// limits are unlimited.
+type ChannelRule struct {
+	Patterns       []string `json:"patterns"`
+	Deny           bool     `json:"deny,omitempty"`
+	ClientEvents   *bool    `json:"client_events,omitempty"`
+	MaxSubscribers int      `json:"max_subscribers,omitempty"`
+	MaxPayload     int      `json:"max_payload,omitempty"`
+}
+
+// ChannelPolicy applies the first rule whose pattern matches a channel.
+// Channels no rule matches behave as if there was no policy. A nil policy
+// allows everything.
+type ChannelPolicy struct {
+	rules []ChannelRule
+}
+
+func NewChannelPolicy(rules []ChannelRule) (*ChannelPolicy, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	for _, rule := range rules {
+		if len(rule.Patterns) == 0 {
+			return nil, fmt.Errorf("channel_policy requires at least one pattern")
+		}
+		for _, pattern := range rule.Patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid channel_policy pattern %q: %v", pattern, err)
+			}
+		}
+		if rule.MaxSubscribers < 0 || rule.MaxPayload < 0 {
+			return nil, fmt.Errorf("channel_policy max_subscribers and max_payload must not be negative")
+		}
+	}
+	return &ChannelPolicy{rules: rules}, nil
+}
+
+func (p *ChannelPolicy) rule(channel string) (ChannelRule, bool) {
+	if p == nil {
+		return ChannelRule{}, false
+	}
+	for _, rule := range p.rules {
+		for _, pattern := range rule.Patterns {
+			if matched, _ := path.Match(pattern, channel); matched {
+				return rule, true
+			}
+		}
+	}
+	return ChannelRule{}, false
+}
+
+func (p *ChannelPolicy) AllowsSubscribe(channel string) bool {
+	rule, _ := p.rule(channel)
+	return !rule.Deny
+}
+
+func (p *ChannelPolicy) AllowsClientEvents(channel string) bool {
+	rule, _ := p.rule(channel)
+	return !rule.Deny && (rule.ClientEvents == nil || *rule.ClientEvents)
+}
+
+func (p *ChannelPolicy) MaxSubscribers(channel string) int {
+	rule, _ := p.rule(channel)
+	return rule.MaxSubscribers
+}
+
+// AllowsPayload reports whether data of size bytes fits the channel's cap.
+func (p *ChannelPolicy) AllowsPayload(channel string, size int) bool {
+	rule, _ := p.rule(channel)
+	return rule.MaxPayload == 0 || size <= rule.MaxPayload
+}
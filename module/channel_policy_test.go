@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func testChannelPolicy(t *testing.T) *ChannelPolicy {
+	t.Helper()
+	allowed, denied := true, false
+	policy, err := NewChannelPolicy([]ChannelRule{
+		{Patterns: []string{"public.*"}},
+		{Patterns: []string{"presence-chat.*"}, ClientEvents: &allowed, MaxPayload: 16},
+		{Patterns: []string{"private-room.*"}, MaxSubscribers: 1, ClientEvents: &denied},
+		{Patterns: []string{"private-*", "presence-*"}, ClientEvents: &denied},
+		{Patterns: []string{"*"}, Deny: true},
+	})
+	if err != nil {
+		t.Fatalf("NewChannelPolicy returned error: %v", err)
+	}
+	return policy
+}
+
+func TestChannelPolicyFirstMatchingRuleApplies(t *testing.T) {
+	policy := testChannelPolicy(t)
+
+	if !policy.AllowsSubscribe("public.news") || policy.AllowsSubscribe("news") {
+		t.Fatal("Expected only public.* public channels to be allowed")
+	}
+	if !policy.AllowsClientEvents("presence-chat.1") || policy.AllowsClientEvents("private-orders") {
+		t.Fatal("Expected client events only on presence-chat.*")
+	}
+	if policy.MaxSubscribers("private-room.1") != 1 || policy.MaxSubscribers("private-orders") != 0 {
+		t.Fatal("Expected max_subscribers only on private-room.*")
+	}
+	if policy.AllowsPayload("presence-chat.1", 17) || !policy.AllowsPayload("private-orders", 1<<20) {
+		t.Fatal("Expected max_payload only on presence-chat.*")
+	}
+
+	var none *ChannelPolicy
+	if !none.AllowsSubscribe("anything") || !none.AllowsClientEvents("private-a") {
+		t.Fatal("Expected a nil policy to allow everything")
+	}
+	if _, err := NewChannelPolicy([]ChannelRule{{Patterns: []string{"private-["}}}); err == nil {
+		t.Fatal("Expected a malformed pattern to be rejected")
+	}
+}
+
+func TestClientAndSubscriptionsEnforceChannelPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	metrics := NewMetrics(prometheus.NewRegistry())
+	delivery := DefaultDeliveryConfig()
+	delivery.ChannelPolicy = testChannelPolicy(t)
+	hub := NewHub("test-app", zap.NewNop(), ctx, metrics, &MockAuthProvider{}, nil, &MockBroker{}, 100, 1, DefaultPingPeriod, delivery)
+	client := &Client{ID: "1.1", hub: hub, send: make(chan any, 8)}
+
+	client.handleMessage([]byte(`{"event":"pusher:subscribe","data":{"channel":"news"}}`))
+	if messages := drainSent(client); len(messages) != 1 || !strings.Contains(messages[0], `"code":4009`) {
+		t.Fatalf("subscribe to news got %v, want a 4009 error", messages)
+	}
+
+	client.handleMessage([]byte(`{"event":"client-typing","channel":"private-orders","data":"{}"}`))
+	client.handleMessage([]byte(`{"event":"client-typing","channel":"presence-chat.1","data":"{\"text\":\"far too long\"}"}`))
+	messages := drainSent(client)
+	if len(messages) != 2 || !strings.Contains(messages[0], "Client events not allowed") || !strings.Contains(messages[1], "payload too large") {
+		t.Fatalf("client events got %v, want two 4301 errors", messages)
+	}
+
+	first := &Client{ID: "2.1", send: make(chan any, 8)}
+	second := &Client{ID: "2.2", send: make(chan any, 8)}
+	hub.getShard("private-room.1").withSubscriptions(func(sm *SubscriptionManager) {
+		if !sm.Subscribe(first, "private-room.1", nil) || sm.Subscribe(second, "private-room.1", nil) {
+			t.Error("Expected max_subscribers to admit only the first subscriber")
+		}
+	})
+	if messages := drainSent(second); len(messages) != 1 || !strings.Contains(messages[0], `"code":4305`) {
+		t.Fatalf("second subscriber got %v, want a 4305 error", messages)
+	}
+
+	if status := hub.PublishWithOptions("presence-chat.1", "message", `{"text":"far too long"}`, PublishOptions{}); status != PublishPayloadTooLarge {
+		t.Fatalf("publish status = %v, want PublishPayloadTooLarge", status)
+	}
+	if got := counterValue(t, metrics.PolicyRejections.WithLabelValues("max_payload")); got != 2 {
+		t.Fatalf("max_payload rejections = %v, want 2", got)
+	}
+}
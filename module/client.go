@@ -44,8 +44,14 @@ type Client struct {
 	ctx     context.Context
 	cancel  context.CancelFunc
 
-	shardMask uint64
-	userID    atomic.Value
+	shardMask     uint64
+	subscriptions atomic.Int64
+	userID        atomic.Value
+	connectToken  string
+	// ordered is closed once the last asynchronous authorization applied.
+	ordered chan struct{}
+	// authSlots holds one token per asynchronous authorization not yet applied.
+	authSlots chan struct{}
 
 	PingPeriod     time.Duration
 	WriteWait      time.Duration
@@ -71,6 +77,24 @@ func (c *Client) HasShard(id int) bool {
 	return (mask & (uint64(1) << id)) != 0
 }
 
+// reserveSubscription counts a new subscription across shards, failing once
+// limit is reached. A limit of zero is unlimited.
+func (c *Client) reserveSubscription(limit int) bool {
+	for {
+		current := c.subscriptions.Load()
+		if limit > 0 && current >= int64(limit) {
+			return false
+		}
+		if c.subscriptions.CompareAndSwap(current, current+1) {
+			return true
+		}
+	}
+}
+
+func (c *Client) releaseSubscription() {
+	c.subscriptions.Add(-1)
+}
+
 func (c *Client) SetUserID(userID string) {
 	c.userID.Store(userID)
 }
@@ -83,6 +107,11 @@ func (c *Client) UserID() string {
 	return ""
 }
 
+// ConnectToken returns the auth token given when the connection was opened, if any.
+func (c *Client) ConnectToken() string {
+	return c.connectToken
+}
+
 func (c *Client) Disconnect() {
 	if c.cancel != nil {
 		c.cancel()
@@ -149,6 +178,8 @@ func outboundMetricKind(msg any) string {
 
 func (c *Client) readPump() {
 	defer func() {
+		// Subscriptions still being authorized must land before cleanup.
+		c.waitAuthorizations()
 		c.hub.Unregister(c)
 		_ = c.conn.Close()
 		c.cancel()
@@ -225,6 +256,16 @@ func (c *Client) handleMessage(message []byte) {
 		if len(msg.Data) > protocol.MaxDataSize {
 			return
 		}
+		if !c.hub.policy.AllowsClientEvents(msg.Channel) {
+			c.hub.policyRejected("client_events")
+			sendPusherError(c, protocol.ErrorClientEventRejected, "Client events not allowed on "+msg.Channel)
+			return
+		}
+		if !c.hub.policy.AllowsPayload(msg.Channel, len(msg.Data)) {
+			c.hub.policyRejected("max_payload")
+			sendPusherError(c, protocol.ErrorClientEventRejected, "Client event payload too large for "+msg.Channel)
+			return
+		}
 
 		if !c.hub.EnqueueClientMessage(&ClientMessageWrapper{
 			Client:  c,
@@ -250,31 +291,27 @@ func (c *Client) handleMessage(message []byte) {
 		if !protocol.IsValidChannelName(subData.Channel) {
 			return
 		}
+		if !c.hub.policy.AllowsSubscribe(subData.Channel) {
+			c.hub.policyRejected("subscribe")
+			sendPusherError(c, protocol.ErrorSubscriptionDenied, "Subscription to "+subData.Channel+" not allowed")
+			return
+		}
 
-		var authData json.RawMessage
+		if !strings.HasPrefix(subData.Channel, protocol.ChannelPrefixPrivate) && !strings.HasPrefix(subData.Channel, protocol.ChannelPrefixPresence) {
+			c.inOrder(func() func() {
+				return func() { c.hub.EnqueueSubscribe(&Subscription{Client: c, Channel: subData.Channel}) }
+			})
+			return
+		}
 
-		if strings.HasPrefix(subData.Channel, protocol.ChannelPrefixPrivate) || strings.HasPrefix(subData.Channel, protocol.ChannelPrefixPresence) {
+		authorize := func() func() {
 			result := c.hub.Authorize(c, subData.Channel, subData.Auth, subData.ChannelData)
-			if !result.Allowed {
-				errMsg, _ := json.Marshal(map[string]interface{}{
-					"event": protocol.EventError,
-					"data": map[string]interface{}{
-						"code":    protocol.ErrorSubscriptionDenied,
-						"message": "Subscription to " + subData.Channel + " rejected",
-					},
-				})
-				c.Send(errMsg)
-				return
-			}
-			authData = result.UserData
+			return func() { c.subscribeAuthorized(subData, result) }
 		}
-
-		if !c.hub.EnqueueSubscribe(&Subscription{
-			Client:   c,
-			Channel:  subData.Channel,
-			AuthData: authData,
-		}) {
-			return
+		if c.hub.batchesAuth() {
+			c.authorizeAsync(authorize)
+		} else {
+			c.inOrder(authorize)
 		}
 
 	case protocol.EventUnsubscribe:
@@ -285,7 +322,9 @@ func (c *Client) handleMessage(message []byte) {
 		if !protocol.IsValidChannelName(subData.Channel) {
 			return
 		}
-		c.hub.EnqueueUnsubscribe(&Subscription{Client: c, Channel: subData.Channel})
+		c.inOrder(func() func() {
+			return func() { c.hub.EnqueueUnsubscribe(&Subscription{Client: c, Channel: subData.Channel}) }
+		})
 
 	case protocol.EventSignin:
 		var signin SignInData
@@ -295,8 +334,14 @@ func (c *Client) handleMessage(message []byte) {
 
 		result := c.hub.auth.AuthenticateUser(c, signin.Auth, signin.UserData)
 		if result.Allowed {
-			if userID := signedInUserID(result.UserData); userID != "" {
-				c.SetUserID(userID)
+			if userID := signedInUserID(result.UserData); userID != "" && !c.hub.SignInUser(c, userID) {
+				c.hub.logger.Warn("Signin rejected, too many connections for user", zap.String("id", c.ID), zap.String("user_id", userID))
+				if c.conn != nil {
+					msg := websocket.FormatCloseMessage(protocol.ErrorOverCapacity, "Too many connections for user")
+					_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				}
+				c.Disconnect()
+				return
 			}
 
 			// Success: pusher:signin_success with user_data
@@ -309,6 +354,10 @@ func (c *Client) handleMessage(message []byte) {
 			c.Send(respPayload)
 		} else {
 			// Failure
+			if result.Unavailable && c.hub.retryAuth {
+				c.closeAuthUnavailable()
+				return
+			}
 			errPayload, _ := json.Marshal(map[string]interface{}{
 				"event": protocol.EventError,
 				"data": map[string]interface{}{
@@ -321,6 +370,86 @@ func (c *Client) handleMessage(message []byte) {
 	}
 }
 
+// closeAuthUnavailable closes the connection with 4101. Pusher clients treat
+// 4100-4199 close codes as "reconnect after backing off" and then resubscribe
+// every channel and sign in again, whereas a pusher:error is never retried.
+func (c *Client) closeAuthUnavailable() {
+	if c.conn != nil {
+		msg := websocket.FormatCloseMessage(protocol.ErrorAuthUnavailable, "Authorization unavailable, retry later")
+		_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+	c.Disconnect()
+}
+
+func (c *Client) subscribeAuthorized(subData SubscribeData, result AuthResult) {
+	if !result.Allowed {
+		if result.Unavailable && c.hub.retryAuth {
+			c.closeAuthUnavailable()
+			return
+		}
+		errMsg, _ := json.Marshal(map[string]interface{}{
+			"event": protocol.EventError,
+			"data": map[string]interface{}{
+				"code":    protocol.ErrorSubscriptionDenied,
+				"message": "Subscription to " + subData.Channel + " rejected",
+			},
+		})
+		c.Send(errMsg)
+		return
+	}
+	c.hub.EnqueueSubscribe(&Subscription{
+		Client:   c,
+		Channel:  subData.Channel,
+		AuthData: result.UserData,
+		Grant:    &subscriptionGrant{auth: subData.Auth, channelData: subData.ChannelData},
+	})
+}
+
+// authorizeAsync runs prepare off the read loop, so the connection's next
+// subscriptions can join the same auth batch, and applies its result after
+// every earlier subscribe or unsubscribe of the connection. Only the read
+// loop calls it, and it blocks while a batch worth of channels is pending, so
+// one connection cannot take over the shared worker concurrency.
+func (c *Client) authorizeAsync(prepare func() func()) {
+	if c.authSlots == nil {
+		c.authSlots = make(chan struct{}, c.hub.pendingAuthLimit())
+	}
+	c.authSlots <- struct{}{}
+
+	prev, done := c.ordered, make(chan struct{})
+	c.ordered = done
+	go func() {
+		defer func() { <-c.authSlots }()
+		defer close(done)
+		apply := prepare()
+		if prev != nil {
+			<-prev
+		}
+		apply()
+	}()
+}
+
+// inOrder runs prepare and applies it now, or behind the pending asynchronous
+// authorizations of the connection.
+func (c *Client) inOrder(prepare func() func()) {
+	if c.ordered != nil {
+		select {
+		case <-c.ordered:
+		default:
+			c.authorizeAsync(prepare)
+			return
+		}
+	}
+	prepare()()
+}
+
+// waitAuthorizations blocks until pending asynchronous authorizations applied.
+func (c *Client) waitAuthorizations() {
+	if c.ordered != nil {
+		<-c.ordered
+	}
+}
+
 func signedInUserID(userData json.RawMessage) string {
 	var data struct {
 		ID json.RawMessage `json:"id"`
@@ -0,0 +1,209 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const DefaultClusterQueryTimeout = 500 * time.Millisecond
+
+// maxConcurrentClusterQueries bounds the read-only queries a hub answers at once.
+const maxConcurrentClusterQueries = 32
+
+// clusterMutationQueueSize bounds the mutating queries waiting for the worker;
+// beyond it the hub loop waits rather than dropping one.
+const clusterMutationQueueSize = 1024
+
+const (
+	ClusterQueryConnections = "connections"
+	ClusterQueryChannels    = "channels"
+	ClusterQueryChannel     = "channel"
+	ClusterTerminateUser    = "terminate_user"
+	ClusterInvalidateAuth   = "invalidate_auth_cache"
+	ClusterUnsubscribe      = "unsubscribe"
+)
+
+// ClusterQuery asks every node for its local view of connections or channels,
+// or tells every node to terminate a user's connections, drop cached auth
+// results or unsubscribe a user or socket from a channel.
+type ClusterQuery struct {
+	ID       string `json:"id"`
+	NodeID   string `json:"node_id"`
+	Kind     string `json:"kind"`
+	Channel  string `json:"channel,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	UserID   string `json:"user_id,omitempty"`
+	SocketID string `json:"socket_id,omitempty"`
+}
+
+// ClusterSnapshot is one node's answer to a ClusterQuery.
+type ClusterSnapshot struct {
+	NodeID       string            `json:"node_id"`
+	Connections  int               `json:"connections,omitempty"`
+	Channels     []ChannelSnapshot `json:"channels,omitempty"`
+	Terminated   int               `json:"terminated,omitempty"`
+	Invalidated  int               `json:"invalidated,omitempty"`
+	Unsubscribed int               `json:"unsubscribed,omitempty"`
+}
+
+// ClusterQueryBroker is implemented by brokers that can scatter a query to every node and gather the replies.
+type ClusterQueryBroker interface {
+	ClusterNodes(ctx context.Context) ([]string, error)
+	QueryCluster(ctx context.Context, msg *BroadcastMessage, expected int) ([]ClusterSnapshot, error)
+	ReplyClusterQuery(ctx context.Context, query ClusterQuery, reply ClusterSnapshot) error
+}
+
+func (h *Hub) clusterQueryBroker() (ClusterQueryBroker, bool) {
+	queries, ok := h.broker.(ClusterQueryBroker)
+	return queries, ok
+}
+
+func (h *Hub) localClusterSnapshot(query ClusterQuery) ClusterSnapshot {
+	snapshot := ClusterSnapshot{NodeID: h.nodeID}
+	switch query.Kind {
+	case ClusterQueryConnections:
+		snapshot.Connections = len(h.ConnectionIDs())
+	case ClusterQueryChannels:
+		snapshot.Channels = h.ChannelSnapshots(query.Prefix)
+	case ClusterQueryChannel:
+		snapshot.Channels = []ChannelSnapshot{h.ChannelSnapshot(query.Channel)}
+	case ClusterTerminateUser:
+		snapshot.Terminated = h.TerminateUserConnections(query.UserID)
+	case ClusterInvalidateAuth:
+		snapshot.Invalidated = h.invalidateAuthCache(query.Channel, query.UserID)
+	case ClusterUnsubscribe:
+		snapshot.Unsubscribed = h.ForceUnsubscribe(query.Channel, query.UserID, query.SocketID)
+	}
+	return snapshot
+}
+
+// QueryCluster returns the local snapshot plus the replies of every live node.
+// The result is partial when a node did not answer before the timeout. Mutating
+// queries are always published; the node list only sets how many replies to
+// wait for.
+func (h *Hub) QueryCluster(ctx context.Context, query ClusterQuery) ([]ClusterSnapshot, bool) {
+	query.ID = newRandomID()
+	query.NodeID = h.nodeID
+	snapshots := []ClusterSnapshot{h.localClusterSnapshot(query)}
+
+	queries, ok := h.clusterQueryBroker()
+	if !ok {
+		return snapshots, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultClusterQueryTimeout)
+	defer cancel()
+
+	nodes, err := queries.ClusterNodes(ctx)
+	unknown := err != nil
+	if unknown {
+		h.logger.Warn("Hub: failed to list cluster nodes", zap.Error(err))
+	}
+	expected := 0
+	for _, nodeID := range nodes {
+		if nodeID != h.nodeID {
+			expected++
+		}
+	}
+	if expected == 0 && !mutatesClusterState(query.Kind) {
+		return snapshots, unknown
+	}
+
+	replies, err := queries.QueryCluster(ctx, &BroadcastMessage{
+		AppID:        h.AppID,
+		Query:        &query,
+		OriginNodeID: h.nodeID,
+	}, expected)
+	if err != nil {
+		h.logger.Warn("Hub: cluster query failed", zap.String("kind", query.Kind), zap.Error(err))
+	}
+	snapshots = append(snapshots, replies...)
+	return snapshots, unknown || len(replies) < expected
+}
+
+// mutatesClusterState reports whether a query kind changes state on the node
+// that answers it, so dropping it would leave the action undone there.
+func mutatesClusterState(kind string) bool {
+	switch kind {
+	case ClusterTerminateUser, ClusterInvalidateAuth, ClusterUnsubscribe:
+		return true
+	default:
+		return false
+	}
+}
+
+// dispatchClusterQuery answers a query from another node without blocking the
+// hub loop. Mutating queries are queued for runClusterMutations and never
+// dropped. Beyond maxConcurrentClusterQueries read-only queries in flight, a
+// snapshot query is dropped and the asking node reports a partial result.
+func (h *Hub) dispatchClusterQuery(query ClusterQuery) {
+	if mutatesClusterState(query.Kind) {
+		select {
+		case h.mutations <- query:
+		case <-h.ctx.Done():
+		}
+		return
+	}
+	select {
+	case h.querySlots <- struct{}{}:
+	default:
+		h.logger.Warn("Hub: too many cluster queries in flight, dropping", zap.String("kind", query.Kind))
+		return
+	}
+	go func() {
+		defer func() { <-h.querySlots }()
+		h.answerClusterQuery(query)
+	}()
+}
+
+// runClusterMutations answers mutating queries from other nodes in order.
+func (h *Hub) runClusterMutations() {
+	for {
+		select {
+		case query := <-h.mutations:
+			h.answerClusterQuery(query)
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *Hub) answerClusterQuery(query ClusterQuery) {
+	queries, ok := h.clusterQueryBroker()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, DefaultClusterQueryTimeout)
+	defer cancel()
+
+	if err := queries.ReplyClusterQuery(ctx, query, h.localClusterSnapshot(query)); err != nil {
+		h.logger.Warn("Hub: failed to answer cluster query", zap.String("kind", query.Kind), zap.Error(err))
+	}
+}
+
+// collectClusterSnapshots queries each distinct cluster once and adds hubs that are not clustered.
+func collectClusterSnapshots(ctx context.Context, hubs []*Hub, query ClusterQuery) ([]ClusterSnapshot, bool) {
+	var snapshots []ClusterSnapshot
+	partial := false
+	queriedScopes := make(map[string]struct{}, len(hubs))
+	for _, hub := range hubs {
+		if _, ok := hub.clusterQueryBroker(); !ok {
+			snapshots = append(snapshots, hub.localClusterSnapshot(query))
+			continue
+		}
+
+		scope := hub.publishScope()
+		if _, seen := queriedScopes[scope]; seen {
+			continue
+		}
+		queriedScopes[scope] = struct{}{}
+
+		result, incomplete := hub.QueryCluster(ctx, query)
+		snapshots = append(snapshots, result...)
+		partial = partial || incomplete
+	}
+	return snapshots, partial
+}
@@ -0,0 +1,252 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func TestManagementAPIAggregatesClusterNodes(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub1 := newRedisTestHub(t, ctx, mr.Addr())
+	hub2 := newRedisTestHub(t, ctx, mr.Addr())
+	if err := RegisterHub("test-app", hub1); err != nil {
+		t.Fatalf("RegisterHub failed: %v", err)
+	}
+	defer UnregisterHub("test-app", hub1)
+	time.Sleep(100 * time.Millisecond)
+
+	remote, _, cancelClient := newManagementClient(hub2, "2.1")
+	defer cancelClient()
+	if !hub2.Register(remote) {
+		t.Fatal("Expected remote client registration to succeed")
+	}
+	defer hub2.Unregister(remote)
+	hub2.getShard("public-room").withSubscriptions(func(sm *SubscriptionManager) {
+		sm.Subscribe(remote, "public-room", nil)
+	})
+
+	module := &WebsocketModule{AppID: "test-app", AppKey: "test-key", AppSecret: "secret"}
+
+	rr := performSignedPusherRequest(t, module, http.MethodGet, "/apps/test-app/connections", nil)
+	var connections map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &connections); err != nil {
+		t.Fatalf("Failed to decode connections response: %v", err)
+	}
+	if connections["connections"] != float64(1) || connections["partial"] != nil {
+		t.Fatalf("Expected one complete cluster connection, got %v", connections)
+	}
+
+	rr = performSignedPusherRequest(t, module, http.MethodGet, "/apps/test-app/channels/public-room?info=subscription_count", nil)
+	var channel map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &channel); err != nil {
+		t.Fatalf("Failed to decode channel response: %v", err)
+	}
+	if channel["occupied"] != true || channel["subscription_count"] != float64(1) {
+		t.Fatalf("Expected channel occupied on the other node, got %v", channel)
+	}
+
+	if err := hub1.broker.(PresenceBroker).RefreshPresenceNode(ctx, "ghost", time.Minute); err != nil {
+		t.Fatalf("RefreshPresenceNode failed: %v", err)
+	}
+
+	rr = performSignedPusherRequest(t, module, http.MethodGet, "/apps/test-app/channels", nil)
+	var channels map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &channels); err != nil {
+		t.Fatalf("Failed to decode channels response: %v", err)
+	}
+	if channels["partial"] != true {
+		t.Fatalf("Expected partial response when a node does not answer, got %v", channels)
+	}
+	if _, ok := channels["channels"].(map[string]any)["public-room"]; !ok {
+		t.Fatalf("Expected replies from answering nodes in partial response, got %v", channels)
+	}
+}
+
+func TestTerminateUserConnectionsAcrossClusterNodes(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub1 := newRedisTestHub(t, ctx, mr.Addr())
+	hub2 := newRedisTestHub(t, ctx, mr.Addr())
+	if err := RegisterHub("test-app", hub1); err != nil {
+		t.Fatalf("RegisterHub failed: %v", err)
+	}
+	defer UnregisterHub("test-app", hub1)
+	time.Sleep(100 * time.Millisecond)
+
+	local, localConn, cancelLocal := newManagementClient(hub1, "1.1")
+	defer cancelLocal()
+	remote, remoteConn, cancelRemote := newManagementClient(hub2, "2.1")
+	defer cancelRemote()
+	for hub, client := range map[*Hub]*Client{hub1: local, hub2: remote} {
+		client.SetUserID("banned")
+		if !hub.Register(client) {
+			t.Fatal("Expected client registration to succeed")
+		}
+	}
+
+	module := &WebsocketModule{AppID: "test-app", AppKey: "test-key", AppSecret: "secret"}
+	rr := performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/users/banned/terminate_connections", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("terminate status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+
+	var response map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode terminate response: %v", err)
+	}
+	if response["terminated"] != float64(2) || response["partial"] != nil {
+		t.Fatalf("Expected two terminated connections across the cluster, got %v", response)
+	}
+	for _, conn := range []*MockWSConnection{localConn, remoteConn} {
+		if !conn.CloseCalled {
+			t.Fatal("Expected terminated connection to be closed")
+		}
+		if len(conn.WriteMsgs) == 0 || !strings.HasPrefix(conn.WriteMsgs[len(conn.WriteMsgs)-1], "[Control:\x0f\xa9") {
+			t.Fatalf("Expected close frame with code 4009, got %q", conn.WriteMsgs)
+		}
+	}
+}
+
+func TestRedisClusterNodesUsesIndexAndPrunesExpired(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx := context.Background()
+	broker := NewRedisBroker(zap.NewNop(), "test-app", mr.Addr(), "", 0, false)
+	defer func() { _ = broker.Close() }()
+	for i := 0; i < 500; i++ {
+		mr.Set("unrelated:"+strconv.Itoa(i), "x")
+	}
+	if err := broker.RefreshPresenceNode(ctx, "alive", time.Minute); err != nil {
+		t.Fatalf("RefreshPresenceNode failed: %v", err)
+	}
+	if err := broker.RefreshPresenceNode(ctx, "expired", time.Millisecond); err != nil {
+		t.Fatalf("RefreshPresenceNode failed: %v", err)
+	}
+	if err := broker.RefreshPresenceNode(ctx, "gone", time.Minute); err != nil {
+		t.Fatalf("RefreshPresenceNode failed: %v", err)
+	}
+	if err := broker.RemovePresenceNode(ctx, "gone"); err != nil {
+		t.Fatalf("RemovePresenceNode failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	nodes, err := broker.ClusterNodes(ctx)
+	if err != nil {
+		t.Fatalf("ClusterNodes failed: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0] != "alive" {
+		t.Fatalf("nodes = %v, want only the live node", nodes)
+	}
+	if members, _ := mr.ZMembers(broker.nodesKey()); len(members) != 1 {
+		t.Fatalf("node index = %v, want expired nodes pruned", members)
+	}
+}
+
+func TestClusterMutationsAreNotDroppedWhenQueriesSaturate(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := newRedisTestHub(t, ctx, mr.Addr())
+	client, _, cancelClient := newManagementClient(hub, "1.1")
+	defer cancelClient()
+	client.SetUserID("banned")
+	if !hub.Register(client) {
+		t.Fatal("Expected client registration to succeed")
+	}
+
+	// Every read-only slot is taken, as under a burst of snapshot queries.
+	for i := 0; i < maxConcurrentClusterQueries; i++ {
+		hub.querySlots <- struct{}{}
+	}
+	hub.dispatchClusterQuery(ClusterQuery{ID: "snapshot", NodeID: "other", Kind: ClusterQueryConnections})
+	hub.dispatchClusterQuery(ClusterQuery{ID: "terminate", NodeID: "other", Kind: ClusterTerminateUser, UserID: "banned"})
+
+	deadline := time.Now().Add(time.Second)
+	for len(hub.ConnectionIDs()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the terminate query to run although the query slots are full")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// unlistedClusterBroker cannot list nodes but still carries queries.
+type unlistedClusterBroker struct {
+	MockBroker
+	queried chan ClusterQuery
+}
+
+func (b *unlistedClusterBroker) ClusterNodes(ctx context.Context) ([]string, error) {
+	return nil, errors.New("node index unavailable")
+}
+
+func (b *unlistedClusterBroker) QueryCluster(ctx context.Context, msg *BroadcastMessage, expected int) ([]ClusterSnapshot, error) {
+	b.queried <- *msg.Query
+	return nil, nil
+}
+
+func (b *unlistedClusterBroker) ReplyClusterQuery(ctx context.Context, query ClusterQuery, reply ClusterSnapshot) error {
+	return nil
+}
+
+func TestQueryClusterPublishesMutationsWhenNodesAreUnknown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &unlistedClusterBroker{queried: make(chan ClusterQuery, 2)}
+	hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), nil, nil, broker, 100, 1, DefaultPingPeriod, DefaultDeliveryConfig())
+
+	if _, partial := hub.QueryCluster(ctx, ClusterQuery{Kind: ClusterTerminateUser, UserID: "banned"}); !partial {
+		t.Fatal("Expected a partial result when the node list is unavailable")
+	}
+	select {
+	case query := <-broker.queried:
+		if query.Kind != ClusterTerminateUser {
+			t.Fatalf("published query kind = %q", query.Kind)
+		}
+	default:
+		t.Fatal("Expected the terminate query to be published anyway")
+	}
+
+	if _, partial := hub.QueryCluster(ctx, ClusterQuery{Kind: ClusterQueryConnections}); !partial {
+		t.Fatal("Expected a partial result when the node list is unavailable")
+	}
+	if len(broker.queried) != 0 {
+		t.Fatal("Expected a snapshot query without known nodes not to be published")
+	}
+}
@@ -0,0 +1,176 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/y-l-g/websocket/module/internal/protocol"
+)
+
+const (
+	EnvelopeFormatJSON   = "json"
+	EnvelopeFormatBinary = "binary"
+)
+
+// Binary envelope layout (version 1):
+//
+//	magic(1) version(1) flags(1)
+//	app_id, channel, event, socket_id, origin_node_id, extensions, data
+//
+// Every field after the header is a uvarint length followed by raw bytes.
+// Extensions hold the JSON-encoded presence update, cluster query, ack request, target, or publish ID, if any.
+const (
+	envelopeMagic          byte = 0xB7
+	envelopeVersion        byte = 1
+	envelopeFlagCompressed byte = 1 << 0
+)
+
+var errEnvelopeTruncated = errors.New("broadcast envelope truncated")
+
+type envelopeExtensions struct {
+	Presence *PresenceUpdate    `json:"presence,omitempty"`
+	Query    *ClusterQuery      `json:"query,omitempty"`
+	Ack      *PublishAckRequest `json:"ack,omitempty"`
+	Target   *PublishTarget     `json:"target,omitempty"`
+	ID       string             `json:"id,omitempty"`
+}
+
+// EncodeBroadcastEnvelope encodes msg in the binary envelope. Data is kept as raw
+// bytes and deflated when compressThreshold is positive and the payload reaches it.
+func EncodeBroadcastEnvelope(msg *BroadcastMessage, compressThreshold int) ([]byte, error) {
+	var ext []byte
+	if msg.Presence != nil || msg.Query != nil || msg.Ack != nil || msg.Target != nil || msg.PublishID != "" {
+		var err error
+		ext, err = json.Marshal(envelopeExtensions{Presence: msg.Presence, Query: msg.Query, Ack: msg.Ack, Target: msg.Target, ID: msg.PublishID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	flags := byte(0)
+	data := []byte(msg.Data)
+	if compressThreshold > 0 && len(data) >= compressThreshold {
+		compressed, err := deflateEnvelopeData(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			data = compressed
+			flags |= envelopeFlagCompressed
+		}
+	}
+
+	fields := [][]byte{
+		[]byte(msg.AppID),
+		[]byte(msg.Channel),
+		[]byte(msg.Event),
+		[]byte(msg.ExceptSocketID),
+		[]byte(msg.OriginNodeID),
+		ext,
+		data,
+	}
+	size := 3
+	for _, field := range fields {
+		size += binary.MaxVarintLen64 + len(field)
+	}
+
+	buf := make([]byte, 3, size)
+	buf[0] = envelopeMagic
+	buf[1] = envelopeVersion
+	buf[2] = flags
+	for _, field := range fields {
+		buf = binary.AppendUvarint(buf, uint64(len(field)))
+		buf = append(buf, field...)
+	}
+	return buf, nil
+}
+
+func isBroadcastEnvelope(data []byte) bool {
+	return len(data) > 0 && data[0] == envelopeMagic
+}
+
+func decodeBroadcastEnvelope(data []byte) (*BroadcastMessage, error) {
+	if len(data) < 3 || data[0] != envelopeMagic {
+		return nil, errEnvelopeTruncated
+	}
+	if data[1] != envelopeVersion {
+		return nil, fmt.Errorf("unsupported broadcast envelope version %d", data[1])
+	}
+	flags := data[2]
+	rest := data[3:]
+
+	fields := make([][]byte, 7)
+	for i := range fields {
+		length, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < length {
+			return nil, errEnvelopeTruncated
+		}
+		fields[i] = rest[n : n+int(length)]
+		rest = rest[n+int(length):]
+	}
+
+	payload := fields[6]
+	if flags&envelopeFlagCompressed != 0 {
+		inflated, err := inflateEnvelopeData(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = inflated
+	} else {
+		payload = append([]byte(nil), payload...)
+	}
+
+	msg := &BroadcastMessage{
+		AppID:          string(fields[0]),
+		Channel:        string(fields[1]),
+		Event:          string(fields[2]),
+		ExceptSocketID: string(fields[3]),
+		OriginNodeID:   string(fields[4]),
+		Data:           json.RawMessage(payload),
+	}
+	if len(fields[5]) > 0 {
+		var ext envelopeExtensions
+		if err := json.Unmarshal(fields[5], &ext); err != nil {
+			return nil, err
+		}
+		msg.Presence = ext.Presence
+		msg.Query = ext.Query
+		msg.Ack = ext.Ack
+		msg.Target = ext.Target
+		msg.PublishID = ext.ID
+	}
+	return msg, nil
+}
+
+func deflateEnvelopeData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func inflateEnvelopeData(data []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer func() { _ = reader.Close() }()
+	inflated, err := io.ReadAll(io.LimitReader(reader, protocol.MaxDataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(inflated) > protocol.MaxDataSize {
+		return nil, errors.New("broadcast envelope payload too large")
+	}
+	return inflated, nil
+}
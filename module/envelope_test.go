@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBroadcastEnvelopeRoundTrip(t *testing.T) {
+	msg := &BroadcastMessage{
+		AppID:          "test-app",
+		Channel:        "private-chat",
+		Event:          "message.sent",
+		Data:           json.RawMessage(`{"text":"hello \"world\""}`),
+		ExceptSocketID: "1.1",
+		OriginNodeID:   "node-1",
+		Presence:       &PresenceUpdate{Action: PresenceActionAdd, Channel: "presence-room", NodeID: "node-1", SocketID: "1.1", UserID: "A"},
+		Target:         &PublishTarget{ExceptUserIDs: []string{"42"}},
+	}
+
+	encoded, err := EncodeBroadcastEnvelope(msg, 0)
+	if err != nil {
+		t.Fatalf("EncodeBroadcastEnvelope failed: %v", err)
+	}
+	decoded, err := DeserializeBroadcast(encoded)
+	if err != nil {
+		t.Fatalf("DeserializeBroadcast failed: %v", err)
+	}
+
+	if decoded.AppID != msg.AppID || decoded.Channel != msg.Channel || decoded.Event != msg.Event ||
+		decoded.ExceptSocketID != msg.ExceptSocketID || decoded.OriginNodeID != msg.OriginNodeID {
+		t.Fatalf("decoded envelope = %+v, want %+v", decoded, msg)
+	}
+	if !bytes.Equal(decoded.Data, msg.Data) {
+		t.Fatalf("decoded data = %s, want %s", decoded.Data, msg.Data)
+	}
+	if decoded.Presence == nil || decoded.Presence.UserID != "A" {
+		t.Fatalf("decoded presence = %+v, want user A", decoded.Presence)
+	}
+	if decoded.Target == nil || len(decoded.Target.ExceptUserIDs) != 1 || decoded.Target.ExceptUserIDs[0] != "42" {
+		t.Fatalf("decoded target = %+v, want except user 42", decoded.Target)
+	}
+}
+
+func TestBroadcastEnvelopeCompressesLargePayloads(t *testing.T) {
+	data := json.RawMessage(`{"text":"` + strings.Repeat("a", 8192) + `"}`)
+	msg := &BroadcastMessage{AppID: "test-app", Channel: "public", Event: "big", Data: data}
+
+	encoded, err := EncodeBroadcastEnvelope(msg, 1024)
+	if err != nil {
+		t.Fatalf("EncodeBroadcastEnvelope failed: %v", err)
+	}
+	if encoded[2]&envelopeFlagCompressed == 0 || len(encoded) >= len(data) {
+		t.Fatalf("Expected compressed envelope smaller than payload, got %d bytes", len(encoded))
+	}
+
+	decoded, err := DeserializeBroadcast(encoded)
+	if err != nil {
+		t.Fatalf("DeserializeBroadcast failed: %v", err)
+	}
+	if !bytes.Equal(decoded.Data, data) {
+		t.Fatal("Expected decompressed payload to match original")
+	}
+}
+
+func TestDeserializeBroadcastAcceptsJSONAndRejectsUnknownVersions(t *testing.T) {
+	legacy, err := SerializeBroadcast(&BroadcastMessage{AppID: "test-app", Channel: "public", Event: "e", Data: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatalf("SerializeBroadcast failed: %v", err)
+	}
+	if msg, err := DeserializeBroadcast(legacy); err != nil || msg.Channel != "public" {
+		t.Fatalf("Expected JSON messages to stay readable, got %+v, %v", msg, err)
+	}
+
+	encoded, err := EncodeBroadcastEnvelope(&BroadcastMessage{Channel: "public"}, 0)
+	if err != nil {
+		t.Fatalf("EncodeBroadcastEnvelope failed: %v", err)
+	}
+	encoded[1] = envelopeVersion + 1
+	if _, err := DeserializeBroadcast(encoded); err == nil {
+		t.Fatal("Expected unknown envelope version to be rejected")
+	}
+	if _, err := DeserializeBroadcast(encoded[:2]); err == nil {
+		t.Fatal("Expected truncated envelope to be rejected")
+	}
+}
+
+func benchmarkBroadcastMessage() *BroadcastMessage {
+	return &BroadcastMessage{
+		AppID:   "bench-app",
+		Channel: "private-orders.42",
+		Event:   "OrderShipped",
+		Data:    json.RawMessage(`{"order":{"id":42,"items":[{"sku":"A-1","qty":2},{"sku":"B-7","qty":1}],"note":"leave at \"door\""}}`),
+	}
+}
+
+func BenchmarkSerializeBroadcastJSON(b *testing.B) {
+	msg := benchmarkBroadcastMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := SerializeBroadcast(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSerializeBroadcastEnvelope(b *testing.B) {
+	msg := benchmarkBroadcastMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeBroadcastEnvelope(msg, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDeserializeBroadcastJSON(b *testing.B) {
+	data, err := SerializeBroadcast(benchmarkBroadcastMessage())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DeserializeBroadcast(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDeserializeBroadcastEnvelope(b *testing.B) {
+	data, err := EncodeBroadcastEnvelope(benchmarkBroadcastMessage(), 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DeserializeBroadcast(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
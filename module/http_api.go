@@ -1,9 +1,7 @@
 package websocket
 
 import (
-	"crypto/hmac"
 	"crypto/md5"
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -19,12 +17,16 @@ const maxHTTPAPIRequestBody = 2 * 1024 * 1024
 const maxHTTPSignatureAge = 5 * time.Minute
 
 type pusherEventRequest struct {
-	Name     string   `json:"name"`
-	Data     string   `json:"data"`
-	Channel  string   `json:"channel"`
-	Channels []string `json:"channels"`
-	SocketID string   `json:"socket_id"`
-	Info     string   `json:"info"`
+	Name           string   `json:"name"`
+	Data           string   `json:"data"`
+	Channel        string   `json:"channel"`
+	Channels       []string `json:"channels"`
+	SocketID       string   `json:"socket_id"`
+	Info           string   `json:"info"`
+	DeliverAt      float64  `json:"deliver_at,omitempty"`
+	CancelKey      string   `json:"cancel_key,omitempty"`
+	IdempotencyKey string   `json:"idempotency_key,omitempty"`
+	PublishTarget
 }
 
 type pusherBatchRequest struct {
@@ -32,18 +34,21 @@ type pusherBatchRequest struct {
 }
 
 type pusherBatchEvent struct {
-	Name     string `json:"name"`
-	Data     string `json:"data"`
-	Channel  string `json:"channel"`
-	SocketID string `json:"socket_id"`
-	Info     string `json:"info"`
+	Name           string `json:"name"`
+	Data           string `json:"data"`
+	Channel        string `json:"channel"`
+	SocketID       string `json:"socket_id"`
+	Info           string `json:"info"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	PublishTarget
 }
 
 type pusherAPIRequest struct {
-	AppID   string
-	Action  string
-	Channel string
-	UserID  string
+	AppID     string
+	Action    string
+	Channel   string
+	UserID    string
+	CancelKey string
 }
 
 func (m *WebsocketModule) servePusherAPI(w http.ResponseWriter, r *http.Request) {
@@ -89,15 +94,21 @@ func (m *WebsocketModule) servePusherAPI(w http.ResponseWriter, r *http.Request)
 	case "batch_events":
 		m.handlePusherBatch(w, body)
 	case "connections":
-		m.handlePusherConnections(w)
+		m.handlePusherConnections(w, r)
 	case "channels":
 		m.handlePusherChannels(w, r)
 	case "channel":
 		m.handlePusherChannel(w, r, apiRequest.Channel)
 	case "channel_users":
-		m.handlePusherChannelUsers(w, apiRequest.Channel)
+		m.handlePusherChannelUsers(w, r, apiRequest.Channel)
 	case "users_terminate":
-		m.handlePusherUserTerminate(w, apiRequest.UserID)
+		m.handlePusherUserTerminate(w, r, apiRequest.UserID)
+	case "channel_unsubscribe":
+		m.handlePusherChannelUnsubscribe(w, r, apiRequest.Channel, body)
+	case "scheduled_event_cancel":
+		m.handlePusherScheduledCancel(w, apiRequest.CancelKey)
+	case "auth_cache_invalidate":
+		m.handlePusherAuthCacheInvalidate(w, r)
 	default:
 		writeJSONError(w, http.StatusNotFound, "not found")
 	}
@@ -131,9 +142,17 @@ func pusherAPIPath(path string) (pusherAPIRequest, bool) {
 	case len(parts) == 4 && parts[1] == "channels" && parts[2] != "" && parts[3] == "users":
 		request.Action = "channel_users"
 		request.Channel = parts[2]
+	case len(parts) == 4 && parts[1] == "channels" && parts[2] != "" && parts[3] == "unsubscribe":
+		request.Action = "channel_unsubscribe"
+		request.Channel = parts[2]
 	case len(parts) == 4 && parts[1] == "users" && parts[2] != "" && parts[3] == "terminate_connections":
 		request.Action = "users_terminate"
 		request.UserID = parts[2]
+	case len(parts) == 3 && parts[1] == "scheduled_events" && parts[2] != "":
+		request.Action = "scheduled_event_cancel"
+		request.CancelKey = parts[2]
+	case len(parts) == 2 && parts[1] == "auth_cache":
+		request.Action = "auth_cache_invalidate"
 	default:
 		return pusherAPIRequest{}, false
 	}
@@ -143,10 +162,12 @@ func pusherAPIPath(path string) (pusherAPIRequest, bool) {
 
 func pusherAPIMethod(action string) string {
 	switch action {
-	case "events", "batch_events", "users_terminate":
+	case "events", "batch_events", "users_terminate", "channel_unsubscribe":
 		return http.MethodPost
 	case "connections", "channels", "channel", "channel_users":
 		return http.MethodGet
+	case "scheduled_event_cancel", "auth_cache_invalidate":
+		return http.MethodDelete
 	default:
 		return ""
 	}
@@ -154,7 +175,8 @@ func pusherAPIMethod(action string) string {
 
 func (m *WebsocketModule) verifyPusherHTTPSignature(r *http.Request, body []byte) bool {
 	query := r.URL.Query()
-	if query.Get("auth_key") != m.AppKey {
+	appKeys := m.appKeys()
+	if !appKeys.HasKey(query.Get("auth_key")) {
 		return false
 	}
 	if query.Get("auth_version") != "1.0" {
@@ -208,11 +230,7 @@ func (m *WebsocketModule) verifyPusherHTTPSignature(r *http.Request, body []byte
 		strings.Join(pairs, "&"),
 	}, "\n")
 
-	mac := hmac.New(sha256.New, []byte(m.AppSecret))
-	mac.Write([]byte(toSign))
-	expected := hex.EncodeToString(mac.Sum(nil))
-
-	return hmac.Equal([]byte(expected), []byte(authSignature))
+	return appKeys.Verify("http_api", query.Get("auth_key"), authSignature, toSign)
 }
 
 func (m *WebsocketModule) handlePusherEvent(w http.ResponseWriter, body []byte) {
@@ -231,24 +249,53 @@ func (m *WebsocketModule) handlePusherEvent(w http.ResponseWriter, body []byte)
 		return
 	}
 
-	options := PublishOptions{ExceptSocketID: request.SocketID}
+	options := PublishOptions{
+		ExceptSocketID: request.SocketID,
+		CancelKey:      request.CancelKey,
+		IdempotencyKey: request.IdempotencyKey,
+		Target:         request.PublishTarget,
+	}
+	if request.DeliverAt > 0 {
+		options.DeliverAt = time.UnixMilli(int64(request.DeliverAt * 1000))
+	}
+	if request.CancelKey != "" && len(channels) > 1 {
+		writeJSONError(w, http.StatusUnprocessableEntity, "cancel_key requires a single channel")
+		return
+	}
+	deduplicated := []string{}
 	for _, channel := range channels {
 		if channel == "" {
 			writeJSONError(w, http.StatusUnprocessableEntity, "channel must not be empty")
 			return
 		}
-		if status := publishToActiveHubsWithOptions(GetHubs(m.AppID), channel, request.Name, request.Data, options); status != PublishOK {
+		status := publishToActiveHubsWithOptions(GetHubs(m.AppID), channel, request.Name, request.Data, options)
+		if status == PublishDeduplicated {
+			deduplicated = append(deduplicated, channel)
+			continue
+		}
+		if status != PublishOK {
 			writePublishError(w, status)
 			return
 		}
 	}
 
+	response := map[string]any{}
 	if request.Info != "" {
-		writeJSON(w, http.StatusOK, map[string]any{"channels": channelResponses(m.AppID, channels, request.Info)})
-		return
+		response["channels"] = channelResponses(m.AppID, channels, request.Info)
+	}
+	if len(deduplicated) > 0 {
+		response["deduplicated_channels"] = deduplicated
 	}
+	writeJSON(w, http.StatusOK, response)
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{})
+func (m *WebsocketModule) handlePusherScheduledCancel(w http.ResponseWriter, cancelKey string) {
+	hubs := GetHubs(m.AppID)
+	if len(hubs) == 0 {
+		writePublishError(w, PublishHubMissing)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"cancelled": cancelScheduledOnActiveHubs(hubs, cancelKey)})
 }
 
 func (m *WebsocketModule) handlePusherBatch(w http.ResponseWriter, body []byte) {
@@ -262,7 +309,7 @@ func (m *WebsocketModule) handlePusherBatch(w http.ResponseWriter, body []byte)
 		return
 	}
 
-	hasInfo := false
+	listed := false
 	infoResponses := make([]map[string]any, 0, len(request.Batch))
 	for _, item := range request.Batch {
 		if item.Name == "" || item.Data == "" || item.Channel == "" {
@@ -270,7 +317,7 @@ func (m *WebsocketModule) handlePusherBatch(w http.ResponseWriter, body []byte)
 			return
 		}
 		if item.Info != "" {
-			hasInfo = true
+			listed = true
 			infoResponses = append(infoResponses, channelSnapshotResponse(
 				collectChannelSnapshot(GetHubs(m.AppID), item.Channel),
 				parseInfo(item.Info),
@@ -279,14 +326,20 @@ func (m *WebsocketModule) handlePusherBatch(w http.ResponseWriter, body []byte)
 		} else {
 			infoResponses = append(infoResponses, map[string]any{})
 		}
-		options := PublishOptions{ExceptSocketID: item.SocketID}
-		if status := publishToActiveHubsWithOptions(GetHubs(m.AppID), item.Channel, item.Name, item.Data, options); status != PublishOK {
+		options := PublishOptions{ExceptSocketID: item.SocketID, IdempotencyKey: item.IdempotencyKey, Target: item.PublishTarget}
+		status := publishToActiveHubsWithOptions(GetHubs(m.AppID), item.Channel, item.Name, item.Data, options)
+		if status == PublishDeduplicated {
+			listed = true
+			infoResponses[len(infoResponses)-1]["deduplicated"] = true
+			continue
+		}
+		if status != PublishOK {
 			writePublishError(w, status)
 			return
 		}
 	}
 
-	if hasInfo {
+	if listed {
 		writeJSON(w, http.StatusOK, map[string]any{"batch": infoResponses})
 		return
 	}
@@ -303,36 +356,43 @@ func channelResponses(appID string, channels []string, info string) map[string]m
 	return responses
 }
 
-func (m *WebsocketModule) handlePusherConnections(w http.ResponseWriter) {
-	writeJSON(w, http.StatusOK, map[string]int{
-		"connections": countConnections(GetHubs(m.AppID)),
-	})
+func (m *WebsocketModule) handlePusherConnections(w http.ResponseWriter, r *http.Request) {
+	snapshots, partial := collectClusterSnapshots(r.Context(), GetHubs(m.AppID), ClusterQuery{Kind: ClusterQueryConnections})
+	connections := 0
+	for _, snapshot := range snapshots {
+		connections += snapshot.Connections
+	}
+	writeClusterJSON(w, http.StatusOK, map[string]any{"connections": connections}, partial)
 }
 
 func (m *WebsocketModule) handlePusherChannels(w http.ResponseWriter, r *http.Request) {
 	info := parseInfo(r.URL.Query().Get("info"))
 	channels := map[string]map[string]any{}
 
-	for _, snapshot := range collectChannelSnapshots(GetHubs(m.AppID), r.URL.Query().Get("filter_by_prefix")) {
+	snapshots, partial := collectClusterSnapshots(r.Context(), GetHubs(m.AppID), ClusterQuery{
+		Kind:   ClusterQueryChannels,
+		Prefix: r.URL.Query().Get("filter_by_prefix"),
+	})
+	for _, snapshot := range mergeClusterChannels(snapshots) {
 		channels[snapshot.Name] = channelSnapshotResponse(snapshot, info, false)
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{"channels": channels})
+	writeClusterJSON(w, http.StatusOK, map[string]any{"channels": channels}, partial)
 }
 
 func (m *WebsocketModule) handlePusherChannel(w http.ResponseWriter, r *http.Request, channel string) {
-	snapshot := collectChannelSnapshot(GetHubs(m.AppID), channel)
-	writeJSON(w, http.StatusOK, channelSnapshotResponse(snapshot, parseInfo(r.URL.Query().Get("info")), true))
+	snapshot, partial := m.clusterChannelSnapshot(r, channel)
+	writeClusterJSON(w, http.StatusOK, channelSnapshotResponse(snapshot, parseInfo(r.URL.Query().Get("info")), true), partial)
 }
 
-func (m *WebsocketModule) handlePusherChannelUsers(w http.ResponseWriter, channel string) {
-	snapshot := collectChannelSnapshot(GetHubs(m.AppID), channel)
-	if snapshot.SubscriptionCount == 0 {
-		writeJSON(w, http.StatusNotFound, map[string]any{})
+func (m *WebsocketModule) handlePusherChannelUsers(w http.ResponseWriter, r *http.Request, channel string) {
+	snapshot, partial := m.clusterChannelSnapshot(r, channel)
+	if !snapshot.occupied() {
+		writeClusterJSON(w, http.StatusNotFound, map[string]any{}, partial)
 		return
 	}
 	if !snapshot.Presence {
-		writeJSON(w, http.StatusBadRequest, map[string]any{})
+		writeClusterJSON(w, http.StatusBadRequest, map[string]any{}, partial)
 		return
 	}
 
@@ -340,42 +400,79 @@ func (m *WebsocketModule) handlePusherChannelUsers(w http.ResponseWriter, channe
 	for _, userID := range snapshot.UserIDs {
 		users = append(users, map[string]string{"id": userID})
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"users": users})
+	writeClusterJSON(w, http.StatusOK, map[string]any{"users": users}, partial)
+}
+
+func (m *WebsocketModule) clusterChannelSnapshot(r *http.Request, channel string) (ChannelSnapshot, bool) {
+	snapshots, partial := collectClusterSnapshots(r.Context(), GetHubs(m.AppID), ClusterQuery{
+		Kind:    ClusterQueryChannel,
+		Channel: channel,
+	})
+	combined := ChannelSnapshot{Name: channel}
+	for _, snapshot := range mergeClusterChannels(snapshots) {
+		combined = mergeChannelSnapshots(combined, snapshot)
+	}
+	combined.Name = channel
+	return combined, partial
 }
 
-func (m *WebsocketModule) handlePusherUserTerminate(w http.ResponseWriter, userID string) {
-	for _, hub := range GetHubs(m.AppID) {
-		hub.TerminateUserConnections(userID)
+func (m *WebsocketModule) handlePusherUserTerminate(w http.ResponseWriter, r *http.Request, userID string) {
+	snapshots, partial := collectClusterSnapshots(r.Context(), GetHubs(m.AppID), ClusterQuery{
+		Kind:   ClusterTerminateUser,
+		UserID: userID,
+	})
+	terminated := 0
+	for _, snapshot := range snapshots {
+		terminated += snapshot.Terminated
 	}
-	writeJSON(w, http.StatusOK, map[string]any{})
+	writeClusterJSON(w, http.StatusOK, map[string]any{"terminated": terminated}, partial)
 }
 
-func countConnections(hubs []*Hub) int {
-	ids := map[string]struct{}{}
-	for _, hub := range hubs {
-		for _, id := range hub.ConnectionIDs() {
-			ids[id] = struct{}{}
-		}
+// handlePusherChannelUnsubscribe removes a user's connections, or a single
+// socket, from a channel on every node.
+func (m *WebsocketModule) handlePusherChannelUnsubscribe(w http.ResponseWriter, r *http.Request, channel string, body []byte) {
+	var request struct {
+		UserID   string `json:"user_id"`
+		SocketID string `json:"socket_id"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid json")
+		return
 	}
-	return len(ids)
+	if request.UserID == "" && request.SocketID == "" {
+		writeJSONError(w, http.StatusUnprocessableEntity, "user_id or socket_id is required")
+		return
+	}
+
+	unsubscribed, partial := unsubscribeOnHubs(r.Context(), GetHubs(m.AppID), channel, request.UserID, request.SocketID)
+	writeClusterJSON(w, http.StatusOK, map[string]any{"unsubscribed": unsubscribed}, partial)
+}
+
+// handlePusherAuthCacheInvalidate drops cached auth results on every node,
+// optionally only for the channel and user_id query parameters. user_id only
+// matches entries of signed-in clients and presence members.
+func (m *WebsocketModule) handlePusherAuthCacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	invalidated, partial := invalidateAuthCacheOnHubs(r.Context(), GetHubs(m.AppID), query.Get("channel"), query.Get("user_id"))
+	writeClusterJSON(w, http.StatusOK, map[string]any{"invalidated": invalidated}, partial)
 }
 
-func collectChannelSnapshots(hubs []*Hub, filterByPrefix string) []ChannelSnapshot {
+func mergeClusterChannels(snapshots []ClusterSnapshot) []ChannelSnapshot {
 	combined := map[string]ChannelSnapshot{}
-	for _, hub := range hubs {
-		for _, snapshot := range hub.ChannelSnapshots(filterByPrefix) {
+	for _, node := range snapshots {
+		for _, snapshot := range node.Channels {
 			combined[snapshot.Name] = mergeChannelSnapshots(combined[snapshot.Name], snapshot)
 		}
 	}
 
-	snapshots := make([]ChannelSnapshot, 0, len(combined))
+	channels := make([]ChannelSnapshot, 0, len(combined))
 	for _, snapshot := range combined {
-		snapshots = append(snapshots, snapshot)
+		channels = append(channels, snapshot)
 	}
-	sort.Slice(snapshots, func(i, j int) bool {
-		return snapshots[i].Name < snapshots[j].Name
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
 	})
-	return snapshots
+	return channels
 }
 
 func collectChannelSnapshot(hubs []*Hub, channel string) ChannelSnapshot {
@@ -425,7 +522,7 @@ func parseInfo(info string) map[string]bool {
 
 func channelSnapshotResponse(snapshot ChannelSnapshot, info map[string]bool, includeOccupied bool) map[string]any {
 	response := map[string]any{}
-	occupied := snapshot.SubscriptionCount > 0
+	occupied := snapshot.occupied()
 	if includeOccupied {
 		response["occupied"] = occupied
 	}
@@ -470,6 +567,18 @@ func publishStatusReason(status PublishStatus) string {
 		return "broker_queue_full"
 	case PublishShardQueueFull:
 		return "shard_queue_full"
+	case PublishAckTimeout:
+		return "ack_timeout"
+	case PublishInvalidSchedule:
+		return "invalid_schedule"
+	case PublishDeduplicated:
+		return "deduplicated"
+	case PublishInvalidTarget:
+		return "invalid_target"
+	case PublishInvalidOptions:
+		return "invalid_options"
+	case PublishAckUnsupported:
+		return "ack_unsupported"
 	case PublishOK:
 		return "ok"
 	default:
@@ -481,9 +590,9 @@ func httpStatusForPublishStatus(status PublishStatus) int {
 	switch status {
 	case PublishHubMissing:
 		return http.StatusNotFound
-	case PublishChannelTooLong, PublishEventTooLong, PublishPayloadTooLarge, PublishInvalidPayloadJSON, PublishInvalidChannelsJSON:
+	case PublishChannelTooLong, PublishEventTooLong, PublishPayloadTooLarge, PublishInvalidPayloadJSON, PublishInvalidChannelsJSON, PublishInvalidSchedule, PublishInvalidTarget, PublishAckUnsupported:
 		return http.StatusUnprocessableEntity
-	case PublishBrokerQueueFull, PublishShardQueueFull:
+	case PublishBrokerQueueFull, PublishShardQueueFull, PublishAckTimeout:
 		return http.StatusServiceUnavailable
 	case PublishBrokerFailed:
 		return http.StatusInternalServerError
@@ -496,6 +605,14 @@ func writeJSONError(w http.ResponseWriter, status int, message string) {
 	writeJSON(w, status, map[string]string{"error": message})
 }
 
+// writeClusterJSON marks responses that are missing replies from some cluster nodes.
+func writeClusterJSON(w http.ResponseWriter, status int, payload map[string]any, partial bool) {
+	if partial {
+		payload["partial"] = true
+	}
+	writeJSON(w, status, payload)
+}
+
 func writeJSON(w http.ResponseWriter, status int, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -52,6 +52,31 @@ func TestPusherAPIEventPublishesWithSocketExclusion(t *testing.T) {
 	}
 }
 
+func TestPusherAPIEventPublishesWithTarget(t *testing.T) {
+	module, broker, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+
+	body := []byte(`{"name":"comment.added","data":"{}","channel":"presence-post.1","except_user_ids":["42"],"except_socket_ids":["1.1","1.2"]}`)
+	rr := performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/events", body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+	msg := readPublishedMessage(t, broker.published)
+	if msg.Target == nil || len(msg.Target.ExceptUserIDs) != 1 || len(msg.Target.ExceptSocketIDs) != 2 {
+		t.Fatalf("Target = %+v, want except user 42 and sockets 1.1, 1.2", msg.Target)
+	}
+
+	userIDs := make([]string, maxPublishTargetIDs+1)
+	for i := range userIDs {
+		userIDs[i] = strconv.Itoa(i)
+	}
+	tooMany, _ := json.Marshal(map[string]any{"name": "comment.added", "data": "{}", "channel": "presence-post.1", "user_ids": userIDs})
+	rr = performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/events", tooMany)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("oversized target status = %d, want 422", rr.Code)
+	}
+}
+
 func TestPusherAPIBatchPublishesItems(t *testing.T) {
 	module, broker, cleanup := newHTTPAPITestModule(t)
 	defer cleanup()
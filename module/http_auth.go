@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const DefaultAuthConnectTimeout = time.Second
+
+// HTTPAuthConfig points the auth fallback at an upstream URL instead of a
+// FrankenPHP worker. Only the listed headers and cookies of the client's
+// handshake are forwarded; Headers defaults to Authorization.
+type HTTPAuthConfig struct {
+	URL            string
+	Headers        []string
+	Cookies        []string
+	Timeout        time.Duration
+	ConnectTimeout time.Duration
+}
+
+// HTTPAuthDispatcher is a RequestDispatcher that posts auth requests to an
+// upstream endpoint.
+type HTTPAuthDispatcher struct {
+	url     string
+	client  *http.Client
+	headers []string
+	cookies map[string]struct{}
+	maxBody int64
+}
+
+// NewHTTPAuthProvider returns a WorkerAuthProvider whose worker is an upstream
+// HTTP endpoint, so responses are validated, rate limited and circuit broken
+// exactly like worker answers.
+func NewHTTPAuthProvider(logger *zap.Logger, metrics *Metrics, config HTTPAuthConfig, appKey string, maxAuthBody int, maxConcurrent int, secret string) (*WorkerAuthProvider, error) {
+	dispatcher, err := NewHTTPAuthDispatcher(config, maxAuthBody)
+	if err != nil {
+		return nil, err
+	}
+	provider := NewWorkerAuthProvider(logger, metrics, dispatcher, appKey, config.URL, maxAuthBody, maxConcurrent, secret)
+	if config.Timeout > 0 {
+		provider.timeout = config.Timeout
+	}
+	return provider, nil
+}
+
+func NewHTTPAuthDispatcher(config HTTPAuthConfig, maxBody int) (*HTTPAuthDispatcher, error) {
+	parsed, err := url.Parse(config.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("auth_url must be an absolute http or https URL")
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultAuthTimeout
+	}
+	connectTimeout := config.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultAuthConnectTimeout
+	}
+
+	headers := config.Headers
+	if len(headers) == 0 {
+		headers = []string{"Authorization"}
+	}
+	canonical := make([]string, 0, len(headers))
+	for _, header := range headers {
+		if strings.EqualFold(header, "Cookie") {
+			continue // cookies are filtered by name below
+		}
+		canonical = append(canonical, http.CanonicalHeaderKey(header))
+	}
+	cookies := make(map[string]struct{}, len(config.Cookies))
+	for _, cookie := range config.Cookies {
+		cookies[cookie] = struct{}{}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+
+	return &HTTPAuthDispatcher{
+		url:     config.URL,
+		client:  &http.Client{Transport: transport, Timeout: timeout},
+		headers: canonical,
+		cookies: cookies,
+		maxBody: int64(maxBody),
+	}, nil
+}
+
+func (d *HTTPAuthDispatcher) SendRequest(w http.ResponseWriter, r *http.Request) error {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, d.url, r.Body)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"Content-Type", "Accept", "X-FrankenPHP-WS-Channel"} {
+		req.Header.Set(name, r.Header.Get(name))
+	}
+	for _, name := range d.headers {
+		if values := r.Header.Values(name); len(values) > 0 {
+			req.Header[name] = values
+		}
+	}
+	if len(d.cookies) > 0 {
+		for _, cookie := range (&http.Request{Header: r.Header}).Cookies() {
+			if _, ok := d.cookies[cookie.Name]; ok {
+				req.AddCookie(cookie)
+			}
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	w.WriteHeader(resp.StatusCode)
+	// One byte past the limit lets the capturer report the overflow.
+	_, err = io.Copy(w, io.LimitReader(resp.Body, d.maxBody+1))
+	return err
+}
@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func TestHTTPAuthProviderForwardsAllowlistedCredentials(t *testing.T) {
+	var forwarded http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Clone()
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(channelStringToSign(body["socket_id"], body["channel_name"], "")))
+		_ = json.NewEncoder(w).Encode(map[string]string{"auth": "test-key:" + hex.EncodeToString(mac.Sum(nil))})
+	}))
+	defer upstream.Close()
+
+	auth, err := NewHTTPAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), HTTPAuthConfig{
+		URL:     upstream.URL + "/broadcasting/auth",
+		Headers: []string{"authorization"},
+		Cookies: []string{"laravel_session"},
+	}, "test-key", 1024, 100, "secret")
+	if err != nil {
+		t.Fatalf("NewHTTPAuthProvider returned error: %v", err)
+	}
+
+	client := &Client{ID: "1.1", Headers: http.Header{
+		"Authorization": {"Bearer abc"},
+		"Cookie":        {"laravel_session=s1; tracking=t1"},
+		"X-Internal":    {"secret"},
+	}}
+	if res := auth.Authorize(client, "private-orders", "", ""); !res.Allowed {
+		t.Fatal("Expected upstream answer to authorize the channel")
+	}
+	if forwarded.Get("Authorization") != "Bearer abc" || forwarded.Get("Cookie") != "laravel_session=s1" {
+		t.Fatalf("forwarded Authorization %q and Cookie %q", forwarded.Get("Authorization"), forwarded.Get("Cookie"))
+	}
+	if forwarded.Get("X-Internal") != "" || forwarded.Get("X-FrankenPHP-WS-Channel") != "private-orders" {
+		t.Fatalf("forwarded headers = %v", forwarded)
+	}
+}
+
+func TestHTTPAuthProviderTimesOutAndTripsBreaker(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	auth, err := NewHTTPAuthProvider(zap.NewNop(), metrics, HTTPAuthConfig{
+		URL:     upstream.URL,
+		Timeout: 20 * time.Millisecond,
+	}, "test-key", 1024, 100, "secret")
+	if err != nil {
+		t.Fatalf("NewHTTPAuthProvider returned error: %v", err)
+	}
+
+	client := &Client{ID: "1.1"}
+	for range CBThreshold + 2 {
+		if res := auth.Authorize(client, "private-orders", "", ""); res.Allowed {
+			t.Fatal("Expected a timed out upstream to deny")
+		}
+	}
+	if got := calls.Load(); got != CBThreshold {
+		t.Fatalf("upstream calls = %d, want %d before the breaker opens", got, CBThreshold)
+	}
+	if got := counterValue(t, metrics.BreakerTripped); got != 2 {
+		t.Fatalf("breaker rejections = %v, want 2", got)
+	}
+}
+
+func TestHTTPAuthDispatcherRejectsRelativeURL(t *testing.T) {
+	if _, err := NewHTTPAuthDispatcher(HTTPAuthConfig{URL: "/broadcasting/auth"}, 1024); err == nil {
+		t.Fatal("Expected a relative auth URL to be rejected")
+	}
+}
@@ -41,8 +41,23 @@ const (
 	PublishInvalidChannelsJSON
 	PublishBrokerQueueFull
 	PublishShardQueueFull
+	PublishAckTimeout
+	PublishInvalidSchedule
+	PublishDeduplicated
+	PublishInvalidTarget
+	PublishInvalidOptions
+	PublishAckUnsupported
 )
 
+// isControl reports whether msg carries presence, cluster query or ack state
+// that must not be dropped or buffered like a plain broadcast.
+func (m *BroadcastMessage) isControl() bool {
+	return m.Presence != nil || m.Query != nil || m.Ack != nil
+}
+
+// maxPublishTargetIDs bounds each list of a PublishTarget.
+const maxPublishTargetIDs = 100
+
 type hubSet struct {
 	mu   sync.RWMutex
 	hubs map[*Hub]struct{}
@@ -88,25 +103,36 @@ func GetHubs(appID string) []*Hub {
 }
 
 type Hub struct {
-	AppID   string
-	auth    AuthProvider
-	broker  Broker
-	logger  *zap.Logger
-	metrics *Metrics
-	ctx     context.Context
-	shards  []*HubShard
+	AppID     string
+	auth      AuthProvider
+	broker    Broker
+	logger    *zap.Logger
+	metrics   *Metrics
+	ctx       context.Context
+	shards    []*HubShard
+	nodeID    string
+	presence  *clusterPresence
+	scheduler *publishScheduler
+	deduper   *publishDeduper
 
 	// Config
 	maxConnections  int64
 	numShards       int
 	activityTimeout int // Seconds
 	shutdownTimeout time.Duration
+	reauthInterval  time.Duration
+	retryAuth       bool
+	policy          *ChannelPolicy
+	maxUserConns    int
 	healthy         atomic.Bool
 	healthErr       atomic.Value
+	brokerState     atomic.Value
+	lastMessageAt   atomic.Int64
 
 	// Synchronization
 	clientsMu sync.RWMutex
 	clients   map[*Client]bool
+	userConns map[string]int
 	conns     atomic.Int64
 	wg        sync.WaitGroup
 	done      chan struct{}
@@ -114,6 +140,10 @@ type Hub struct {
 	clientMessage chan *ClientMessageWrapper
 	subscribe     chan *Subscription
 	unsubscribe   chan *Subscription
+	clientRelay   chan *BroadcastMessage
+	pendingAcks   sync.Map
+	querySlots    chan struct{}
+	mutations     chan ClusterQuery
 }
 
 type BroadcastMessage struct {
@@ -122,6 +152,12 @@ type BroadcastMessage struct {
 	Event             string             `json:"event"`
 	Data              json.RawMessage    `json:"data"`
 	ExceptSocketID    string             `json:"socket_id,omitempty"`
+	Presence          *PresenceUpdate    `json:"presence,omitempty"`
+	OriginNodeID      string             `json:"origin_node_id,omitempty"`
+	PublishID         string             `json:"publish_id,omitempty"`
+	Query             *ClusterQuery      `json:"query,omitempty"`
+	Ack               *PublishAckRequest `json:"ack,omitempty"`
+	Target            *PublishTarget     `json:"target,omitempty"`
 	InternalCreatedAt time.Time          `json:"-"`
 	BrokerReceivedAt  time.Time          `json:"-"`
 	ShardBroadcastAt  time.Time          `json:"-"`
@@ -130,12 +166,39 @@ type BroadcastMessage struct {
 
 type PublishOptions struct {
 	ExceptSocketID string
+	Ack            PublishAck
+	AckTimeout     time.Duration
+	DeliverAt      time.Time
+	CancelKey      string
+	IdempotencyKey string
+	Target         PublishTarget
+}
+
+// PublishTarget narrows delivery within a channel. User IDs match the user a
+// connection joined a presence channel as, or else the user it signed in as.
+type PublishTarget struct {
+	ExceptSocketIDs []string `json:"except_socket_ids,omitempty"`
+	ExceptUserIDs   []string `json:"except_user_ids,omitempty"`
+	UserIDs         []string `json:"user_ids,omitempty"`
+}
+
+func (t PublishTarget) empty() bool {
+	return len(t.ExceptSocketIDs) == 0 && len(t.ExceptUserIDs) == 0 && len(t.UserIDs) == 0
+}
+
+func (t PublishTarget) valid() bool {
+	return len(t.ExceptSocketIDs) <= maxPublishTargetIDs &&
+		len(t.ExceptUserIDs) <= maxPublishTargetIDs &&
+		len(t.UserIDs) <= maxPublishTargetIDs
 }
 
 type Subscription struct {
 	Client   *Client
 	Channel  string
 	AuthData json.RawMessage
+	// Grant holds the credentials a private or presence subscription was
+	// authorized with, so it can be re-checked later.
+	Grant *subscriptionGrant
 }
 
 type ClientMessageWrapper struct {
@@ -154,6 +217,18 @@ type DeliveryConfig struct {
 	BrokerQueueSize    int
 	ShardQueueSize     int
 	ShutdownTimeout    time.Duration
+	IdempotencyWindow  time.Duration
+	// ReauthInterval re-checks private and presence subscriptions; zero disables it.
+	ReauthInterval time.Duration
+	// Per-user and per-channel limits; zero means unlimited.
+	MaxConnectionsPerUser         int
+	MaxSubscriptionsPerConnection int
+	MaxPresenceMembers            int
+	// RetryUnavailableAuth closes the connection with 4101 when the auth
+	// backend could not decide, so clients reconnect, instead of a 4009 denial.
+	RetryUnavailableAuth bool
+	// ChannelPolicy restricts channels by pattern; nil allows everything.
+	ChannelPolicy *ChannelPolicy
 }
 
 func DefaultDeliveryConfig() DeliveryConfig {
@@ -166,6 +241,7 @@ func DefaultDeliveryConfig() DeliveryConfig {
 		BrokerQueueSize:    DefaultBrokerQueueSize,
 		ShardQueueSize:     DefaultShardQueueSize,
 		ShutdownTimeout:    DefaultShutdownTimeout,
+		IdempotencyWindow:  DefaultIdempotencyWindow,
 	}
 }
 
@@ -192,6 +268,9 @@ func (c DeliveryConfig) withDefaults() DeliveryConfig {
 	if c.ShutdownTimeout <= 0 {
 		c.ShutdownTimeout = defaults.ShutdownTimeout
 	}
+	if c.IdempotencyWindow <= 0 {
+		c.IdempotencyWindow = defaults.IdempotencyWindow
+	}
 	return c
 }
 
@@ -224,16 +303,45 @@ func NewHub(appID string, logger *zap.Logger, ctx context.Context, metrics *Metr
 		numShards:       numShards,
 		activityTimeout: timeoutSec,
 		shutdownTimeout: delivery.ShutdownTimeout,
+		reauthInterval:  delivery.ReauthInterval,
+		retryAuth:       delivery.RetryUnavailableAuth,
+		policy:          delivery.ChannelPolicy,
+		maxUserConns:    delivery.MaxConnectionsPerUser,
 		done:            make(chan struct{}),
 		clientMessage:   make(chan *ClientMessageWrapper, delivery.ShardQueueSize),
 		subscribe:       make(chan *Subscription, delivery.ShardQueueSize),
 		unsubscribe:     make(chan *Subscription, delivery.ShardQueueSize),
 		shards:          make([]*HubShard, numShards),
 		clients:         make(map[*Client]bool),
+		userConns:       make(map[string]int),
+		querySlots:      make(chan struct{}, maxConcurrentClusterQueries),
+		mutations:       make(chan ClusterQuery, clusterMutationQueueSize),
+		nodeID:          newRandomID(),
+	}
+	h.presence = newClusterPresence(appID, h.nodeID, broker, logger, metrics)
+	h.scheduler = newPublishScheduler(h)
+	h.deduper = newPublishDeduper(broker, logger, delivery.IdempotencyWindow)
+	h.brokerState.Store(BrokerConnected)
+	if stateful, ok := broker.(StatefulBroker); ok {
+		stateful.SetStateHandler(h.setBrokerState)
+	}
+	if h.supportsClusterFanout() {
+		h.clientRelay = make(chan *BroadcastMessage, delivery.BrokerQueueSize)
 	}
 
 	for i := 0; i < numShards; i++ {
 		h.shards[i] = NewHubShard(i, appID, logger, ctx, metrics, webhook, delivery.ShardQueueSize)
+		if h.presence != nil {
+			h.shards[i].subs.replicator = h.presence
+		}
+		if h.clientRelay != nil {
+			h.shards[i].relay = h.relayClientEvent
+		}
+		h.shards[i].subs.limits = subscriptionLimits{
+			perConnection:   delivery.MaxSubscriptionsPerConnection,
+			presenceMembers: delivery.MaxPresenceMembers,
+		}
+		h.shards[i].subs.policy = delivery.ChannelPolicy
 		go h.shards[i].Run()
 	}
 
@@ -292,6 +400,7 @@ func (h *Hub) Unregister(c *Client) {
 		return
 	}
 	delete(h.clients, c)
+	h.releaseUserLocked(c.UserID())
 	h.clientsMu.Unlock()
 
 	h.conns.Add(-1)
@@ -309,6 +418,41 @@ func (h *Hub) Unregister(c *Client) {
 	h.wg.Done()
 }
 
+// SignInUser records a registered client as a connection of userID, and
+// reports false when the user already has max_connections_per_user of them.
+func (h *Hub) SignInUser(c *Client, userID string) bool {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+
+	current := c.UserID()
+	if current == userID {
+		return true
+	}
+	if h.maxUserConns > 0 && h.userConns[userID] >= h.maxUserConns {
+		if h.metrics != nil {
+			h.metrics.LimitRejections.WithLabelValues("user_connections").Inc()
+		}
+		return false
+	}
+	if _, registered := h.clients[c]; registered {
+		h.releaseUserLocked(current)
+		h.userConns[userID]++
+	}
+	c.SetUserID(userID)
+	return true
+}
+
+func (h *Hub) releaseUserLocked(userID string) {
+	if userID == "" {
+		return
+	}
+	if h.userConns[userID] <= 1 {
+		delete(h.userConns, userID)
+		return
+	}
+	h.userConns[userID]--
+}
+
 func (h *Hub) ConnectionIDs() []string {
 	h.clientsMu.RLock()
 	defer h.clientsMu.RUnlock()
@@ -349,7 +493,11 @@ func (h *Hub) TerminateUserConnections(userID string) int {
 		}
 	}
 
+	closeMsg := websocket.FormatCloseMessage(protocol.ErrorSubscriptionDenied, "connection terminated")
 	for client := range clients {
+		if client.conn != nil {
+			_ = client.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		}
 		client.Disconnect()
 		h.Unregister(client)
 	}
@@ -371,6 +519,27 @@ func (h *Hub) Authorize(client *Client, channel string, auth string, channelData
 	return h.auth.Authorize(client, channel, auth, channelData)
 }
 
+func (h *Hub) policyRejected(reason string) {
+	if h.metrics != nil {
+		h.metrics.PolicyRejections.WithLabelValues(reason).Inc()
+	}
+}
+
+// batchesAuth reports whether the auth provider coalesces concurrent
+// authorizations, in which case clients authorize without blocking their reads.
+func (h *Hub) batchesAuth() bool {
+	batcher, ok := h.auth.(AuthBatcher)
+	return ok && batcher.BatchesAuthorization()
+}
+
+// pendingAuthLimit bounds the asynchronous authorizations of one connection.
+func (h *Hub) pendingAuthLimit() int {
+	if limiter, ok := h.auth.(interface{ AuthBatchLimit() int }); ok && limiter.AuthBatchLimit() > 0 {
+		return limiter.AuthBatchLimit()
+	}
+	return DefaultAuthBatchSize
+}
+
 func (h *Hub) EnqueueSubscribe(sub *Subscription) bool {
 	select {
 	case h.subscribe <- sub:
@@ -438,11 +607,27 @@ func (h *Hub) publishWithOptions(channel, event, data string, options PublishOpt
 		return PublishPayloadTooLarge
 	}
 
+	if !h.policy.AllowsPayload(channel, len(data)) {
+		h.logger.Warn("Hub: publish failed, payload exceeds the channel policy", zap.String("channel", channel), zap.Int("length", len(data)))
+		h.policyRejected("max_payload")
+		return PublishPayloadTooLarge
+	}
+
 	if !json.Valid([]byte(data)) {
 		h.logger.Error("Hub: publish failed, data payload is not valid JSON")
 		return PublishInvalidPayloadJSON
 	}
 
+	if !options.DeliverAt.IsZero() || options.CancelKey != "" {
+		if options.DeliverAt.IsZero() || !validScheduleCancelKey(options.CancelKey) || time.Until(options.DeliverAt) > MaxScheduleDelay {
+			return PublishInvalidSchedule
+		}
+	}
+
+	if !options.Target.valid() {
+		return PublishInvalidTarget
+	}
+
 	if hotPath {
 		h.metrics.PublishDuration.WithLabelValues("validate").Observe(time.Since(validateStart).Seconds())
 		defer func() {
@@ -450,6 +635,36 @@ func (h *Hub) publishWithOptions(channel, event, data string, options PublishOpt
 		}()
 	}
 
+	if options.IdempotencyKey == "" {
+		return h.publishValidated(channel, event, data, options)
+	}
+	key := idempotencyKey(channel, options.IdempotencyKey)
+	if !h.deduper.claim(h.ctx, key) {
+		if h.metrics != nil {
+			h.metrics.PublishDeduplicated.WithLabelValues(h.AppID).Inc()
+		}
+		return PublishDeduplicated
+	}
+	status := h.publishValidated(channel, event, data, options)
+	if releasesIdempotencyKey(status) {
+		h.deduper.release(h.ctx, key)
+	}
+	return status
+}
+
+func (h *Hub) publishValidated(channel, event, data string, options PublishOptions) PublishStatus {
+	if !options.DeliverAt.IsZero() && time.Now().Before(options.DeliverAt) {
+		return h.scheduler.schedule(ScheduledPublish{
+			ID:             scheduledPublishID(options.CancelKey),
+			Channel:        channel,
+			Event:          event,
+			Data:           data,
+			ExceptSocketID: options.ExceptSocketID,
+			Target:         options.Target,
+			DeliverAt:      options.DeliverAt.UnixMilli(),
+		})
+	}
+
 	h.metrics.Messages.Inc()
 
 	raw := json.RawMessage(data)
@@ -462,13 +677,40 @@ func (h *Hub) publishWithOptions(channel, event, data string, options PublishOpt
 		ExceptSocketID:    options.ExceptSocketID,
 		InternalCreatedAt: time.Now(),
 	}
-	if h.supportsLocalPublishAck() {
+	if !options.Target.empty() {
+		target := options.Target
+		msg.Target = &target
+	}
+	// A local confirmation says nothing about other nodes, so a clustered
+	// quorum always goes through publishAcked.
+	quorum := options.Ack == PublishAckQuorum && h.supportsClusterFanout()
+	if h.supportsLocalPublishAck() && !quorum {
 		msg.LocalResult = make(chan PublishStatus, 1)
+	} else if options.Ack != PublishAckNone {
+		return h.publishAcked(msg, options)
+	}
+
+	if status := h.publishToBroker(msg); status != PublishOK {
+		return status
+	}
+	if msg.LocalResult != nil {
+		select {
+		case status := <-msg.LocalResult:
+			if status != PublishOK && h.metrics != nil {
+				h.metrics.PublishFailures.WithLabelValues(h.AppID, publishStatusMetricReason(status)).Inc()
+			}
+			return status
+		case <-h.ctx.Done():
+			return PublishBrokerFailed
+		}
 	}
+	return PublishOK
+}
 
+func (h *Hub) publishToBroker(msg *BroadcastMessage) PublishStatus {
 	brokerStart := time.Now()
 	err := h.broker.Publish(h.ctx, msg)
-	if hotPath {
+	if h.metrics != nil && h.metrics.HotPathEnabled {
 		h.metrics.PublishDuration.WithLabelValues("broker").Observe(time.Since(brokerStart).Seconds())
 	}
 	if err != nil {
@@ -485,17 +727,6 @@ func (h *Hub) publishWithOptions(channel, event, data string, options PublishOpt
 		}
 		return PublishBrokerFailed
 	}
-	if msg.LocalResult != nil {
-		select {
-		case status := <-msg.LocalResult:
-			if status != PublishOK && h.metrics != nil {
-				h.metrics.PublishFailures.WithLabelValues(h.AppID, publishStatusMetricReason(status)).Inc()
-			}
-			return status
-		case <-h.ctx.Done():
-			return PublishBrokerFailed
-		}
-	}
 	return PublishOK
 }
 
@@ -504,6 +735,50 @@ func (h *Hub) supportsLocalPublishAck() bool {
 	return ok && acker.SupportsLocalPublishAck()
 }
 
+func (h *Hub) supportsClusterFanout() bool {
+	clustered, ok := h.broker.(interface{ SupportsClusterFanout() bool })
+	return ok && clustered.SupportsClusterFanout()
+}
+
+// relayClientEvent forwards a locally delivered client event to other nodes.
+// It runs on a shard goroutine, so it never blocks on the broker.
+func (h *Hub) relayClientEvent(cMsg *ClientMessageWrapper) {
+	msg := &BroadcastMessage{
+		AppID:          h.AppID,
+		Channel:        cMsg.Channel,
+		Event:          cMsg.Event,
+		Data:           cMsg.Data,
+		ExceptSocketID: cMsg.Client.ID,
+		OriginNodeID:   h.nodeID,
+	}
+	select {
+	case h.clientRelay <- msg:
+	default:
+		if h.metrics != nil {
+			h.metrics.BrokerDropped.WithLabelValues(h.AppID, "client_event_queue_full").Inc()
+		}
+	}
+}
+
+func (h *Hub) runClientRelay() {
+	for {
+		select {
+		case msg := <-h.clientRelay:
+			if err := h.broker.Publish(h.ctx, msg); err != nil {
+				if h.metrics != nil {
+					h.metrics.BrokerDropped.WithLabelValues(h.AppID, "client_event_failed").Inc()
+				}
+				h.logger.Warn("Hub: failed to relay client event",
+					zap.String("channel", msg.Channel),
+					zap.String("event", msg.Event),
+					zap.Error(err))
+			}
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
 func publishStatusMetricReason(status PublishStatus) string {
 	switch status {
 	case PublishBrokerQueueFull:
@@ -512,6 +787,18 @@ func publishStatusMetricReason(status PublishStatus) string {
 		return "shard_queue_full"
 	case PublishBrokerFailed:
 		return "broker_failed"
+	case PublishAckTimeout:
+		return "ack_timeout"
+	case PublishInvalidSchedule:
+		return "invalid_schedule"
+	case PublishDeduplicated:
+		return "deduplicated"
+	case PublishInvalidTarget:
+		return "invalid_target"
+	case PublishInvalidOptions:
+		return "invalid_options"
+	case PublishAckUnsupported:
+		return "ack_unsupported"
 	default:
 		return "failed"
 	}
@@ -542,9 +829,7 @@ func publishToActiveHubsWithOptions(hubs []*Hub, channel, event, data string, op
 		}
 		publishedScopes[scope] = struct{}{}
 
-		if result := hub.publishWithOptions(channel, event, data, options); result != PublishOK && status == PublishOK {
-			status = result
-		}
+		status = mergePublishStatus(status, hub.publishWithOptions(channel, event, data, options))
 	}
 	return status
 }
@@ -553,6 +838,12 @@ func (h *Hub) IsHealthy() bool {
 	return h.healthy.Load()
 }
 
+// IsReady reports whether the hub is healthy and its broker is connected, so
+// publishes reach the rest of the cluster.
+func (h *Hub) IsReady() bool {
+	return h.IsHealthy() && h.BrokerState() == BrokerConnected
+}
+
 func (h *Hub) HealthError() string {
 	if value := h.healthErr.Load(); value != nil {
 		if msg, ok := value.(string); ok {
@@ -567,6 +858,67 @@ func (h *Hub) setHealth(healthy bool, err string) {
 	h.healthErr.Store(err)
 }
 
+func (h *Hub) BrokerState() BrokerState {
+	state, _ := h.brokerState.Load().(BrokerState)
+	return state
+}
+
+func (h *Hub) setBrokerState(state BrokerState) {
+	previous := h.BrokerState()
+	h.brokerState.Store(state)
+	if previous == state {
+		return
+	}
+	if state == BrokerConnected {
+		h.logger.Info("Hub: broker connected", zap.String("app_id", h.AppID))
+		// Presence updates published while disconnected never arrived.
+		if h.presence != nil {
+			h.presence.invalidate()
+		}
+	} else {
+		h.logger.Warn("Hub: broker degraded", zap.String("app_id", h.AppID), zap.String("state", string(state)))
+	}
+}
+
+// HubHealth is the detailed state reported by the health endpoint.
+type HubHealth struct {
+	Status               string       `json:"status"`
+	Ready                bool         `json:"ready"`
+	BrokerState          string       `json:"broker_state"`
+	LastMessageAt        *time.Time   `json:"last_message_at"`
+	Connections          int64        `json:"connections"`
+	ShardQueueSaturation float64      `json:"shard_queue_saturation"`
+	Peers                []PeerHealth `json:"peers,omitempty"`
+}
+
+func (h *Hub) Health() HubHealth {
+	health := HubHealth{
+		Status:      "ok",
+		Ready:       h.IsReady(),
+		BrokerState: string(h.BrokerState()),
+		Connections: h.conns.Load(),
+	}
+	if !h.IsHealthy() {
+		health.Status = h.HealthError()
+		if health.Status == "" {
+			health.Status = "hub_unhealthy"
+		}
+	}
+	if nanos := h.lastMessageAt.Load(); nanos > 0 {
+		at := time.Unix(0, nanos).UTC()
+		health.LastMessageAt = &at
+	}
+	for _, shard := range h.shards {
+		if saturation := shard.queueSaturation(); saturation > health.ShardQueueSaturation {
+			health.ShardQueueSaturation = saturation
+		}
+	}
+	if reporter, ok := h.broker.(PeerHealthReporter); ok {
+		health.Peers = reporter.PeerHealth()
+	}
+	return health
+}
+
 func (h *Hub) Run() {
 	defer close(h.done)
 	h.logger.Info("Hub: started", zap.String("app_id", h.AppID), zap.Int("shards", h.numShards))
@@ -579,6 +931,21 @@ func (h *Hub) Run() {
 	}
 	h.setHealth(true, "")
 
+	if h.presence != nil {
+		if err := h.presence.load(h.ctx, h); err != nil {
+			h.presence.invalidate()
+		}
+		go h.presence.run(h.ctx, h)
+	}
+	if h.clientRelay != nil {
+		go h.runClientRelay()
+	}
+	go h.scheduler.run(h.ctx)
+	go h.runClusterMutations()
+	if h.reauthInterval > 0 {
+		go h.runReauthorization()
+	}
+
 	for {
 		select {
 		case msg, ok := <-brokerStream:
@@ -588,6 +955,17 @@ func (h *Hub) Run() {
 				return
 			}
 			if msg != nil {
+				h.lastMessageAt.Store(time.Now().UnixNano())
+				if msg.Presence != nil && msg.Presence.NodeID == h.nodeID {
+					continue
+				}
+				if msg.OriginNodeID == h.nodeID {
+					continue
+				}
+				if msg.Query != nil {
+					h.dispatchClusterQuery(*msg.Query)
+					continue
+				}
 				if h.metrics != nil && h.metrics.HotPathEnabled {
 					now := time.Now()
 					if !msg.InternalCreatedAt.IsZero() {
@@ -600,7 +978,10 @@ func (h *Hub) Run() {
 					msg.BrokerReceivedAt = now
 				}
 				shard := h.getShard(msg.Channel)
-				shard.enqueueBroadcast(msg)
+				status := shard.enqueueBroadcast(msg)
+				if msg.Ack != nil {
+					h.acknowledgePublish(msg.Ack, status)
+				}
 			}
 
 		case sub := <-h.subscribe:
@@ -622,6 +1003,9 @@ func (h *Hub) Run() {
 		case <-h.ctx.Done():
 			h.setHealth(false, "shutting_down")
 			h.logger.Info("Hub: shutting down, draining connections...")
+			if h.presence != nil {
+				h.presence.Wait()
+			}
 			_ = h.broker.Close()
 
 			closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/y-l-g/websocket/module/internal/protocol"
@@ -563,3 +564,104 @@ func TestClientShardMask(t *testing.T) {
 		t.Error("Bitmask lost state")
 	}
 }
+
+func TestClientEventsReachSubscribersOnOtherNodes(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub1 := newRedisTestHub(t, ctx, mr.Addr())
+	hub2 := newRedisTestHub(t, ctx, mr.Addr())
+	time.Sleep(100 * time.Millisecond)
+
+	channel := "private-chat"
+	sender := &Client{ID: "1.1", send: make(chan any, 10)}
+	localPeer := &Client{ID: "1.2", send: make(chan any, 10)}
+	remotePeer := &Client{ID: "2.1", send: make(chan any, 10)}
+	outsider := &Client{ID: "1.3", send: make(chan any, 10)}
+	for hub, clients := range map[*Hub][]*Client{hub1: {sender, localPeer}, hub2: {remotePeer}} {
+		for _, client := range clients {
+			hub.getShard(channel).withSubscriptions(func(sm *SubscriptionManager) {
+				sm.Subscribe(client, channel, nil)
+			})
+			drainClientMessage(t, client)
+		}
+	}
+
+	hub1.getShard(channel).EnqueueClientMessage(&ClientMessageWrapper{
+		Client:  outsider,
+		Channel: channel,
+		Event:   "client-typing",
+		Data:    json.RawMessage(`{"ignored":true}`),
+	})
+	hub1.getShard(channel).EnqueueClientMessage(&ClientMessageWrapper{
+		Client:  sender,
+		Channel: channel,
+		Event:   "client-typing",
+		Data:    json.RawMessage(`{"typing":true}`),
+	})
+
+	select {
+	case <-remotePeer.send:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected client event to reach subscriber on other node")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := len(localPeer.send); got != 1 {
+		t.Fatalf("Expected local peer to receive the client event once, got %d messages", got)
+	}
+	if got := len(sender.send); got != 0 {
+		t.Fatalf("Expected sender to be excluded, got %d messages", got)
+	}
+	if got := len(remotePeer.send); got != 0 {
+		t.Fatalf("Expected only the member's client event to be relayed, got %d extra messages", got)
+	}
+}
+
+func TestHubHealthReflectsBrokerReconnects(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := newRedisTestHub(t, ctx, mr.Addr())
+	waitForHubReady := func(ready bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for hub.IsReady() != ready {
+			if time.Now().After(deadline) {
+				t.Fatalf("Hub ready = %v (%s), want %v", hub.IsReady(), hub.BrokerState(), ready)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	waitForHubReady(true)
+
+	mr.Close()
+	waitForHubReady(false)
+	if !hub.IsHealthy() {
+		t.Fatalf("Hub unhealthy during broker outage: %s", hub.HealthError())
+	}
+	if health := hub.Health(); health.Status != "ok" || health.Ready || health.BrokerState != string(BrokerReconnecting) {
+		t.Fatalf("Health during outage = %+v", health)
+	}
+
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("Failed to restart miniredis: %v", err)
+	}
+	waitForHubReady(true)
+	if health := hub.Health(); health.Status != "ok" || !health.Ready || health.BrokerState != string(BrokerConnected) {
+		t.Fatalf("Health after reconnect = %+v", health)
+	}
+}
@@ -0,0 +1,160 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultIdempotencyWindow = 5 * time.Minute
+	maxLocalIdempotencyKeys  = 100000
+	idempotencyStoreTimeout  = time.Second
+)
+
+// IdempotencyBroker is implemented by brokers that can remember publish keys
+// for the whole cluster, so a retry that lands on another node is still dropped.
+type IdempotencyBroker interface {
+	ClaimIdempotencyKey(ctx context.Context, key string, window time.Duration) (bool, error)
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// publishDeduper remembers idempotency keys for one window, in the broker when
+// it implements IdempotencyBroker and in memory otherwise.
+type publishDeduper struct {
+	window time.Duration
+	store  IdempotencyBroker
+	logger *zap.Logger
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// order holds local claims oldest first. The window is fixed, so this is
+	// also expiry order; entries whose key was released or claimed again no
+	// longer match seen and are skipped.
+	order []idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newPublishDeduper(broker Broker, logger *zap.Logger, window time.Duration) *publishDeduper {
+	store, _ := broker.(IdempotencyBroker)
+	return &publishDeduper{
+		window: window,
+		store:  store,
+		logger: logger,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// idempotencyKey scopes a caller key to one channel, so a retried multi-channel
+// publish is deduplicated channel by channel.
+func idempotencyKey(channel, key string) string {
+	sum := sha256.Sum256([]byte(channel + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// claim reports whether key was not seen within the window and records it.
+// Storage errors let the publish through rather than dropping it.
+func (d *publishDeduper) claim(ctx context.Context, key string) bool {
+	if d.store != nil {
+		ctx, cancel := context.WithTimeout(ctx, idempotencyStoreTimeout)
+		defer cancel()
+		claimed, err := d.store.ClaimIdempotencyKey(ctx, key, d.window)
+		if err != nil {
+			d.logger.Warn("Hub: failed to check idempotency key, publishing anyway", zap.Error(err))
+			return true
+		}
+		return claimed
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if expires, ok := d.seen[key]; ok && now.Before(expires) {
+		return false
+	}
+	d.evictLocked(now)
+	expires := now.Add(d.window)
+	d.seen[key] = expires
+	d.order = append(d.order, idempotencyEntry{key: key, expires: expires})
+	return true
+}
+
+// release forgets key after a failed publish so the caller can retry it.
+func (d *publishDeduper) release(ctx context.Context, key string) {
+	if d.store != nil {
+		ctx, cancel := context.WithTimeout(ctx, idempotencyStoreTimeout)
+		defer cancel()
+		if err := d.store.ReleaseIdempotencyKey(ctx, key); err != nil {
+			d.logger.Warn("Hub: failed to release idempotency key", zap.Error(err))
+		}
+		return
+	}
+
+	d.mu.Lock()
+	delete(d.seen, key)
+	d.mu.Unlock()
+}
+
+// evictLocked forgets expired keys, and the soonest to expire while the map is
+// full, from the front of the claim order, so each claim costs amortized O(1).
+func (d *publishDeduper) evictLocked(now time.Time) {
+	for len(d.order) > 0 {
+		front := d.order[0]
+		expires, ok := d.seen[front.key]
+		current := ok && expires.Equal(front.expires)
+		if current && now.Before(expires) && len(d.seen) < maxLocalIdempotencyKeys {
+			break
+		}
+		if current {
+			delete(d.seen, front.key)
+		}
+		d.order[0] = idempotencyEntry{}
+		d.order = d.order[1:]
+	}
+	// Released and re-claimed keys leave stale entries behind; drop them once
+	// they outnumber the live ones.
+	if len(d.order) > 2*len(d.seen)+1024 {
+		live := make([]idempotencyEntry, 0, len(d.seen))
+		for _, entry := range d.order {
+			if expires, ok := d.seen[entry.key]; ok && expires.Equal(entry.expires) {
+				live = append(live, entry)
+			}
+		}
+		d.order = live
+	}
+}
+
+// releasesIdempotencyKey reports whether a failed publish was certainly not
+// accepted, so a retry with the same key must go through. An ack timeout may
+// still be delivered and keeps its key.
+func releasesIdempotencyKey(status PublishStatus) bool {
+	switch status {
+	case PublishOK, PublishDeduplicated, PublishAckTimeout:
+		return false
+	default:
+		return true
+	}
+}
+
+// mergePublishStatus combines per-channel results: failures win over
+// deduplicated publishes, which win over success.
+func mergePublishStatus(status, result PublishStatus) PublishStatus {
+	switch {
+	case result == PublishOK:
+		return status
+	case status == PublishOK:
+		return result
+	case status == PublishDeduplicated && result != PublishDeduplicated:
+		return result
+	default:
+		return status
+	}
+}
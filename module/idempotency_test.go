@@ -0,0 +1,188 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func TestPublishDropsDuplicateIdempotencyKey(t *testing.T) {
+	module, broker, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+	hub := module.hub
+
+	options := PublishOptions{IdempotencyKey: "order-1"}
+	if status := hub.PublishWithOptions("public-room", "update", `{}`, options); status != PublishOK {
+		t.Fatalf("first publish status = %v, want PublishOK", status)
+	}
+	readPublishedMessage(t, broker.published)
+
+	if status := hub.PublishWithOptions("public-room", "update", `{}`, options); status != PublishDeduplicated {
+		t.Fatalf("retry status = %v, want PublishDeduplicated", status)
+	}
+	// The key is scoped to the channel, so another channel still publishes.
+	if status := hub.PublishWithOptions("other-room", "update", `{}`, options); status != PublishOK {
+		t.Fatalf("other channel status = %v, want PublishOK", status)
+	}
+	readPublishedMessage(t, broker.published)
+
+	select {
+	case msg := <-broker.published:
+		t.Fatalf("duplicate publish reached the broker: %v", msg)
+	default:
+	}
+	if got := counterValue(t, hub.metrics.PublishDeduplicated.WithLabelValues("test-app")); got != 1 {
+		t.Fatalf("deduplicated publishes = %v, want 1", got)
+	}
+}
+
+func TestPublishReleasesIdempotencyKeyOnFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &MockBroker{err: ErrBrokerQueueFull}
+	hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), nil, nil, broker, 100, 1, DefaultPingPeriod, DefaultDeliveryConfig())
+
+	options := PublishOptions{IdempotencyKey: "order-1"}
+	for i := 0; i < 2; i++ {
+		if status := hub.PublishWithOptions("public-room", "update", `{}`, options); status != PublishBrokerQueueFull {
+			t.Fatalf("attempt %d status = %v, want PublishBrokerQueueFull", i, status)
+		}
+	}
+}
+
+func TestPublishIdempotencyWindowExpires(t *testing.T) {
+	deduper := newPublishDeduper(nil, zap.NewNop(), 50*time.Millisecond)
+	key := idempotencyKey("public-room", "order-1")
+
+	if !deduper.claim(context.Background(), key) {
+		t.Fatal("expected first claim to succeed")
+	}
+	if deduper.claim(context.Background(), key) {
+		t.Fatal("expected claim within the window to fail")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !deduper.claim(context.Background(), key) {
+		t.Fatal("expected claim after the window to succeed")
+	}
+}
+
+func TestPublishIdempotencyIsClusterWideWithRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub1 := newRedisTestHub(t, ctx, mr.Addr())
+	hub2 := newRedisTestHub(t, ctx, mr.Addr())
+
+	options := PublishOptions{IdempotencyKey: "order-1"}
+	if status := hub1.PublishWithOptions("public-room", "update", `{}`, options); status != PublishOK {
+		t.Fatalf("first publish status = %v, want PublishOK", status)
+	}
+	if status := hub2.PublishWithOptions("public-room", "update", `{}`, options); status != PublishDeduplicated {
+		t.Fatalf("retry on another node status = %v, want PublishDeduplicated", status)
+	}
+
+	mr.FastForward(DefaultIdempotencyWindow + time.Second)
+	if status := hub2.PublishWithOptions("public-room", "update", `{}`, options); status != PublishOK {
+		t.Fatalf("publish after window status = %v, want PublishOK", status)
+	}
+}
+
+func TestPusherHTTPAPIReportsDeduplicatedEvents(t *testing.T) {
+	module, broker, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+
+	body := []byte(`{"name":"update","data":"{}","channels":["a","b"],"idempotency_key":"order-1"}`)
+	rr := performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/events", body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("first publish status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+	if bytes.Contains(rr.Body.Bytes(), []byte("deduplicated")) {
+		t.Fatalf("first publish reported deduplication: %s", rr.Body.String())
+	}
+	readPublishedMessage(t, broker.published)
+	readPublishedMessage(t, broker.published)
+
+	rr = performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/events", body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("retry status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Deduplicated []string `json:"deduplicated_channels"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Deduplicated) != 2 {
+		t.Fatalf("deduplicated_channels = %v, want [a b]", response.Deduplicated)
+	}
+
+	batch := []byte(`{"batch":[{"name":"update","data":"{}","channel":"a","idempotency_key":"order-1"},{"name":"update","data":"{}","channel":"c","idempotency_key":"order-1"}]}`)
+	rr = performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/batch_events", batch)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("batch status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+	var batchResponse struct {
+		Batch []map[string]any `json:"batch"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &batchResponse); err != nil {
+		t.Fatalf("Failed to decode batch response: %v", err)
+	}
+	if len(batchResponse.Batch) != 2 || batchResponse.Batch[0]["deduplicated"] != true || batchResponse.Batch[1]["deduplicated"] != nil {
+		t.Fatalf("batch response = %s, want only the first item deduplicated", rr.Body.String())
+	}
+	if msg := readPublishedMessage(t, broker.published); msg.Channel != "c" {
+		t.Fatalf("published channel %q, want c", msg.Channel)
+	}
+}
+
+func TestPublishDeduperEvictsInClaimOrder(t *testing.T) {
+	d := newPublishDeduper(&MockBroker{}, zap.NewNop(), time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < maxLocalIdempotencyKeys+10; i++ {
+		if !d.claim(ctx, strconv.Itoa(i)) {
+			t.Fatalf("claim %d was deduplicated", i)
+		}
+	}
+	if len(d.seen) != maxLocalIdempotencyKeys {
+		t.Fatalf("remembered %d keys, want the bound %d", len(d.seen), maxLocalIdempotencyKeys)
+	}
+	if d.claim(ctx, strconv.Itoa(maxLocalIdempotencyKeys+9)) {
+		t.Fatal("Expected the newest key to still be remembered")
+	}
+	if !d.claim(ctx, "0") {
+		t.Fatal("Expected the oldest key to be evicted when full")
+	}
+
+	// Repeated release and re-claim must not grow the claim order without bound.
+	for i := 0; i < 3*maxLocalIdempotencyKeys; i++ {
+		d.release(ctx, "retried")
+		d.claim(ctx, "retried")
+	}
+	if len(d.order) > 2*len(d.seen)+1024 {
+		t.Fatalf("claim order holds %d entries for %d keys", len(d.order), len(d.seen))
+	}
+
+	expiring := newPublishDeduper(&MockBroker{}, zap.NewNop(), time.Millisecond)
+	expiring.claim(ctx, "old")
+	time.Sleep(5 * time.Millisecond)
+	expiring.claim(ctx, "new")
+	if _, ok := expiring.seen["old"]; ok || len(expiring.order) != 1 {
+		t.Fatalf("Expected the expired key to be forgotten, seen = %v", expiring.seen)
+	}
+}
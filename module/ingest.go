@@ -0,0 +1,206 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	ingestPollTimeout = time.Second
+	ingestRetryDelay  = time.Second
+)
+
+// ingestDeadLetter records a queued broadcast that could not be published.
+// Job holds only the channels that failed, so it can be pushed back onto the list as is.
+type ingestDeadLetter struct {
+	Reason   string              `json:"reason"`
+	Job      *pusherEventRequest `json:"job,omitempty"`
+	Payload  string              `json:"payload,omitempty"`
+	FailedAt int64               `json:"failed_at"`
+}
+
+// RedisIngest pops broadcast jobs from a Redis list and publishes them to the app's hubs.
+// Jobs use the body of the Pusher trigger API: name, data, channel or channels, and socket_id.
+// Each job is moved to a processing list named after the host while it is
+// published, so a job in flight when the process dies is requeued on restart.
+type RedisIngest struct {
+	client     *redis.Client
+	logger     *zap.Logger
+	metrics    *Metrics
+	appID      string
+	list       string
+	processing string
+	deadLetter string
+}
+
+func NewRedisIngest(logger *zap.Logger, metrics *Metrics, client *redis.Client, appID, list, deadLetter string) *RedisIngest {
+	if deadLetter == "" {
+		deadLetter = list + ":dead"
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return &RedisIngest{
+		client:     client,
+		logger:     logger,
+		metrics:    metrics,
+		appID:      appID,
+		list:       list,
+		processing: list + ":processing:" + host,
+		deadLetter: deadLetter,
+	}
+}
+
+func (i *RedisIngest) Run(ctx context.Context) {
+	i.logger.Info("Redis ingest: consuming broadcast jobs", zap.String("list", i.list), zap.String("processing", i.processing), zap.String("dead_letter", i.deadLetter))
+	for !i.requeue(ctx) {
+		if !i.wait(ctx) {
+			return
+		}
+	}
+
+	for ctx.Err() == nil {
+		payload, err := i.client.BLMove(ctx, i.list, i.processing, "LEFT", "RIGHT", ingestPollTimeout).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			i.logger.Warn("Redis ingest: pop failed, retrying", zap.Error(err))
+			if !i.wait(ctx) {
+				return
+			}
+			continue
+		}
+		i.process(payload)
+		i.done(payload)
+	}
+}
+
+// wait sleeps before a retry and reports false once ctx is done.
+func (i *RedisIngest) wait(ctx context.Context) bool {
+	timer := time.NewTimer(ingestRetryDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// requeue moves jobs a previous run left in the processing list back to the
+// head of the list, oldest first, and reports whether it emptied it. The jobs
+// may have been published already, so a crash can repeat a broadcast.
+func (i *RedisIngest) requeue(ctx context.Context) bool {
+	requeued := 0
+	defer func() {
+		if requeued > 0 {
+			i.logger.Warn("Redis ingest: requeued jobs left unfinished by a previous run", zap.Int("jobs", requeued))
+		}
+	}()
+	for {
+		err := i.client.LMove(ctx, i.processing, i.list, "RIGHT", "LEFT").Err()
+		if errors.Is(err, redis.Nil) {
+			return true
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				i.logger.Warn("Redis ingest: failed to requeue unfinished jobs, retrying", zap.Error(err))
+			}
+			return false
+		}
+		requeued++
+	}
+}
+
+// done drops a published or dead-lettered job from the processing list.
+func (i *RedisIngest) done(payload string) {
+	ctx, cancel := context.WithTimeout(context.Background(), ingestPollTimeout)
+	defer cancel()
+	if err := i.client.LRem(ctx, i.processing, 1, payload).Err(); err != nil {
+		i.logger.Error("Redis ingest: failed to clear processed job", zap.Error(err))
+	}
+}
+
+func (i *RedisIngest) process(payload string) {
+	var job pusherEventRequest
+	if err := json.Unmarshal([]byte(payload), &job); err != nil {
+		i.reject(ingestDeadLetter{Reason: "invalid_json", Payload: payload})
+		return
+	}
+
+	channels := job.Channels
+	if len(channels) == 0 && job.Channel != "" {
+		channels = []string{job.Channel}
+	}
+	if job.Name == "" || job.Data == "" || len(channels) == 0 {
+		i.reject(ingestDeadLetter{Reason: "invalid_job", Payload: payload})
+		return
+	}
+
+	options := PublishOptions{ExceptSocketID: job.SocketID, IdempotencyKey: job.IdempotencyKey, Target: job.PublishTarget}
+	failed := map[string][]string{}
+	for _, channel := range channels {
+		status := PublishInvalidChannelsJSON
+		if channel != "" {
+			status = publishToActiveHubsWithOptions(GetHubs(i.appID), channel, job.Name, job.Data, options)
+		}
+		if status != PublishOK && status != PublishDeduplicated {
+			reason := publishStatusReason(status)
+			failed[reason] = append(failed[reason], channel)
+		}
+	}
+
+	if len(failed) == 0 {
+		i.count("published")
+		return
+	}
+	for reason, channels := range failed {
+		i.reject(ingestDeadLetter{
+			Reason: reason,
+			Job: &pusherEventRequest{
+				Name:           job.Name,
+				Data:           job.Data,
+				Channels:       channels,
+				SocketID:       job.SocketID,
+				IdempotencyKey: job.IdempotencyKey,
+				PublishTarget:  job.PublishTarget,
+			},
+		})
+	}
+}
+
+func (i *RedisIngest) reject(letter ingestDeadLetter) {
+	i.count("dead_lettered")
+	letter.FailedAt = time.Now().Unix()
+	data, err := json.Marshal(letter)
+	if err != nil {
+		i.logger.Error("Redis ingest: failed to encode dead letter", zap.Error(err))
+		return
+	}
+
+	// The dead letter is written even while shutting down, so it gets its own deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), ingestPollTimeout)
+	defer cancel()
+	if err := i.client.RPush(ctx, i.deadLetter, data).Err(); err != nil {
+		i.logger.Error("Redis ingest: failed to write dead letter", zap.String("reason", letter.Reason), zap.Error(err))
+		return
+	}
+	i.logger.Warn("Redis ingest: broadcast job dead-lettered", zap.String("reason", letter.Reason))
+}
+
+func (i *RedisIngest) count(result string) {
+	if i.metrics != nil {
+		i.metrics.IngestMessages.WithLabelValues(i.appID, result).Inc()
+	}
+}
@@ -0,0 +1,139 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestRedisIngestPublishesJobsAndDeadLettersFailures(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	_, broker, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer func() { _ = client.Close() }()
+	metrics := NewMetrics(prometheus.NewRegistry())
+	ingest := NewRedisIngest(zap.NewNop(), metrics, client, "test-app", "pogo:broadcasts", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ingest.Run(ctx)
+
+	longChannel := strings.Repeat("x", 300)
+	jobs := []string{
+		`{"name":"order.shipped","data":"{\"id\":1}","channels":["orders","` + longChannel + `"],"socket_id":"1.1"}`,
+		`not json`,
+		`{"name":"order.shipped","channels":["orders"]}`,
+	}
+	for _, job := range jobs {
+		if _, err := mr.Push("pogo:broadcasts", job); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+
+	select {
+	case msg := <-broker.published:
+		if msg.Channel != "orders" || msg.Event != "order.shipped" || msg.ExceptSocketID != "1.1" || string(msg.Data) != `{"id":1}` {
+			t.Fatalf("Unexpected published message: %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected queued job to be published")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	var letters []string
+	for time.Now().Before(deadline) {
+		letters, _ = mr.List("pogo:broadcasts:dead")
+		if len(letters) == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(letters) != 3 {
+		t.Fatalf("Expected three dead letters, got %q", letters)
+	}
+
+	reasons := make([]string, 0, len(letters))
+	for _, raw := range letters {
+		var letter ingestDeadLetter
+		if err := json.Unmarshal([]byte(raw), &letter); err != nil {
+			t.Fatalf("Failed to decode dead letter: %v", err)
+		}
+		reasons = append(reasons, letter.Reason)
+		if letter.Reason == "channel_too_long" && (letter.Job == nil || len(letter.Job.Channels) != 1 || letter.Job.Channels[0] != longChannel) {
+			t.Fatalf("Expected dead letter to keep only the failed channel, got %+v", letter.Job)
+		}
+	}
+	if strings.Join(reasons, ",") != "channel_too_long,invalid_json,invalid_job" {
+		t.Fatalf("dead letter reasons = %v", reasons)
+	}
+	if got := counterValue(t, metrics.IngestMessages.WithLabelValues("test-app", "dead_lettered")); got != 3 {
+		t.Fatalf("dead_lettered = %v, want 3", got)
+	}
+	if got := counterValue(t, metrics.IngestMessages.WithLabelValues("test-app", "published")); got != 0 {
+		t.Fatalf("published = %v, want 0 for a partially failed job", got)
+	}
+}
+
+func TestRedisIngestRequeuesUnfinishedJobsAtStartup(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	_, broker, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer func() { _ = client.Close() }()
+	ingest := NewRedisIngest(zap.NewNop(), nil, client, "test-app", "pogo:broadcasts", "")
+
+	// A previous run crashed after popping the first job but before publishing it.
+	if _, err := mr.Push(ingest.processing, `{"name":"first","data":"{}","channel":"orders"}`); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if _, err := mr.Push("pogo:broadcasts", `{"name":"second","data":"{}","channel":"orders"}`); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ingest.Run(ctx)
+
+	for _, want := range []string{"first", "second"} {
+		select {
+		case msg := <-broker.published:
+			if msg.Event != want {
+				t.Fatalf("published %q, want %q", msg.Event, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected job %q to be published", want)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		pending, _ := mr.List(ingest.processing)
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("processing list still holds %q after publishing", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -31,10 +31,15 @@ const (
 const (
 	ErrorApplicationDisabled = 4003 // Used for Binary frames (Generic Not Supported) or disabled app
 	ErrorOverCapacity        = 4100
+	ErrorAuthUnavailable     = 4101 // Auth backend unavailable, retry after a backoff
 	ErrorGenericReconnect    = 4200
 	ErrorUnsupportedProtocol = 4007
-	ErrorSubscriptionDenied  = 4009
+	ErrorSubscriptionDenied  = 4009 // "Connection is unauthorized"; 4000-4099 closes are not retried
+	ErrorClientEventRejected = 4301 // Client event refused by the channel policy
 	ErrorSigninLimitExceeded = 4302 // Watchlist limit
+	ErrorSubscriptionLimit   = 4303 // Subscriptions per connection
+	ErrorPresenceLimit       = 4304 // Members per presence channel
+	ErrorChannelFull         = 4305 // Subscribers per channel
 )
 
 // Limits
@@ -0,0 +1,402 @@
+package websocket
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// JWTClockSkew is the leeway applied to exp and nbf, for devices whose clock
+// drifts from the issuer's.
+const JWTClockSkew = 30 * time.Second
+
+var errJWTKeyNotFound = errors.New("no verification key for token")
+
+// JWTAuthConfig lists the keys and expected claims for JWTAuthProvider. At
+// least one of Secret, PublicKeyFiles or JWKSFile is required.
+type JWTAuthConfig struct {
+	Secret         string
+	PublicKeyFiles []string
+	JWKSFile       string
+	Issuer         string
+	Audience       string
+}
+
+// jwtAuthClaims are the claims read from a channel token. Channels holds exact
+// names or path.Match patterns such as "private-orders.*"; sub is the presence
+// and signin user ID.
+type jwtAuthClaims struct {
+	Channels []string        `json:"channels"`
+	UserInfo json.RawMessage `json:"user_info,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type jwtKey struct {
+	id  string
+	key any
+}
+
+// JWTAuthProvider authorizes subscriptions with a signed JWT, either in the
+// subscribe auth field or given at connect time, without calling the auth
+// worker. Anything that is not a JWT goes to the fallback provider.
+type JWTAuthProvider struct {
+	keys     []jwtKey
+	parser   *jwt.Parser
+	fallback AuthProvider
+	logger   *zap.Logger
+	metrics  *Metrics
+}
+
+func NewJWTAuthProvider(logger *zap.Logger, metrics *Metrics, config JWTAuthConfig, fallback AuthProvider) (*JWTAuthProvider, error) {
+	keys, err := loadJWTKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwt auth requires a secret, public key or JWKS file")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(JWTClockSkew),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	return &JWTAuthProvider{
+		keys:     keys,
+		parser:   jwt.NewParser(options...),
+		fallback: fallback,
+		logger:   logger,
+		metrics:  metrics,
+	}, nil
+}
+
+func (p *JWTAuthProvider) Authorize(client *Client, channel string, auth string, channelData string) AuthResult {
+	token := auth
+	if !looksLikeJWT(token) {
+		if auth != "" || client.ConnectToken() == "" {
+			return p.delegateAuthorize(client, channel, auth, channelData)
+		}
+		token = client.ConnectToken()
+	}
+
+	claims, ok := p.parse(client, token)
+	if !ok {
+		return AuthResult{Allowed: false}
+	}
+	if !claims.permits(channel) {
+		// A connect-time token covers some channels; the rest still go
+		// through the usual auth flow.
+		if token != auth {
+			return p.delegateAuthorize(client, channel, auth, channelData)
+		}
+		p.fail("jwt_channel_denied")
+		p.logger.Warn("Auth: channel not permitted by token", zap.String("id", client.ID), zap.String("channel", channel))
+		return AuthResult{Allowed: false}
+	}
+
+	response := channelAuthResponse{}
+	if strings.HasPrefix(channel, "presence-") {
+		if claims.Subject == "" {
+			p.fail("missing_channel_data")
+			p.logger.Warn("Auth: presence token missing sub claim", zap.String("id", client.ID), zap.String("channel", channel))
+			return AuthResult{Allowed: false}
+		}
+		data, err := json.Marshal(PresenceChannelData{UserID: jsonString(claims.Subject), UserInfo: claims.UserInfo})
+		if err != nil {
+			return AuthResult{Allowed: false}
+		}
+		response.ChannelData = string(data)
+	}
+
+	raw, err := json.Marshal(response)
+	if err != nil {
+		return AuthResult{Allowed: false}
+	}
+	return AuthResult{Allowed: true, UserData: raw}
+}
+
+// AuthenticateUser signs the user in from the token's sub and user_info claims
+// when authSig is a JWT.
+func (p *JWTAuthProvider) AuthenticateUser(client *Client, authSig string, userData string) AuthResult {
+	if !looksLikeJWT(authSig) {
+		if p.fallback == nil {
+			return AuthResult{Allowed: false}
+		}
+		return p.fallback.AuthenticateUser(client, authSig, userData)
+	}
+
+	claims, ok := p.parse(client, authSig)
+	if !ok || claims.Subject == "" {
+		return AuthResult{Allowed: false}
+	}
+	raw, err := json.Marshal(struct {
+		ID       string          `json:"id"`
+		UserInfo json.RawMessage `json:"user_info,omitempty"`
+	}{ID: claims.Subject, UserInfo: claims.UserInfo})
+	if err != nil {
+		return AuthResult{Allowed: false}
+	}
+	return AuthResult{Allowed: true, UserData: raw}
+}
+
+func (p *JWTAuthProvider) InvalidateAuthCache(channel, userID string) int {
+	invalidator, ok := p.fallback.(AuthCacheInvalidator)
+	if !ok {
+		return 0
+	}
+	return invalidator.InvalidateAuthCache(channel, userID)
+}
+
+func (p *JWTAuthProvider) BatchesAuthorization() bool {
+	batcher, ok := p.fallback.(AuthBatcher)
+	return ok && batcher.BatchesAuthorization()
+}
+
+func (p *JWTAuthProvider) AuthBatchLimit() int {
+	if limiter, ok := p.fallback.(interface{ AuthBatchLimit() int }); ok {
+		return limiter.AuthBatchLimit()
+	}
+	return DefaultAuthBatchSize
+}
+
+func (p *JWTAuthProvider) delegateAuthorize(client *Client, channel string, auth string, channelData string) AuthResult {
+	if p.fallback == nil {
+		p.fail("missing_signature")
+		return AuthResult{Allowed: false}
+	}
+	return p.fallback.Authorize(client, channel, auth, channelData)
+}
+
+func (p *JWTAuthProvider) parse(client *Client, raw string) (*jwtAuthClaims, bool) {
+	claims := &jwtAuthClaims{}
+	if _, err := p.parser.ParseWithClaims(raw, claims, p.keyFunc); err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			p.fail("jwt_expired")
+		} else {
+			p.fail("invalid_jwt")
+		}
+		p.logger.Warn("Auth: token rejected", zap.String("id", client.ID), zap.Error(err))
+		return nil, false
+	}
+	return claims, true
+}
+
+// keyFunc returns every configured key that fits the token's algorithm and kid.
+func (p *JWTAuthProvider) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	set := jwt.VerificationKeySet{}
+	for _, key := range p.keys {
+		if kid != "" && key.id != "" && key.id != kid {
+			continue
+		}
+		if jwtKeyFits(token.Method, key.key) {
+			set.Keys = append(set.Keys, key.key)
+		}
+	}
+	if len(set.Keys) == 0 {
+		return nil, errJWTKeyNotFound
+	}
+	return set, nil
+}
+
+func (p *JWTAuthProvider) fail(reason string) {
+	if p.metrics != nil {
+		p.metrics.AuthFailures.WithLabelValues(reason).Inc()
+	}
+}
+
+func (c *jwtAuthClaims) permits(channel string) bool {
+	for _, pattern := range c.Channels {
+		if pattern == channel {
+			return true
+		}
+		if matched, err := path.Match(pattern, channel); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func jwtKeyFits(method jwt.SigningMethod, key any) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		_, ok := key.([]byte)
+		return ok
+	case *jwt.SigningMethodRSA:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodEd25519:
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
+
+// looksLikeJWT tells a compact JWT apart from a Pusher "key:signature" auth.
+func looksLikeJWT(value string) bool {
+	return strings.Count(value, ".") == 2 && !strings.Contains(value, ":")
+}
+
+func jsonString(value string) json.RawMessage {
+	raw, _ := json.Marshal(value)
+	return raw
+}
+
+func loadJWTKeys(config JWTAuthConfig) ([]jwtKey, error) {
+	var keys []jwtKey
+	if config.Secret != "" {
+		keys = append(keys, jwtKey{key: []byte(config.Secret)})
+	}
+	for _, file := range config.PublicKeyFiles {
+		key, err := loadJWTPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jwtKey{key: key})
+	}
+	if config.JWKSFile != "" {
+		set, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, set...)
+	}
+	return keys, nil
+}
+
+func loadJWTPublicKey(file string) (any, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read jwt public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt public key %s: no PEM block", file)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt public key %s: %w", file, err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("jwt public key %s: only RSA and Ed25519 keys are supported", file)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	K   string `json:"k"`
+}
+
+// loadJWKS reads RSA, Ed25519 (OKP) and symmetric (oct) keys from a JWKS file.
+// Keys of other types or meant for encryption are skipped.
+func loadJWKS(file string) ([]jwtKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks file: %w", err)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks file %s: %w", file, err)
+	}
+
+	var keys []jwtKey
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks file %s, key %q: %w", file, jwk.Kid, err)
+		}
+		if key != nil {
+			keys = append(keys, jwtKey{id: jwk.Kid, key: key})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks file %s has no usable signing keys", file)
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, err
+		}
+		if len(secret) == 0 {
+			return nil, errors.New("empty symmetric key")
+		}
+		return secret, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func signTestJWT(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwtAuthClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func testJWTClaims(channels ...string) jwtAuthClaims {
+	return jwtAuthClaims{
+		Channels:         channels,
+		UserInfo:         json.RawMessage(`{"name":"Ada"}`),
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "42", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+	}
+}
+
+func TestJWTAuthorizesPermittedChannels(t *testing.T) {
+	provider, err := NewJWTAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), JWTAuthConfig{Secret: "jwt-secret"}, nil)
+	if err != nil {
+		t.Fatalf("NewJWTAuthProvider returned error: %v", err)
+	}
+	client := &Client{ID: "1.1"}
+	token := signTestJWT(t, jwt.SigningMethodHS256, []byte("jwt-secret"), "", testJWTClaims("private-orders.*", "presence-room"))
+
+	if res := provider.Authorize(client, "private-orders.7", token, ""); !res.Allowed {
+		t.Fatal("Expected pattern channel to be allowed")
+	}
+	if res := provider.Authorize(client, "private-invoices.7", token, ""); res.Allowed {
+		t.Fatal("Expected channel outside the token to be denied")
+	}
+
+	res := provider.Authorize(client, "presence-room", token, "")
+	if !res.Allowed {
+		t.Fatal("Expected presence channel to be allowed")
+	}
+	var response PresenceAuthResponse
+	if err := json.Unmarshal(res.UserData, &response); err != nil {
+		t.Fatalf("Failed to decode auth response: %v", err)
+	}
+	if response.ChannelData != `{"user_id":"42","user_info":{"name":"Ada"}}` {
+		t.Fatalf("channel_data = %s, want member from sub and user_info claims", response.ChannelData)
+	}
+
+	expired := testJWTClaims("private-orders.*")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute - JWTClockSkew))
+	if res := provider.Authorize(client, "private-orders.7", signTestJWT(t, jwt.SigningMethodHS256, []byte("jwt-secret"), "", expired), ""); res.Allowed {
+		t.Fatal("Expected expired token to be denied")
+	}
+	noExpiry := testJWTClaims("private-orders.*")
+	noExpiry.ExpiresAt = nil
+	if res := provider.Authorize(client, "private-orders.7", signTestJWT(t, jwt.SigningMethodHS256, []byte("jwt-secret"), "", noExpiry), ""); res.Allowed {
+		t.Fatal("Expected token without exp to be denied")
+	}
+	if res := provider.Authorize(client, "private-orders.7", signTestJWT(t, jwt.SigningMethodHS256, []byte("other-secret"), "", testJWTClaims("private-orders.*")), ""); res.Allowed {
+		t.Fatal("Expected token signed with another secret to be denied")
+	}
+}
+
+func TestJWTAuthUsesConnectTokenAndFallsBack(t *testing.T) {
+	fallback := NewWorkerAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), nil, "test-key", "", 1024, 100, "app-secret")
+	provider, err := NewJWTAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), JWTAuthConfig{Secret: "jwt-secret"}, fallback)
+	if err != nil {
+		t.Fatalf("NewJWTAuthProvider returned error: %v", err)
+	}
+	client := &Client{ID: "1.1", connectToken: signTestJWT(t, jwt.SigningMethodHS256, []byte("jwt-secret"), "", testJWTClaims("private-orders.7"))}
+
+	if res := provider.Authorize(client, "private-orders.7", "", ""); !res.Allowed {
+		t.Fatal("Expected connect token to authorize the channel")
+	}
+	if res := provider.Authorize(client, "private-other", "", ""); res.Allowed {
+		t.Fatal("Expected channel outside the connect token to need a signature")
+	}
+	mac := hmac.New(sha256.New, []byte("app-secret"))
+	mac.Write([]byte(channelStringToSign(client.ID, "private-other", "")))
+	if res := provider.Authorize(client, "private-other", "test-key:"+hex.EncodeToString(mac.Sum(nil)), ""); !res.Allowed {
+		t.Fatal("Expected Pusher signature to be checked by the fallback provider")
+	}
+
+	res := provider.AuthenticateUser(client, client.ConnectToken(), "")
+	if !res.Allowed || signedInUserID(res.UserData) != "42" {
+		t.Fatalf("signin = %+v, want user 42", res)
+	}
+}
+
+func TestJWTAuthLoadsPublicKeysAndJWKS(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal RSA key: %v", err)
+	}
+	pemFile := filepath.Join(dir, "rsa.pem")
+	if err := os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	jwks := `{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"ed-1","x":"` + base64.RawURLEncoding.EncodeToString(edPublic) + `"}]}`
+	jwksFile := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksFile, []byte(jwks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := NewJWTAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), JWTAuthConfig{PublicKeyFiles: []string{pemFile}, JWKSFile: jwksFile}, nil)
+	if err != nil {
+		t.Fatalf("NewJWTAuthProvider returned error: %v", err)
+	}
+	client := &Client{ID: "1.1"}
+
+	if res := provider.Authorize(client, "private-a", signTestJWT(t, jwt.SigningMethodRS256, rsaKey, "", testJWTClaims("private-a")), ""); !res.Allowed {
+		t.Fatal("Expected RS256 token to be allowed")
+	}
+	if res := provider.Authorize(client, "private-a", signTestJWT(t, jwt.SigningMethodEdDSA, edPrivate, "ed-1", testJWTClaims("private-a")), ""); !res.Allowed {
+		t.Fatal("Expected EdDSA token from the JWKS to be allowed")
+	}
+	if res := provider.Authorize(client, "private-a", signTestJWT(t, jwt.SigningMethodEdDSA, edPrivate, "ed-2", testJWTClaims("private-a")), ""); res.Allowed {
+		t.Fatal("Expected token with an unknown kid to be denied")
+	}
+
+	if _, err := NewJWTAuthProvider(zap.NewNop(), nil, JWTAuthConfig{JWKSFile: filepath.Join(dir, "missing.json")}, nil); err == nil {
+		t.Fatal("Expected missing JWKS file to be rejected")
+	}
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func TestSubscriptionManagerLimitsSubscriptionsPerConnection(t *testing.T) {
+	sm := newTestSubManager()
+	sm.limits = subscriptionLimits{perConnection: 2}
+	client := &Client{ID: "1.1", send: make(chan any, 10)}
+
+	for _, channel := range []string{"public-a", "public-b", "public-a"} {
+		if !sm.Subscribe(client, channel, nil) {
+			t.Fatalf("Expected subscription to %s to succeed", channel)
+		}
+	}
+	drainSent(client)
+
+	if sm.Subscribe(client, "public-c", nil) {
+		t.Fatal("Expected a third channel to be rejected")
+	}
+	messages := drainSent(client)
+	if len(messages) != 1 || !strings.Contains(messages[0], `"code":4303`) {
+		t.Fatalf("rejected client received %v, want a 4303 pusher:error", messages)
+	}
+	if got := counterValue(t, sm.metrics.LimitRejections.WithLabelValues("subscriptions")); got != 1 {
+		t.Fatalf("subscription rejections = %v, want 1", got)
+	}
+
+	sm.Unsubscribe(client, "public-a")
+	if !sm.Subscribe(client, "public-c", nil) {
+		t.Fatal("Expected unsubscribing to free a slot")
+	}
+}
+
+func TestSubscriptionManagerLimitsPresenceMembers(t *testing.T) {
+	sm := newTestSubManager()
+	sm.limits = subscriptionLimits{presenceMembers: 2}
+	channel := "presence-room"
+	subscribe := func(client *Client, userID string) bool {
+		return sm.Subscribe(client, channel, []byte(`{"channel_data": "{\"user_id\":\"`+userID+`\"}"}`))
+	}
+
+	alice := &Client{ID: "1.1", send: make(chan any, 10)}
+	aliceTab := &Client{ID: "1.2", send: make(chan any, 10)}
+	bob := &Client{ID: "1.3", send: make(chan any, 10)}
+	carol := &Client{ID: "1.4", send: make(chan any, 10)}
+	if !subscribe(alice, "alice") || !subscribe(bob, "bob") {
+		t.Fatal("Expected the first two members to join")
+	}
+	if !subscribe(aliceTab, "alice") {
+		t.Fatal("Expected another connection of a present member to join")
+	}
+	if subscribe(carol, "carol") {
+		t.Fatal("Expected a third member to be rejected")
+	}
+	messages := drainSent(carol)
+	if len(messages) != 1 || !strings.Contains(messages[0], `"code":4304`) {
+		t.Fatalf("rejected member received %v, want a 4304 pusher:error", messages)
+	}
+	if got := counterValue(t, sm.metrics.LimitRejections.WithLabelValues("presence_members")); got != 1 {
+		t.Fatalf("presence rejections = %v, want 1", got)
+	}
+}
+
+func TestHubLimitsConnectionsPerUser(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	metrics := NewMetrics(prometheus.NewRegistry())
+	delivery := DefaultDeliveryConfig()
+	delivery.MaxConnectionsPerUser = 1
+	hub := NewHub("test-app", zap.NewNop(), ctx, metrics, &MockAuthProvider{}, nil, &MockBroker{}, 100, 1, DefaultPingPeriod, delivery)
+
+	first := &Client{ID: "1.1", send: make(chan any, 8)}
+	second := &Client{ID: "1.2", send: make(chan any, 8)}
+	hub.Register(first)
+	hub.Register(second)
+
+	if !hub.SignInUser(first, "42") {
+		t.Fatal("Expected the first connection of the user to sign in")
+	}
+	if hub.SignInUser(second, "42") {
+		t.Fatal("Expected a second connection of the user to be rejected")
+	}
+	if got := counterValue(t, metrics.LimitRejections.WithLabelValues("user_connections")); got != 1 {
+		t.Fatalf("user connection rejections = %v, want 1", got)
+	}
+
+	hub.Unregister(first)
+	if !hub.SignInUser(second, "42") {
+		t.Fatal("Expected disconnecting to free the user's slot")
+	}
+}
@@ -0,0 +1,830 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/y-l-g/websocket/module/internal/protocol"
+	"go.uber.org/zap"
+)
+
+const (
+	meshFrameHello   byte = 1
+	meshFrameProof   byte = 2
+	meshFrameMessage byte = 3
+	meshFramePing    byte = 4
+
+	meshMaxFrameSize      = protocol.MaxDataSize + 64*1024
+	meshHandshakeTimeout  = 5 * time.Second
+	meshPingInterval      = 5 * time.Second
+	meshReadTimeout       = 3 * meshPingInterval
+	meshMinBackoff        = 100 * time.Millisecond
+	meshMaxBackoff        = 5 * time.Second
+	meshDiscoveryInterval = 10 * time.Second
+	meshDedupSize         = 8192
+	meshMessageIDSize     = 16
+	meshFrameMACSize      = sha256.Size
+)
+
+const (
+	MeshPeerConnecting   = "connecting"
+	MeshPeerConnected    = "connected"
+	MeshPeerReconnecting = "reconnecting"
+)
+
+var errMeshSelf = errors.New("mesh peer is this node")
+
+// MeshConfig configures a MeshBroker. Peers are dialed directly; DNS names
+// (host:port) are resolved periodically and every returned address is dialed.
+type MeshConfig struct {
+	Listen    string
+	Peers     []string
+	DNS       []string
+	Secret    string
+	QueueSize int
+}
+
+// PeerHealth describes the outbound link to one mesh peer.
+type PeerHealth struct {
+	Address         string     `json:"address"`
+	NodeID          string     `json:"node_id,omitempty"`
+	State           string     `json:"state"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastConnectedAt *time.Time `json:"last_connected_at,omitempty"`
+}
+
+// MeshBroker fans out broadcasts to a small set of peer nodes over authenticated TCP links.
+// Each node dials every peer and only sends on the links it dialed.
+type MeshBroker struct {
+	logger   *zap.Logger
+	metrics  *Metrics
+	appID    string
+	nodeID   string
+	secret   []byte
+	listener net.Listener
+	bus      chan *BroadcastMessage
+	control  chan *BroadcastMessage
+	out      chan *BroadcastMessage
+	queue    int
+	static   []string
+	dns      []string
+	seen     *meshDedup
+	ctx      context.Context
+	cancel   context.CancelFunc
+	once     sync.Once
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	peers   map[string]*meshPeer
+	inbound map[net.Conn]struct{}
+}
+
+type meshPeer struct {
+	addr   string
+	send   chan []byte
+	cancel context.CancelFunc
+
+	mu              sync.Mutex
+	nodeID          string
+	state           string
+	lastErr         string
+	lastConnectedAt time.Time
+	self            bool
+}
+
+type meshHello struct {
+	AppID  string `json:"app_id"`
+	NodeID string `json:"node_id"`
+	Nonce  string `json:"nonce"`
+}
+
+func NewMeshBroker(logger *zap.Logger, metrics *Metrics, appID string, config MeshConfig) (*MeshBroker, error) {
+	if config.Secret == "" {
+		return nil, errors.New("mesh broker requires a secret")
+	}
+	listener, err := meshListen(config.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("mesh listen failed: %w", err)
+	}
+	size := config.QueueSize
+	if size <= 0 {
+		size = DefaultBrokerQueueSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &MeshBroker{
+		logger:   logger,
+		metrics:  metrics,
+		appID:    appID,
+		nodeID:   newRandomID(),
+		secret:   []byte(config.Secret),
+		listener: listener,
+		bus:      make(chan *BroadcastMessage, size),
+		control:  make(chan *BroadcastMessage, size),
+		out:      make(chan *BroadcastMessage),
+		queue:    size,
+		static:   config.Peers,
+		dns:      config.DNS,
+		seen:     newMeshDedup(meshDedupSize),
+		ctx:      ctx,
+		cancel:   cancel,
+		peers:    make(map[string]*meshPeer),
+		inbound:  make(map[net.Conn]struct{}),
+	}
+
+	b.wg.Add(2)
+	go b.acceptLoop()
+	go b.forward()
+	if len(b.static) > 0 || len(b.dns) > 0 {
+		b.wg.Add(1)
+		go b.discoveryLoop()
+	}
+	return b, nil
+}
+
+// meshListen binds through caddy's listener pool so a config reload can open
+// the same address while the previous config still holds it.
+func meshListen(address string) (net.Listener, error) {
+	addr, err := caddy.ParseNetworkAddressWithDefaults(address, "tcp", 0)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IsUnixNetwork() || addr.PortRangeSize() != 1 {
+		return nil, fmt.Errorf("mesh address %q must be a single TCP port", address)
+	}
+	ln, err := addr.Listen(context.Background(), 0, net.ListenConfig{})
+	if err != nil {
+		return nil, err
+	}
+	listener, ok := ln.(net.Listener)
+	if !ok {
+		return nil, fmt.Errorf("mesh address %q is not a stream listener", address)
+	}
+	return listener, nil
+}
+
+// Addr returns the address the mesh listener is bound to.
+func (b *MeshBroker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *MeshBroker) Publish(ctx context.Context, msg *BroadcastMessage) error {
+	select {
+	case <-b.ctx.Done():
+		return errors.New("broker closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if msg.AppID == "" {
+		copy := *msg
+		copy.AppID = b.appID
+		msg = &copy
+	}
+
+	if !b.enqueue(msg) {
+		return ErrBrokerQueueFull
+	}
+
+	payload, err := b.messagePayload(msg)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, peer := range b.peers {
+		if peer.currentState() != MeshPeerConnected {
+			continue
+		}
+		select {
+		case peer.send <- payload:
+		default:
+			if b.metrics != nil {
+				b.metrics.BrokerDropped.WithLabelValues(b.appID, "mesh_peer_queue_full").Inc()
+			}
+		}
+	}
+	return nil
+}
+
+func (b *MeshBroker) SupportsLocalPublishAck() bool {
+	return true
+}
+
+func (b *MeshBroker) SupportsClusterFanout() bool {
+	return true
+}
+
+func (b *MeshBroker) Subscribe(ctx context.Context) (<-chan *BroadcastMessage, error) {
+	return b.out, nil
+}
+
+// enqueue hands msg to the hub without blocking. Control messages have their
+// own queue, so a burst of broadcasts cannot crowd them out.
+func (b *MeshBroker) enqueue(msg *BroadcastMessage) bool {
+	queue := b.bus
+	if msg.isControl() {
+		queue = b.control
+	}
+	select {
+	case queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// forward merges both queues into the subscriber stream, control first.
+func (b *MeshBroker) forward() {
+	defer b.wg.Done()
+	for {
+		var msg *BroadcastMessage
+		select {
+		case msg = <-b.control:
+		default:
+			select {
+			case msg = <-b.control:
+			case msg = <-b.bus:
+			case <-b.ctx.Done():
+				return
+			}
+		}
+		select {
+		case b.out <- msg:
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *MeshBroker) PublishScope() string {
+	return fmt.Sprintf("mesh:%p", b)
+}
+
+func (b *MeshBroker) Close() error {
+	b.once.Do(func() {
+		b.cancel()
+		_ = b.listener.Close()
+
+		b.mu.Lock()
+		for conn := range b.inbound {
+			_ = conn.Close()
+		}
+		b.mu.Unlock()
+
+		b.wg.Wait()
+	})
+	return nil
+}
+
+// SetPeers replaces the dialed peer set, keeping links to addresses that remain.
+// When DNS names are configured the discovery loop calls it periodically.
+func (b *MeshBroker) SetPeers(addrs []string) {
+	wanted := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		wanted[addr] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ctx.Err() != nil {
+		return
+	}
+
+	for addr, peer := range b.peers {
+		if _, ok := wanted[addr]; !ok {
+			peer.cancel()
+			delete(b.peers, addr)
+			if b.metrics != nil {
+				b.metrics.MeshPeerUp.DeleteLabelValues(b.appID, addr)
+			}
+		}
+	}
+	for addr := range wanted {
+		if _, ok := b.peers[addr]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(b.ctx)
+		peer := &meshPeer{
+			addr:   addr,
+			send:   make(chan []byte, b.queue),
+			cancel: cancel,
+			state:  MeshPeerConnecting,
+		}
+		b.peers[addr] = peer
+		b.wg.Add(1)
+		go b.runPeer(ctx, peer)
+	}
+}
+
+func (b *MeshBroker) PeerHealth() []PeerHealth {
+	b.mu.Lock()
+	peers := make([]*meshPeer, 0, len(b.peers))
+	for _, peer := range b.peers {
+		peers = append(peers, peer)
+	}
+	b.mu.Unlock()
+
+	health := make([]PeerHealth, 0, len(peers))
+	for _, peer := range peers {
+		peer.mu.Lock()
+		if !peer.self {
+			entry := PeerHealth{
+				Address:   peer.addr,
+				NodeID:    peer.nodeID,
+				State:     peer.state,
+				LastError: peer.lastErr,
+			}
+			if !peer.lastConnectedAt.IsZero() {
+				at := peer.lastConnectedAt.UTC()
+				entry.LastConnectedAt = &at
+			}
+			health = append(health, entry)
+		}
+		peer.mu.Unlock()
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Address < health[j].Address
+	})
+	return health
+}
+
+func (b *MeshBroker) discoveryLoop() {
+	defer b.wg.Done()
+
+	b.SetPeers(b.discoverPeers())
+	if len(b.dns) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(meshDiscoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.SetPeers(b.discoverPeers())
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *MeshBroker) discoverPeers() []string {
+	addrs := append([]string(nil), b.static...)
+	for _, name := range b.dns {
+		host, port, err := net.SplitHostPort(name)
+		if err != nil {
+			b.logger.Warn("Mesh: invalid DNS peer", zap.String("name", name), zap.Error(err))
+			continue
+		}
+		ctx, cancel := context.WithTimeout(b.ctx, meshHandshakeTimeout)
+		hosts, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			b.logger.Warn("Mesh: DNS peer lookup failed", zap.String("name", name), zap.Error(err))
+			// Keep the peers we already know for this name.
+			b.mu.Lock()
+			for addr := range b.peers {
+				if _, addrPort, err := net.SplitHostPort(addr); err == nil && addrPort == port {
+					addrs = append(addrs, addr)
+				}
+			}
+			b.mu.Unlock()
+			continue
+		}
+		for _, resolved := range hosts {
+			addrs = append(addrs, net.JoinHostPort(resolved, port))
+		}
+	}
+	return addrs
+}
+
+func (b *MeshBroker) runPeer(ctx context.Context, peer *meshPeer) {
+	defer b.wg.Done()
+
+	backoff := meshMinBackoff
+	for ctx.Err() == nil {
+		connected, err := b.connectPeer(ctx, peer)
+		if errors.Is(err, errMeshSelf) {
+			peer.mu.Lock()
+			peer.self = true
+			peer.mu.Unlock()
+			b.logger.Debug("Mesh: skipping own address", zap.String("peer", peer.addr))
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		peer.setDown(err)
+		b.setPeerUp(peer.addr, false)
+		b.logger.Warn("Mesh: peer link down", zap.String("peer", peer.addr), zap.Error(err))
+
+		if connected {
+			backoff = meshMinBackoff
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		backoff = min(backoff*2, meshMaxBackoff)
+	}
+}
+
+// connectPeer dials one peer and forwards queued frames until the link fails.
+func (b *MeshBroker) connectPeer(ctx context.Context, peer *meshPeer) (bool, error) {
+	dialer := net.Dialer{Timeout: meshHandshakeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", peer.addr)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	reader := bufio.NewReader(conn)
+	peerNodeID, session, err := b.handshake(conn, reader, true)
+	if err != nil {
+		return false, err
+	}
+
+	// Messages queued while the link was down are stale; drop them.
+	for len(peer.send) > 0 {
+		<-peer.send
+	}
+	peer.setUp(peerNodeID)
+	b.setPeerUp(peer.addr, true)
+	b.logger.Info("Mesh: connected to peer", zap.String("peer", peer.addr), zap.String("node_id", peerNodeID))
+
+	// The peer never writes after the handshake; a read returning means the link is gone.
+	closed := make(chan error, 1)
+	go func() {
+		_, err := reader.ReadByte()
+		if err == nil {
+			err = errors.New("unexpected data from peer")
+		}
+		closed <- err
+	}()
+
+	ping := time.NewTicker(meshPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case payload := <-peer.send:
+			if err := b.writeRawFrame(conn, session.seal(meshFrameMessage, payload)); err != nil {
+				return true, err
+			}
+		case <-ping.C:
+			if err := b.writeRawFrame(conn, session.seal(meshFramePing, nil)); err != nil {
+				return true, err
+			}
+		case err := <-closed:
+			return true, err
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+	}
+}
+
+func (b *MeshBroker) acceptLoop() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			b.logger.Warn("Mesh: accept failed", zap.Error(err))
+			continue
+		}
+
+		b.mu.Lock()
+		b.inbound[conn] = struct{}{}
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go b.serveInbound(conn)
+	}
+}
+
+func (b *MeshBroker) serveInbound(conn net.Conn) {
+	defer b.wg.Done()
+	defer func() {
+		_ = conn.Close()
+		b.mu.Lock()
+		delete(b.inbound, conn)
+		b.mu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	peerNodeID, session, err := b.handshake(conn, reader, false)
+	if err != nil {
+		if !errors.Is(err, errMeshSelf) {
+			b.logger.Warn("Mesh: inbound handshake failed", zap.String("remote", conn.RemoteAddr().String()), zap.Error(err))
+		}
+		return
+	}
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(meshReadTimeout))
+		kind, payload, err := readMeshFrame(reader)
+		if err != nil {
+			if b.ctx.Err() == nil && !errors.Is(err, io.EOF) {
+				b.logger.Warn("Mesh: inbound link closed", zap.String("node_id", peerNodeID), zap.Error(err))
+			}
+			return
+		}
+		payload, err = session.open(kind, payload)
+		if err != nil {
+			b.logger.Warn("Mesh: dropping link with unauthenticated frame", zap.String("node_id", peerNodeID), zap.Error(err))
+			return
+		}
+		if kind != meshFrameMessage {
+			continue
+		}
+		if err := b.receive(payload); err != nil {
+			b.logger.Error("Mesh: invalid message frame", zap.String("node_id", peerNodeID), zap.Error(err))
+		}
+	}
+}
+
+func (b *MeshBroker) receive(payload []byte) error {
+	if len(payload) < meshMessageIDSize {
+		return errEnvelopeTruncated
+	}
+	var id [meshMessageIDSize]byte
+	copy(id[:], payload[:meshMessageIDSize])
+	if !b.seen.add(id) {
+		return nil
+	}
+
+	msg, err := DeserializeBroadcast(payload[meshMessageIDSize:])
+	if err != nil {
+		return err
+	}
+	if msg.AppID != "" && msg.AppID != b.appID {
+		return nil
+	}
+	if msg.AppID == "" {
+		msg.AppID = b.appID
+	}
+
+	// Never block the peer's read loop on a slow hub.
+	if !b.enqueue(msg) && b.metrics != nil {
+		reason := "mesh_receive_queue_full"
+		if msg.isControl() {
+			reason = "mesh_control_queue_full"
+		}
+		b.metrics.BrokerDropped.WithLabelValues(b.appID, reason).Inc()
+	}
+	return nil
+}
+
+// handshake exchanges hellos with random nonces, then proves knowledge of the
+// shared secret with a MAC over both hellos and the dial direction. The dialer
+// proves itself first and the accepting side only answers a valid proof, so
+// neither side can be used to sign a session it is not part of. Frames after
+// the handshake are authenticated with a key derived from the same transcript.
+func (b *MeshBroker) handshake(conn net.Conn, reader *bufio.Reader, dialed bool) (string, *meshSession, error) {
+	_ = conn.SetDeadline(time.Now().Add(meshHandshakeTimeout))
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	local := meshHello{AppID: b.appID, NodeID: b.nodeID, Nonce: hex.EncodeToString(nonce)}
+	hello, err := json.Marshal(local)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := b.writeFrame(conn, meshFrameHello, hello); err != nil {
+		return "", nil, err
+	}
+
+	kind, payload, err := readMeshFrame(reader)
+	if err != nil {
+		return "", nil, err
+	}
+	var peer meshHello
+	if kind != meshFrameHello || json.Unmarshal(payload, &peer) != nil {
+		return "", nil, errors.New("mesh handshake: invalid hello")
+	}
+	if peer.AppID != b.appID {
+		return "", nil, fmt.Errorf("mesh handshake: peer serves app %q", peer.AppID)
+	}
+	if peer.NodeID == b.nodeID {
+		return "", nil, errMeshSelf
+	}
+
+	dialer, acceptor := local, peer
+	if !dialed {
+		dialer, acceptor = peer, local
+	}
+	transcript := meshTranscript(b.appID, dialer, acceptor)
+	ours, theirs := b.meshMAC("dial", transcript), b.meshMAC("accept", transcript)
+	if !dialed {
+		ours, theirs = theirs, ours
+	}
+
+	if dialed {
+		if err := b.writeFrame(conn, meshFrameProof, ours); err != nil {
+			return "", nil, err
+		}
+	}
+	kind, payload, err = readMeshFrame(reader)
+	if err != nil {
+		return "", nil, err
+	}
+	if kind != meshFrameProof || !hmac.Equal(payload, theirs) {
+		return "", nil, errors.New("mesh handshake: invalid peer proof")
+	}
+	if !dialed {
+		if err := b.writeFrame(conn, meshFrameProof, ours); err != nil {
+			return "", nil, err
+		}
+	}
+	return peer.NodeID, &meshSession{key: b.meshMAC("session", transcript)}, nil
+}
+
+// meshTranscript binds a handshake to both node IDs, both nonces and which side dialed.
+func meshTranscript(appID string, dialer, acceptor meshHello) string {
+	return appID + "|" + dialer.NodeID + "|" + dialer.Nonce + "|" + acceptor.NodeID + "|" + acceptor.Nonce
+}
+
+func (b *MeshBroker) meshMAC(label, transcript string) []byte {
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write([]byte("pogo-mesh|" + label + "|" + transcript))
+	return mac.Sum(nil)
+}
+
+// meshSession authenticates the frames of one link. Each frame carries a MAC
+// over a sequence number, so frames cannot be replayed, reordered or spliced
+// in from another link.
+type meshSession struct {
+	key []byte
+	seq uint64
+}
+
+func (s *meshSession) mac(kind byte, body []byte) []byte {
+	var header [9]byte
+	binary.BigEndian.PutUint64(header[:8], s.seq)
+	header[8] = kind
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(header[:])
+	mac.Write(body)
+	s.seq++
+	return mac.Sum(nil)
+}
+
+// seal returns a complete frame with body followed by its MAC.
+func (s *meshSession) seal(kind byte, body []byte) []byte {
+	frame := make([]byte, 5, 5+len(body)+meshFrameMACSize)
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(body)+meshFrameMACSize))
+	frame = append(frame, body...)
+	return append(frame, s.mac(kind, body)...)
+}
+
+// open verifies a frame payload and returns its body.
+func (s *meshSession) open(kind byte, payload []byte) ([]byte, error) {
+	if len(payload) < meshFrameMACSize {
+		return nil, errors.New("mesh frame: missing MAC")
+	}
+	body := payload[:len(payload)-meshFrameMACSize]
+	if !hmac.Equal(payload[len(body):], s.mac(kind, body)) {
+		return nil, errors.New("mesh frame: invalid MAC")
+	}
+	return body, nil
+}
+
+// messagePayload prefixes the encoded message with a random ID used for deduplication.
+func (b *MeshBroker) messagePayload(msg *BroadcastMessage) ([]byte, error) {
+	envelope, err := EncodeBroadcastEnvelope(msg, 0)
+	if err != nil {
+		return nil, err
+	}
+	var id [meshMessageIDSize]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	b.seen.add(id)
+
+	payload := make([]byte, 0, meshMessageIDSize+len(envelope))
+	payload = append(payload, id[:]...)
+	return append(payload, envelope...), nil
+}
+
+func (b *MeshBroker) writeFrame(conn net.Conn, kind byte, payload []byte) error {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	return b.writeRawFrame(conn, append(frame, payload...))
+}
+
+func (b *MeshBroker) writeRawFrame(conn net.Conn, frame []byte) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(meshHandshakeTimeout))
+	_, err := conn.Write(frame)
+	return err
+}
+
+func readMeshFrame(reader *bufio.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:5])
+	if length > meshMaxFrameSize {
+		return 0, nil, fmt.Errorf("mesh frame too large: %d bytes", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+func (b *MeshBroker) setPeerUp(addr string, up bool) {
+	if b.metrics == nil {
+		return
+	}
+	value := 0.0
+	if up {
+		value = 1
+	}
+	b.metrics.MeshPeerUp.WithLabelValues(b.appID, addr).Set(value)
+}
+
+func (p *meshPeer) currentState() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+func (p *meshPeer) setUp(nodeID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodeID = nodeID
+	p.state = MeshPeerConnected
+	p.lastErr = ""
+	p.lastConnectedAt = time.Now()
+}
+
+func (p *meshPeer) setDown(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = MeshPeerReconnecting
+	if err != nil {
+		p.lastErr = err.Error()
+	}
+}
+
+// meshDedup remembers the most recent message IDs in a fixed-size ring.
+type meshDedup struct {
+	mu    sync.Mutex
+	seen  map[[meshMessageIDSize]byte]struct{}
+	order [][meshMessageIDSize]byte
+	next  int
+}
+
+func newMeshDedup(size int) *meshDedup {
+	return &meshDedup{
+		seen:  make(map[[meshMessageIDSize]byte]struct{}, size),
+		order: make([][meshMessageIDSize]byte, size),
+	}
+}
+
+// add records id and reports whether it was new.
+func (d *meshDedup) add(id [meshMessageIDSize]byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.seen[id]; ok {
+		return false
+	}
+	if len(d.seen) >= len(d.order) {
+		delete(d.seen, d.order[d.next])
+	}
+	d.order[d.next] = id
+	d.next = (d.next + 1) % len(d.order)
+	d.seen[id] = struct{}{}
+	return true
+}
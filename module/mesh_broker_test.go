@@ -0,0 +1,382 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func newMeshTestBroker(t *testing.T, secret string) *MeshBroker {
+	t.Helper()
+
+	broker, err := NewMeshBroker(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), "test-app", MeshConfig{
+		Listen: "127.0.0.1:0",
+		Secret: secret,
+	})
+	if err != nil {
+		t.Fatalf("NewMeshBroker failed: %v", err)
+	}
+	t.Cleanup(func() { _ = broker.Close() })
+	return broker
+}
+
+func waitForMeshPeers(t *testing.T, broker *MeshBroker, state string, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		count := 0
+		for _, peer := range broker.PeerHealth() {
+			if peer.State == state {
+				count++
+			}
+		}
+		if count == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d peers in state %q, got %+v", want, state, broker.PeerHealth())
+}
+
+func TestMeshBrokerDeliversAcrossHubs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	brokers := []*MeshBroker{
+		newMeshTestBroker(t, "secret"),
+		newMeshTestBroker(t, "secret"),
+		newMeshTestBroker(t, "secret"),
+	}
+	addrs := make([]string, 0, len(brokers))
+	for _, broker := range brokers {
+		addrs = append(addrs, broker.Addr())
+	}
+	hubs := make([]*Hub, 0, len(brokers))
+	for _, broker := range brokers {
+		// Every node gets the full list; its own address is detected and skipped.
+		broker.SetPeers(addrs)
+		hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), nil, nil, broker, 10000, 4, DefaultPingPeriod, DefaultDeliveryConfig())
+		go hub.Run()
+		hubs = append(hubs, hub)
+	}
+	for _, broker := range brokers {
+		waitForMeshPeers(t, broker, MeshPeerConnected, 2)
+	}
+
+	clients := make([]*Client, 0, len(hubs))
+	for i, hub := range hubs {
+		client := &Client{ID: string(rune('1'+i)) + ".1", send: make(chan any, 10)}
+		hub.getShard("public-room").withSubscriptions(func(sm *SubscriptionManager) {
+			sm.Subscribe(client, "public-room", nil)
+		})
+		drainClientMessage(t, client)
+		clients = append(clients, client)
+	}
+
+	if status := hubs[1].PublishWithOptions("public-room", "update", `{"n":1}`, PublishOptions{}); status != PublishOK {
+		t.Fatalf("Publish status = %v, want PublishOK", status)
+	}
+	for i, client := range clients {
+		select {
+		case <-client.send:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected client on node %d to receive the broadcast", i)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for i, client := range clients {
+		if got := len(client.send); got != 0 {
+			t.Fatalf("Expected client on node %d to receive the broadcast once, got %d extra", i, got)
+		}
+	}
+
+	health := hubs[0].Health()
+	if len(health.Peers) != 2 || health.Peers[0].NodeID == "" {
+		t.Fatalf("Expected hub health to list two connected peers, got %+v", health.Peers)
+	}
+}
+
+func TestMeshBrokerDropsDuplicateFrames(t *testing.T) {
+	sender := newMeshTestBroker(t, "secret")
+	receiver := newMeshTestBroker(t, "secret")
+
+	bus, err := receiver.Subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	payload, err := sender.messagePayload(&BroadcastMessage{AppID: "test-app", Channel: "public", Event: "once", Data: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatalf("messagePayload failed: %v", err)
+	}
+	for range 2 {
+		if err := receiver.receive(payload); err != nil {
+			t.Fatalf("receive failed: %v", err)
+		}
+	}
+
+	select {
+	case msg := <-bus:
+		if msg.Event != "once" {
+			t.Fatalf("Unexpected message: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected first copy to be delivered")
+	}
+	select {
+	case msg := <-bus:
+		t.Fatalf("Expected duplicate frame to be dropped, got %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMeshBrokerRejectsPeerWithWrongSecret(t *testing.T) {
+	good := newMeshTestBroker(t, "secret")
+	bad := newMeshTestBroker(t, "other")
+
+	bad.SetPeers([]string{good.Addr()})
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		peers := bad.PeerHealth()
+		if len(peers) == 1 && peers[0].LastError != "" {
+			if peers[0].State == MeshPeerConnected {
+				t.Fatalf("Expected peer with wrong secret to stay disconnected, got %+v", peers[0])
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected handshake failure to be reported, got %+v", bad.PeerHealth())
+}
+
+func TestMeshBrokerRejectsRelayedHandshakeAndForgedFrames(t *testing.T) {
+	target := newMeshTestBroker(t, "secret")
+	victim := newMeshTestBroker(t, "secret")
+	bus, err := target.Subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// An attacker without the secret relays the victim's handshake to the target...
+	relay, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer func() { _ = relay.Close() }()
+	victim.SetPeers([]string{relay.Addr().String()})
+	victimConn, err := relay.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer func() { _ = victimConn.Close() }()
+	targetConn, err := net.Dial("tcp", target.Addr())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() { _ = targetConn.Close() }()
+
+	victimReader, targetReader := bufio.NewReader(victimConn), bufio.NewReader(targetConn)
+	forward := func(from *bufio.Reader, to net.Conn) {
+		t.Helper()
+		kind, payload, err := readMeshFrame(from)
+		if err != nil {
+			t.Fatalf("Relay read failed: %v", err)
+		}
+		if err := target.writeFrame(to, kind, payload); err != nil {
+			t.Fatalf("Relay write failed: %v", err)
+		}
+	}
+	forward(victimReader, targetConn)
+	forward(targetReader, victimConn)
+	forward(victimReader, targetConn)
+	forward(targetReader, victimConn)
+	waitForMeshPeers(t, victim, MeshPeerConnected, 1)
+
+	// ...then injects its own broadcast on the authenticated link.
+	payload, err := victim.messagePayload(&BroadcastMessage{AppID: "test-app", Channel: "public", Event: "forged", Data: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatalf("messagePayload failed: %v", err)
+	}
+	forged := (&meshSession{key: []byte("guessed")}).seal(meshFrameMessage, payload)
+	if _, err := targetConn.Write(forged); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	select {
+	case msg := <-bus:
+		t.Fatalf("Expected forged frame to be dropped, got %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	_ = targetConn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := targetReader.ReadByte(); err == nil {
+		t.Fatal("Expected target to close the link after a forged frame")
+	}
+}
+
+func TestMeshBrokerAcceptorWaitsForDialerProof(t *testing.T) {
+	target := newMeshTestBroker(t, "secret")
+	conn, err := net.Dial("tcp", target.Addr())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+
+	hello, _ := json.Marshal(meshHello{AppID: "test-app", NodeID: "attacker", Nonce: "00"})
+	if err := target.writeFrame(conn, meshFrameHello, hello); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if kind, _, err := readMeshFrame(reader); err != nil || kind != meshFrameHello {
+		t.Fatalf("Expected hello from target, got kind %d: %v", kind, err)
+	}
+
+	// Without a proof from the dialer the target must not sign anything.
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if kind, _, err := readMeshFrame(reader); err == nil {
+		t.Fatalf("Expected no frame before the dialer's proof, got kind %d", kind)
+	}
+}
+
+func TestMeshBrokerReconnectsAfterPeerRestart(t *testing.T) {
+	dialer := newMeshTestBroker(t, "secret")
+	peer := newMeshTestBroker(t, "secret")
+	addr := peer.Addr()
+
+	dialer.SetPeers([]string{addr})
+	waitForMeshPeers(t, dialer, MeshPeerConnected, 1)
+
+	_ = peer.Close()
+	waitForMeshPeers(t, dialer, MeshPeerReconnecting, 1)
+
+	var restarted *MeshBroker
+	deadline := time.Now().Add(3 * time.Second)
+	for restarted == nil {
+		broker, err := NewMeshBroker(zap.NewNop(), nil, "test-app", MeshConfig{Listen: addr, Secret: "secret"})
+		if err == nil {
+			restarted = broker
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed to restart peer on %s: %v", addr, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer func() { _ = restarted.Close() }()
+
+	waitForMeshPeers(t, dialer, MeshPeerConnected, 1)
+}
+
+func TestMeshBrokerSharesListenAddressAcrossReload(t *testing.T) {
+	old := newMeshTestBroker(t, "secret")
+	dialer := newMeshTestBroker(t, "secret")
+
+	// A reload provisions the new broker before the old config is cleaned up.
+	reloaded, err := NewMeshBroker(zap.NewNop(), nil, "test-app", MeshConfig{Listen: old.Addr(), Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Expected reload to bind the address still held by the old config: %v", err)
+	}
+	defer func() { _ = reloaded.Close() }()
+	_ = old.Close()
+
+	dialer.SetPeers([]string{reloaded.Addr()})
+	waitForMeshPeers(t, dialer, MeshPeerConnected, 1)
+}
+
+func TestWebsocketModuleParsesMeshOptions(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`pogo_websocket {
+		app_id pogo-app
+		app_key pogo-key
+		app_secret test-secret
+		mesh_listen :7946
+		mesh_peers 10.0.0.2:7946 10.0.0.3:7946
+		mesh_peers 10.0.0.4:7946
+		mesh_dns pogo-headless.default.svc:7946
+	}`)
+
+	var m WebsocketModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile returned error: %v", err)
+	}
+	if err := m.validateAndDefaults(); err != nil {
+		t.Fatalf("validateAndDefaults returned error: %v", err)
+	}
+	if m.MeshListen != ":7946" || len(m.MeshPeers) != 3 || len(m.MeshDNS) != 1 {
+		t.Fatalf("mesh config = %q/%v/%v", m.MeshListen, m.MeshPeers, m.MeshDNS)
+	}
+	if m.MeshSecret != "test-secret" {
+		t.Fatalf("mesh secret = %q, want app secret fallback", m.MeshSecret)
+	}
+
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", MeshListen: ":7946", RedisHost: "localhost:6379"}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected mesh_listen with redis_host to be rejected")
+	}
+	m = WebsocketModule{AppID: "pogo-app", AppKey: "pogo-key", AppSecret: "test-secret", MeshPeers: []string{"10.0.0.2:7946"}}
+	if err := m.validateAndDefaults(); err == nil {
+		t.Fatal("Expected mesh_peers without mesh_listen to be rejected")
+	}
+}
+
+func TestMeshBrokerReceiveDropsBroadcastsInsteadOfBlocking(t *testing.T) {
+	metrics := NewMetrics(prometheus.NewRegistry())
+	receiver, err := NewMeshBroker(zap.NewNop(), metrics, "test-app", MeshConfig{Listen: "127.0.0.1:0", Secret: "secret", QueueSize: 2})
+	if err != nil {
+		t.Fatalf("NewMeshBroker failed: %v", err)
+	}
+	defer func() { _ = receiver.Close() }()
+	sender := newMeshTestBroker(t, "secret")
+
+	// Nobody reads the stream: the forwarder holds one broadcast, the queue two more.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			payload, err := sender.messagePayload(&BroadcastMessage{AppID: "test-app", Channel: "public-room", Event: "update", Data: json.RawMessage(`{}`)})
+			if err != nil {
+				t.Errorf("messagePayload failed: %v", err)
+				return
+			}
+			if err := receiver.receive(payload); err != nil {
+				t.Errorf("receive failed: %v", err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected receive not to block on a full queue")
+	}
+	if got := counterValue(t, metrics.BrokerDropped.WithLabelValues("test-app", "mesh_receive_queue_full")); got < 2 {
+		t.Fatalf("mesh_receive_queue_full drops = %v, want at least 2", got)
+	}
+
+	payload, err := sender.messagePayload(&BroadcastMessage{AppID: "test-app", Channel: "public-room", Event: "acked", Data: json.RawMessage(`{}`), Ack: &PublishAckRequest{ID: "ack"}})
+	if err != nil {
+		t.Fatalf("messagePayload failed: %v", err)
+	}
+	if err := receiver.receive(payload); err != nil {
+		t.Fatalf("receive failed: %v", err)
+	}
+	stream, _ := receiver.Subscribe(context.Background())
+	seen := []string{}
+	for len(seen) == 0 || seen[len(seen)-1] != "acked" {
+		select {
+		case msg := <-stream:
+			seen = append(seen, msg.Event)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the control message to be delivered, got %v", seen)
+		}
+	}
+	if len(seen) > 2 {
+		t.Fatalf("delivery order = %v, want the control message ahead of queued broadcasts", seen)
+	}
+}
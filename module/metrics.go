@@ -13,10 +13,24 @@ type Metrics struct {
 	Subscriptions        prometheus.Gauge
 	AuthDuration         prometheus.Histogram
 	BreakerTripped       prometheus.Counter
+	AuthBreakerState     prometheus.Gauge
+	AuthBatchSize        prometheus.Histogram
 	AuthFailures         *prometheus.CounterVec
+	AuthCacheLookups     *prometheus.CounterVec
+	KeyVerifications     *prometheus.CounterVec
+	LimitRejections      *prometheus.CounterVec
+	PolicyRejections     *prometheus.CounterVec
+	SubscriptionsRevoked *prometheus.CounterVec
 	DroppedMessages      *prometheus.CounterVec
 	BrokerDropped        *prometheus.CounterVec
 	PublishFailures      *prometheus.CounterVec
+	OutboxDepth          *prometheus.GaugeVec
+	OutboxAge            *prometheus.GaugeVec
+	MeshPeerUp           *prometheus.GaugeVec
+	IngestMessages       *prometheus.CounterVec
+	ScheduledPending     *prometheus.GaugeVec
+	ScheduledEvents      *prometheus.CounterVec
+	PublishDeduplicated  *prometheus.CounterVec
 	WebhookQueueDepth    prometheus.Gauge
 	WebhookDropped       *prometheus.CounterVec
 	PublishDuration      *prometheus.HistogramVec
@@ -61,11 +75,47 @@ func NewMetrics(reg prometheus.Registerer) *Metrics {
 			Name:      "circuit_breaker_open_total",
 			Help:      "Total number of auth requests rejected because the Circuit Breaker was open",
 		}),
+		AuthBreakerState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "pogo_websocket",
+			Name:      "circuit_breaker_state",
+			Help:      "Auth circuit breaker state (0 closed, 1 half-open, 2 open)",
+		}),
+		AuthBatchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "pogo_websocket",
+			Name:      "auth_batch_channels",
+			Help:      "Channels authorized per batched auth worker request",
+			Buckets:   []float64{1, 2, 3, 5, 10, 20, 50},
+		}),
 		AuthFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: "pogo_websocket",
 			Name:      "auth_failures_total",
 			Help:      "Total number of failed auth requests",
 		}, []string{"reason"}),
+		AuthCacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pogo_websocket",
+			Name:      "auth_cache_lookups_total",
+			Help:      "Auth worker cache lookups by result",
+		}, []string{"result"}),
+		KeyVerifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pogo_websocket",
+			Name:      "key_verifications_total",
+			Help:      "Verified signatures by scope and app key version",
+		}, []string{"scope", "version"}),
+		LimitRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pogo_websocket",
+			Name:      "limit_rejections_total",
+			Help:      "Signins and subscriptions rejected by per-user and per-channel limits",
+		}, []string{"limit"}),
+		PolicyRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pogo_websocket",
+			Name:      "policy_rejections_total",
+			Help:      "Subscriptions, client events and publishes refused by channel_policy rules",
+		}, []string{"reason"}),
+		SubscriptionsRevoked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pogo_websocket",
+			Name:      "subscriptions_revoked_total",
+			Help:      "Subscriptions removed by re-authorization or a forced unsubscribe",
+		}, []string{"reason"}),
 		DroppedMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: "pogo_websocket",
 			Name:      "client_dropped_messages_total",
@@ -81,6 +131,41 @@ func NewMetrics(reg prometheus.Registerer) *Metrics {
 			Name:      "publish_failures_total",
 			Help:      "Number of failed publish attempts by reason",
 		}, []string{"app_id", "reason"}),
+		OutboxDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pogo_websocket",
+			Name:      "outbox_depth",
+			Help:      "Current number of publishes buffered while the broker is unavailable",
+		}, []string{"app_id"}),
+		OutboxAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pogo_websocket",
+			Name:      "outbox_oldest_age_seconds",
+			Help:      "Age of the oldest publish buffered in the outbox",
+		}, []string{"app_id"}),
+		MeshPeerUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pogo_websocket",
+			Name:      "mesh_peer_up",
+			Help:      "Whether the outbound link to a mesh peer is connected",
+		}, []string{"app_id", "peer"}),
+		IngestMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pogo_websocket",
+			Name:      "ingest_messages_total",
+			Help:      "Broadcast jobs consumed from the Redis ingest list by result",
+		}, []string{"app_id", "result"}),
+		ScheduledPending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pogo_websocket",
+			Name:      "scheduled_pending",
+			Help:      "Scheduled publishes waiting for their delivery time",
+		}, []string{"app_id"}),
+		ScheduledEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pogo_websocket",
+			Name:      "scheduled_events_total",
+			Help:      "Scheduled publishes by result",
+		}, []string{"app_id", "result"}),
+		PublishDeduplicated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pogo_websocket",
+			Name:      "publish_deduplicated_total",
+			Help:      "Publishes dropped because their idempotency key was already seen",
+		}, []string{"app_id"}),
 		WebhookQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: "pogo_websocket",
 			Name:      "webhook_queue_depth",
@@ -157,10 +242,24 @@ func NewMetrics(reg prometheus.Registerer) *Metrics {
 		_ = reg.Register(m.Subscriptions)
 		_ = reg.Register(m.AuthDuration)
 		_ = reg.Register(m.BreakerTripped)
+		_ = reg.Register(m.AuthBreakerState)
+		_ = reg.Register(m.AuthBatchSize)
 		_ = reg.Register(m.AuthFailures)
+		_ = reg.Register(m.AuthCacheLookups)
+		_ = reg.Register(m.KeyVerifications)
+		_ = reg.Register(m.LimitRejections)
+		_ = reg.Register(m.PolicyRejections)
+		_ = reg.Register(m.SubscriptionsRevoked)
 		_ = reg.Register(m.DroppedMessages)
 		_ = reg.Register(m.BrokerDropped)
 		_ = reg.Register(m.PublishFailures)
+		_ = reg.Register(m.OutboxDepth)
+		_ = reg.Register(m.OutboxAge)
+		_ = reg.Register(m.MeshPeerUp)
+		_ = reg.Register(m.IngestMessages)
+		_ = reg.Register(m.ScheduledPending)
+		_ = reg.Register(m.ScheduledEvents)
+		_ = reg.Register(m.PublishDeduplicated)
 		_ = reg.Register(m.WebhookQueueDepth)
 		_ = reg.Register(m.WebhookDropped)
 		_ = reg.Register(m.PublishDuration)
@@ -186,6 +285,9 @@ func (m *Metrics) SetDeliveryConfig(config DeliveryConfig) {
 	m.DeliveryConfig.WithLabelValues("write_burst_size").Set(float64(config.WriteBurstSize))
 	m.DeliveryConfig.WithLabelValues("broker_queue_size").Set(float64(config.BrokerQueueSize))
 	m.DeliveryConfig.WithLabelValues("shard_queue_size").Set(float64(config.ShardQueueSize))
+	m.DeliveryConfig.WithLabelValues("max_connections_per_user").Set(float64(config.MaxConnectionsPerUser))
+	m.DeliveryConfig.WithLabelValues("max_subscriptions_per_connection").Set(float64(config.MaxSubscriptionsPerConnection))
+	m.DeliveryConfig.WithLabelValues("max_presence_members").Set(float64(config.MaxPresenceMembers))
 	if config.EnableCompression {
 		m.DeliveryConfig.WithLabelValues("enable_compression").Set(1)
 	} else {
@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// nativePublishRequest is the options array accepted by pogo_websocket_publish_with_options.
+type nativePublishRequest struct {
+	SocketID       string     `json:"socket_id"`
+	Ack            PublishAck `json:"ack"`
+	AckTimeoutMs   int64      `json:"ack_timeout_ms"`
+	IdempotencyKey string     `json:"idempotency_key"`
+	DeliverAtMs    int64      `json:"deliver_at_ms"`
+	CancelKey      string     `json:"cancel_key"`
+	PublishTarget
+}
+
+// parseNativePublishOptions decodes the JSON-encoded options array. Unknown keys
+// are rejected so a misspelled option does not silently publish to everyone.
+func parseNativePublishOptions(optionsJSON string) (PublishOptions, bool) {
+	if optionsJSON == "" {
+		return PublishOptions{}, true
+	}
+
+	var request nativePublishRequest
+	decoder := json.NewDecoder(bytes.NewReader([]byte(optionsJSON)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		return PublishOptions{}, false
+	}
+	if request.Ack < PublishAckNone || request.Ack > PublishAckQuorum || request.AckTimeoutMs < 0 {
+		return PublishOptions{}, false
+	}
+
+	options := PublishOptions{
+		ExceptSocketID: request.SocketID,
+		Ack:            request.Ack,
+		AckTimeout:     time.Duration(request.AckTimeoutMs) * time.Millisecond,
+		IdempotencyKey: request.IdempotencyKey,
+		CancelKey:      request.CancelKey,
+		Target:         request.PublishTarget,
+	}
+	if request.DeliverAtMs > 0 {
+		options.DeliverAt = time.UnixMilli(request.DeliverAtMs)
+	}
+	return options, true
+}
+
+// publishChannelsWithOptions publishes one event to each channel and returns
+// one status per channel, in order.
+func publishChannelsWithOptions(appID, channelsJSON, event, data, optionsJSON string) []PublishStatus {
+	var channels []string
+	if err := json.Unmarshal([]byte(channelsJSON), &channels); err != nil {
+		return nil
+	}
+	statuses := make([]PublishStatus, len(channels))
+
+	options, ok := parseNativePublishOptions(optionsJSON)
+	if !ok {
+		for i := range statuses {
+			statuses[i] = PublishInvalidOptions
+		}
+		return statuses
+	}
+	if options.CancelKey != "" && len(channels) > 1 {
+		for i := range statuses {
+			statuses[i] = PublishInvalidSchedule
+		}
+		return statuses
+	}
+
+	hubs := GetHubs(appID)
+	for i, channel := range channels {
+		switch {
+		case len(hubs) == 0:
+			statuses[i] = PublishHubMissing
+		case channel == "":
+			statuses[i] = PublishInvalidChannelsJSON
+		default:
+			statuses[i] = publishToActiveHubsWithOptions(hubs, channel, event, data, options)
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,52 @@
+package websocket
+
+import "testing"
+
+func TestParseNativePublishOptions(t *testing.T) {
+	options, ok := parseNativePublishOptions(`{"socket_id":"1.1","ack":1,"ack_timeout_ms":250,"except_user_ids":["42"]}`)
+	if !ok {
+		t.Fatal("expected options to parse")
+	}
+	if options.ExceptSocketID != "1.1" || options.Ack != PublishAckLocal || options.AckTimeout.Milliseconds() != 250 {
+		t.Fatalf("options = %+v, want socket 1.1, local ack, 250ms", options)
+	}
+	if len(options.Target.ExceptUserIDs) != 1 || options.Target.ExceptUserIDs[0] != "42" {
+		t.Fatalf("target = %+v, want except user 42", options.Target)
+	}
+
+	for _, invalid := range []string{`{"socket":"1.1"}`, `{"ack":3}`, `["1.1"]`, `{"ack_timeout_ms":-1}`} {
+		if _, ok := parseNativePublishOptions(invalid); ok {
+			t.Fatalf("expected %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestPublishChannelsWithOptionsReportsEachChannel(t *testing.T) {
+	if statuses := publishChannelsWithOptions("test-app", `["a"]`, "update", `{}`, ""); len(statuses) != 1 || statuses[0] != PublishHubMissing {
+		t.Fatalf("statuses without hub = %v, want [PublishHubMissing]", statuses)
+	}
+
+	_, broker, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+
+	statuses := publishChannelsWithOptions("test-app", `["a","","b"]`, "update", `{}`, `{"socket_id":"1.1"}`)
+	want := []PublishStatus{PublishOK, PublishInvalidChannelsJSON, PublishOK}
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", statuses, want)
+		}
+	}
+	for range 2 {
+		if msg := readPublishedMessage(t, broker.published); msg.ExceptSocketID != "1.1" {
+			t.Fatalf("ExceptSocketID = %q, want 1.1", msg.ExceptSocketID)
+		}
+	}
+
+	statuses = publishChannelsWithOptions("test-app", `["a","b"]`, "update", `{}`, `{"unknown":true}`)
+	if statuses[0] != PublishInvalidOptions || statuses[1] != PublishInvalidOptions {
+		t.Fatalf("statuses with unknown option = %v, want PublishInvalidOptions", statuses)
+	}
+}
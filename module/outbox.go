@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultOutboxMaxAge = 30 * time.Second
+	outboxRetryInterval = 500 * time.Millisecond
+	// outboxDirectPublishTimeout bounds a direct publish before it falls back
+	// to the outbox.
+	outboxDirectPublishTimeout = 250 * time.Millisecond
+	// recentPublishIDLimit bounds the publish IDs each Redis subscriber keeps.
+	recentPublishIDLimit = 65536
+)
+
+// recentPublishIDs remembers the publish IDs a subscriber has delivered within
+// window, so a message resent from an outbox after an ambiguous failure is
+// delivered once. At most limit IDs are kept; the oldest go first.
+type recentPublishIDs struct {
+	window time.Duration
+	limit  int
+	seen   map[string]struct{}
+	order  []recentPublishID
+}
+
+type recentPublishID struct {
+	id      string
+	expires time.Time
+}
+
+func newRecentPublishIDs(window time.Duration, limit int) *recentPublishIDs {
+	return &recentPublishIDs{window: window, limit: limit, seen: make(map[string]struct{})}
+}
+
+// repeated records id and reports whether it was already delivered.
+func (r *recentPublishIDs) repeated(id string, now time.Time) bool {
+	for len(r.order) > 0 && (len(r.order) >= r.limit || !now.Before(r.order[0].expires)) {
+		delete(r.seen, r.order[0].id)
+		r.order[0] = recentPublishID{}
+		r.order = r.order[1:]
+	}
+	if _, ok := r.seen[id]; ok {
+		return true
+	}
+	r.seen[id] = struct{}{}
+	r.order = append(r.order, recentPublishID{id: id, expires: now.Add(r.window)})
+	return false
+}
+
+type outboxEntry struct {
+	data     []byte
+	queuedAt time.Time
+}
+
+// publishOutbox buffers encoded broker messages while the broker is unavailable.
+// Entries are flushed in order and dropped once they are older than maxAge.
+type publishOutbox struct {
+	mu      sync.Mutex
+	entries []outboxEntry
+	size    int
+	maxAge  time.Duration
+	appID   string
+	metrics *Metrics
+	wake    chan struct{}
+}
+
+func newPublishOutbox(appID string, size int, maxAge time.Duration, metrics *Metrics) *publishOutbox {
+	if maxAge <= 0 {
+		maxAge = DefaultOutboxMaxAge
+	}
+	return &publishOutbox{
+		size:    size,
+		maxAge:  maxAge,
+		appID:   appID,
+		metrics: metrics,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+func (o *publishOutbox) empty() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries) == 0
+}
+
+func (o *publishOutbox) push(data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.expireLocked(time.Now())
+	if len(o.entries) >= o.size {
+		o.drop("outbox_full", 1)
+		return fmt.Errorf("%w: outbox full", ErrBrokerQueueFull)
+	}
+	o.entries = append(o.entries, outboxEntry{data: data, queuedAt: time.Now()})
+	o.observeLocked(time.Now())
+	o.notify()
+	return nil
+}
+
+// front returns the oldest entry that has not expired.
+func (o *publishOutbox) front() ([]byte, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.expireLocked(time.Now())
+	if len(o.entries) == 0 {
+		return nil, false
+	}
+	return o.entries[0].data, true
+}
+
+func (o *publishOutbox) pop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.entries) > 0 {
+		o.entries[0] = outboxEntry{}
+		o.entries = o.entries[1:]
+	}
+	o.observeLocked(time.Now())
+}
+
+func (o *publishOutbox) observe() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expireLocked(time.Now())
+	o.observeLocked(time.Now())
+}
+
+func (o *publishOutbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *publishOutbox) expireLocked(now time.Time) {
+	expired := 0
+	for expired < len(o.entries) && now.Sub(o.entries[expired].queuedAt) > o.maxAge {
+		o.entries[expired] = outboxEntry{}
+		expired++
+	}
+	if expired == 0 {
+		return
+	}
+	o.entries = o.entries[expired:]
+	o.drop("outbox_expired", expired)
+	o.observeLocked(now)
+}
+
+func (o *publishOutbox) observeLocked(now time.Time) {
+	if o.metrics == nil {
+		return
+	}
+	o.metrics.OutboxDepth.WithLabelValues(o.appID).Set(float64(len(o.entries)))
+	age := 0.0
+	if len(o.entries) > 0 {
+		age = now.Sub(o.entries[0].queuedAt).Seconds()
+	}
+	o.metrics.OutboxAge.WithLabelValues(o.appID).Set(age)
+}
+
+func (o *publishOutbox) drop(reason string, count int) {
+	if o.metrics != nil {
+		o.metrics.BrokerDropped.WithLabelValues(o.appID, reason).Add(float64(count))
+	}
+}
@@ -0,0 +1,349 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/y-l-g/websocket/module/internal/protocol"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultPresenceNodeTTL       = 30 * time.Second
+	DefaultPresenceQueueSize     = 4096
+	presenceShutdownCleanupLimit = 2 * time.Second
+)
+
+const (
+	PresenceActionAdd    = "add"
+	PresenceActionRemove = "remove"
+)
+
+// PresenceUpdate describes one presence connection joining or leaving a channel on a node.
+type PresenceUpdate struct {
+	Action   string          `json:"action"`
+	Channel  string          `json:"channel"`
+	NodeID   string          `json:"node_id"`
+	SocketID string          `json:"socket_id"`
+	UserID   string          `json:"user_id"`
+	UserInfo json.RawMessage `json:"user_info,omitempty"`
+}
+
+func (u PresenceUpdate) entryKey() string {
+	return u.NodeID + "|" + u.SocketID
+}
+
+// ownedKey tells apart one connection's entries in different channels.
+func (u PresenceUpdate) ownedKey() string {
+	return u.Channel + "|" + u.entryKey()
+}
+
+func (u PresenceUpdate) member() Member {
+	info := u.UserInfo
+	if len(info) == 0 {
+		info = json.RawMessage("null")
+	}
+	return Member{UserID: u.UserID, UserInfo: info}
+}
+
+// PresenceBroker is implemented by brokers that keep presence membership in shared storage.
+type PresenceBroker interface {
+	AddPresenceMember(ctx context.Context, update PresenceUpdate) error
+	RemovePresenceMember(ctx context.Context, update PresenceUpdate) (bool, error)
+	PresenceMembers(ctx context.Context) ([]PresenceUpdate, error)
+	RefreshPresenceNode(ctx context.Context, nodeID string, ttl time.Duration) error
+	RemovePresenceNode(ctx context.Context, nodeID string) error
+	ReapPresenceMembers(ctx context.Context) ([]PresenceUpdate, error)
+}
+
+// presenceReplicator receives local membership changes from a SubscriptionManager.
+type presenceReplicator interface {
+	MemberAdded(channel, socketID string, member Member)
+	MemberRemoved(channel, socketID, userID string)
+}
+
+type clusterPresence struct {
+	appID   string
+	nodeID  string
+	store   PresenceBroker
+	broker  Broker
+	logger  *zap.Logger
+	metrics *Metrics
+	ttl     time.Duration
+	updates chan PresenceUpdate
+	resync  chan struct{}
+	owned   map[string]PresenceUpdate
+	stale   bool
+	dirty   bool
+	done    chan struct{}
+}
+
+func newClusterPresence(appID, nodeID string, broker Broker, logger *zap.Logger, metrics *Metrics) *clusterPresence {
+	store, ok := broker.(PresenceBroker)
+	if !ok {
+		return nil
+	}
+	return &clusterPresence{
+		appID:   appID,
+		nodeID:  nodeID,
+		store:   store,
+		broker:  broker,
+		logger:  logger,
+		metrics: metrics,
+		ttl:     DefaultPresenceNodeTTL,
+		updates: make(chan PresenceUpdate, DefaultPresenceQueueSize),
+		resync:  make(chan struct{}, 1),
+		owned:   make(map[string]PresenceUpdate),
+		done:    make(chan struct{}),
+	}
+}
+
+func newRandomID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (p *clusterPresence) MemberAdded(channel, socketID string, member Member) {
+	p.enqueue(PresenceUpdate{
+		Action:   PresenceActionAdd,
+		Channel:  channel,
+		NodeID:   p.nodeID,
+		SocketID: socketID,
+		UserID:   member.UserID,
+		UserInfo: member.UserInfo,
+	})
+}
+
+func (p *clusterPresence) MemberRemoved(channel, socketID, userID string) {
+	p.enqueue(PresenceUpdate{
+		Action:   PresenceActionRemove,
+		Channel:  channel,
+		NodeID:   p.nodeID,
+		SocketID: socketID,
+		UserID:   userID,
+	})
+}
+
+func (p *clusterPresence) enqueue(update PresenceUpdate) {
+	select {
+	case p.updates <- update:
+	default:
+		if p.metrics != nil {
+			p.metrics.BrokerDropped.WithLabelValues(p.appID, "presence_queue_full").Inc()
+		}
+		p.logger.Warn("Presence: replication queue full, dropping update",
+			zap.String("channel", update.Channel),
+			zap.String("action", update.Action),
+			zap.String("socket_id", update.SocketID))
+		p.invalidate()
+	}
+}
+
+// invalidate schedules a full resync with the shared store, after an update
+// was dropped or the broker reconnected and may have missed some.
+func (p *clusterPresence) invalidate() {
+	select {
+	case p.resync <- struct{}{}:
+	default:
+	}
+}
+
+// load replaces the members the local shards hold for other nodes with the
+// shared store's view.
+func (p *clusterPresence) load(ctx context.Context, h *Hub) error {
+	members, err := p.store.PresenceMembers(ctx)
+	if err != nil {
+		p.logger.Error("Presence: failed to load cluster members", zap.Error(err))
+		return err
+	}
+
+	byShard := make(map[*HubShard][]PresenceUpdate)
+	for _, update := range members {
+		if update.NodeID == p.nodeID {
+			continue
+		}
+		shard := h.getShard(update.Channel)
+		byShard[shard] = append(byShard[shard], update)
+	}
+	for _, shard := range h.shards {
+		remote := byShard[shard]
+		shard.withSubscriptions(func(sm *SubscriptionManager) {
+			sm.SyncRemotePresence(remote)
+		})
+	}
+	return nil
+}
+
+// sync rebuilds this node's entries in the store from the local shards and
+// reloads everyone else's. Queued updates are discarded first: the shard
+// state read afterwards already reflects them.
+func (p *clusterPresence) sync(ctx context.Context, h *Hub) {
+	p.dirty = true
+	for drained := false; !drained; {
+		select {
+		case <-p.updates:
+		default:
+			drained = true
+		}
+	}
+
+	local := make(map[string]PresenceUpdate)
+	for _, shard := range h.shards {
+		shard.withSubscriptions(func(sm *SubscriptionManager) {
+			for _, update := range sm.LocalPresence(p.nodeID) {
+				local[update.ownedKey()] = update
+			}
+		})
+	}
+
+	for key, update := range p.owned {
+		if _, ok := local[key]; ok {
+			continue
+		}
+		update.Action = PresenceActionRemove
+		if _, err := p.store.RemovePresenceMember(ctx, update); err != nil {
+			p.logger.Warn("Presence: resync failed to remove member", zap.String("channel", update.Channel), zap.Error(err))
+			return
+		}
+		delete(p.owned, key)
+		p.publish(ctx, update)
+	}
+	for key, update := range local {
+		if err := p.store.AddPresenceMember(ctx, update); err != nil {
+			p.logger.Warn("Presence: resync failed to store member", zap.String("channel", update.Channel), zap.Error(err))
+			return
+		}
+		p.owned[key] = update
+		p.publish(ctx, update)
+	}
+
+	if err := p.load(ctx, h); err != nil {
+		return
+	}
+	p.dirty = false
+	p.logger.Info("Presence: resynchronized cluster membership", zap.Int("local_members", len(local)))
+}
+
+func (p *clusterPresence) run(ctx context.Context, h *Hub) {
+	defer close(p.done)
+
+	p.refresh(ctx)
+	ticker := time.NewTicker(p.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case update := <-p.updates:
+			p.apply(ctx, update)
+		case <-p.resync:
+			p.sync(ctx, h)
+		case <-ticker.C:
+			p.refresh(ctx)
+			p.reap(ctx)
+			if p.dirty {
+				p.sync(ctx, h)
+			}
+		case <-ctx.Done():
+			p.cleanup()
+			return
+		}
+	}
+}
+
+func (p *clusterPresence) apply(ctx context.Context, update PresenceUpdate) {
+	switch update.Action {
+	case PresenceActionAdd:
+		if err := p.store.AddPresenceMember(ctx, update); err != nil {
+			p.logger.Error("Presence: failed to store member", zap.String("channel", update.Channel), zap.Error(err))
+		}
+		p.owned[update.ownedKey()] = update
+	case PresenceActionRemove:
+		if _, err := p.store.RemovePresenceMember(ctx, update); err != nil {
+			p.logger.Error("Presence: failed to remove member", zap.String("channel", update.Channel), zap.Error(err))
+		}
+		delete(p.owned, update.ownedKey())
+	default:
+		return
+	}
+	p.publish(ctx, update)
+}
+
+func (p *clusterPresence) publish(ctx context.Context, update PresenceUpdate) {
+	event := protocol.EventMemberAdded
+	if update.Action == PresenceActionRemove {
+		event = protocol.EventMemberRemoved
+	}
+	if err := p.broker.Publish(ctx, &BroadcastMessage{
+		AppID:    p.appID,
+		Channel:  update.Channel,
+		Event:    event,
+		Data:     json.RawMessage("{}"),
+		Presence: &update,
+	}); err != nil {
+		p.logger.Warn("Presence: failed to publish membership update", zap.String("channel", update.Channel), zap.Error(err))
+	}
+}
+
+func (p *clusterPresence) refresh(ctx context.Context) {
+	if err := p.store.RefreshPresenceNode(ctx, p.nodeID, p.ttl); err != nil {
+		p.stale = true
+		p.logger.Warn("Presence: failed to refresh node heartbeat", zap.Error(err))
+		return
+	}
+	if !p.stale {
+		return
+	}
+
+	// Other nodes may have reaped our members while the heartbeat was missing.
+	p.stale = false
+	for _, update := range p.owned {
+		if err := p.store.AddPresenceMember(ctx, update); err != nil {
+			p.logger.Warn("Presence: failed to restore member", zap.String("channel", update.Channel), zap.Error(err))
+			continue
+		}
+		p.publish(ctx, update)
+	}
+}
+
+// reap removes members left behind by nodes whose heartbeat expired.
+func (p *clusterPresence) reap(ctx context.Context) {
+	removed, err := p.store.ReapPresenceMembers(ctx)
+	if err != nil {
+		p.logger.Warn("Presence: failed to reap stale members", zap.Error(err))
+		return
+	}
+	for _, update := range removed {
+		update.Action = PresenceActionRemove
+		p.logger.Info("Presence: removed member of expired node",
+			zap.String("channel", update.Channel),
+			zap.String("node_id", update.NodeID),
+			zap.String("user_id", update.UserID))
+		p.publish(ctx, update)
+	}
+}
+
+func (p *clusterPresence) cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), presenceShutdownCleanupLimit)
+	defer cancel()
+
+	for key, update := range p.owned {
+		update.Action = PresenceActionRemove
+		if removed, err := p.store.RemovePresenceMember(ctx, update); err == nil && removed {
+			p.publish(ctx, update)
+		}
+		delete(p.owned, key)
+	}
+	if err := p.store.RemovePresenceNode(ctx, p.nodeID); err != nil {
+		p.logger.Warn("Presence: failed to remove node heartbeat", zap.Error(err))
+	}
+}
+
+func (p *clusterPresence) Wait() {
+	<-p.done
+}
@@ -0,0 +1,202 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func newRedisTestHub(t *testing.T, ctx context.Context, addr string) *Hub {
+	t.Helper()
+
+	broker := NewRedisBroker(zap.NewNop(), "test-app", addr, "", 0, false)
+	hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), nil, nil, broker, 10000, 4, DefaultPingPeriod, DefaultDeliveryConfig())
+	if hub.presence == nil {
+		t.Fatal("Expected Redis broker to enable cluster presence")
+	}
+	go hub.Run()
+	return hub
+}
+
+func subscribePresence(t *testing.T, hub *Hub, client *Client, channel, userID string) {
+	t.Helper()
+
+	auth := []byte(`{"channel_data": "{\"user_id\":\"` + userID + `\"}"}`)
+	ok := false
+	hub.getShard(channel).withSubscriptions(func(sm *SubscriptionManager) {
+		ok = sm.Subscribe(client, channel, auth)
+	})
+	if !ok {
+		t.Fatalf("Expected presence subscribe for %s to succeed", userID)
+	}
+}
+
+func waitForChannelUsers(t *testing.T, hub *Hub, channel string, want int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		users := hub.ChannelSnapshot(channel).UserIDs
+		if len(users) == want {
+			return users
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d users on %s, got %v", want, channel, users)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClusterPresenceReplicatesMembersAcrossHubs(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub1 := newRedisTestHub(t, ctx, mr.Addr())
+	hub2 := newRedisTestHub(t, ctx, mr.Addr())
+	time.Sleep(100 * time.Millisecond)
+
+	channel := "presence-room"
+	c1 := &Client{ID: "1.1", send: make(chan any, 10)}
+	subscribePresence(t, hub1, c1, channel, "A")
+	drainClientMessage(t, c1)
+
+	if users := waitForChannelUsers(t, hub2, channel, 1); users[0] != "A" {
+		t.Fatalf("Expected hub2 to see user A, got %v", users)
+	}
+
+	c2 := &Client{ID: "2.1", send: make(chan any, 10)}
+	subscribePresence(t, hub2, c2, channel, "B")
+
+	var succeeded map[string]any
+	if err := json.Unmarshal((<-c2.send).([]byte), &succeeded); err != nil {
+		t.Fatalf("Failed to parse subscription_succeeded: %v", err)
+	}
+	var payload struct {
+		Presence struct {
+			IDs []string `json:"ids"`
+		} `json:"presence"`
+	}
+	if err := json.Unmarshal([]byte(succeeded["data"].(string)), &payload); err != nil {
+		t.Fatalf("Failed to parse presence payload: %v", err)
+	}
+	if len(payload.Presence.IDs) != 2 {
+		t.Fatalf("Expected subscription_succeeded to list 2 members, got %v", payload.Presence.IDs)
+	}
+
+	waitForChannelUsers(t, hub1, channel, 2)
+
+	hub1.getShard(channel).withSubscriptions(func(sm *SubscriptionManager) {
+		sm.Unsubscribe(c1, channel)
+	})
+	waitForChannelUsers(t, hub2, channel, 1)
+}
+
+func TestRedisBrokerReapsMembersOfExpiredNodes(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	broker := NewRedisBroker(zap.NewNop(), "test-app", mr.Addr(), "", 0, false)
+	defer func() { _ = broker.Close() }()
+
+	ctx := context.Background()
+	alive := PresenceUpdate{Channel: "presence-room", NodeID: "alive", SocketID: "1.1", UserID: "A"}
+	dead := PresenceUpdate{Channel: "presence-room", NodeID: "dead", SocketID: "2.1", UserID: "B"}
+	for _, update := range []PresenceUpdate{alive, dead} {
+		if err := broker.AddPresenceMember(ctx, update); err != nil {
+			t.Fatalf("AddPresenceMember failed: %v", err)
+		}
+	}
+	if err := broker.RefreshPresenceNode(ctx, "alive", time.Minute); err != nil {
+		t.Fatalf("RefreshPresenceNode failed: %v", err)
+	}
+
+	removed, err := broker.ReapPresenceMembers(ctx)
+	if err != nil {
+		t.Fatalf("ReapPresenceMembers failed: %v", err)
+	}
+	if len(removed) != 1 || removed[0].NodeID != "dead" || removed[0].UserID != "B" {
+		t.Fatalf("Expected only the dead node member to be reaped, got %+v", removed)
+	}
+
+	members, err := broker.PresenceMembers(ctx)
+	if err != nil {
+		t.Fatalf("PresenceMembers failed: %v", err)
+	}
+	if len(members) != 1 || members[0].NodeID != "alive" {
+		t.Fatalf("Expected only the alive node member to remain, got %+v", members)
+	}
+
+	if nodes, _ := mr.ZMembers(broker.presenceExpiryKey()); len(nodes) != 1 || nodes[0] != "alive" {
+		t.Fatalf("Expected the reaped node to leave the expiry index, got %v", nodes)
+	}
+}
+
+func TestClusterPresenceResyncsAfterMissedUpdates(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub1 := newRedisTestHub(t, ctx, mr.Addr())
+	hub2 := newRedisTestHub(t, ctx, mr.Addr())
+	time.Sleep(100 * time.Millisecond)
+
+	// Subscribe without replicating, as if the update had been dropped.
+	channel := "presence-room"
+	c1 := &Client{ID: "1.1", send: make(chan any, 10)}
+	hub1.getShard(channel).withSubscriptions(func(sm *SubscriptionManager) {
+		replicator := sm.replicator
+		sm.replicator = nil
+		sm.Subscribe(c1, channel, []byte(`{"channel_data": "{\"user_id\":\"A\"}"}`))
+		sm.replicator = replicator
+	})
+	time.Sleep(50 * time.Millisecond)
+	if users := hub2.ChannelSnapshot(channel).UserIDs; len(users) != 0 {
+		t.Fatalf("Expected hub2 to miss user A before the resync, got %v", users)
+	}
+
+	hub1.presence.invalidate()
+	if users := waitForChannelUsers(t, hub2, channel, 1); users[0] != "A" {
+		t.Fatalf("Expected the resync to announce user A, got %v", users)
+	}
+
+	// A member that left while hub2 was disconnected disappears on reconnect.
+	broker := NewRedisBroker(zap.NewNop(), "test-app", mr.Addr(), "", 0, false)
+	defer func() { _ = broker.Close() }()
+	ghost := PresenceUpdate{Channel: channel, NodeID: "ghost", SocketID: "9.9", UserID: "G"}
+	if err := broker.RefreshPresenceNode(ctx, "ghost", time.Minute); err != nil {
+		t.Fatalf("RefreshPresenceNode failed: %v", err)
+	}
+	if err := broker.AddPresenceMember(ctx, ghost); err != nil {
+		t.Fatalf("AddPresenceMember failed: %v", err)
+	}
+	hub2.presence.invalidate()
+	waitForChannelUsers(t, hub2, channel, 2)
+
+	if _, err := broker.RemovePresenceMember(ctx, ghost); err != nil {
+		t.Fatalf("RemovePresenceMember failed: %v", err)
+	}
+	hub2.setBrokerState(BrokerReconnecting)
+	hub2.setBrokerState(BrokerConnected)
+	if users := waitForChannelUsers(t, hub2, channel, 1); users[0] != "A" {
+		t.Fatalf("Expected only user A after the reconnect resync, got %v", users)
+	}
+}
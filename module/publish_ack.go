@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const DefaultPublishAckTimeout = time.Second
+
+// PublishAck selects how long a publish waits before returning.
+type PublishAck int
+
+const (
+	// PublishAckNone returns once the broker accepted the message.
+	PublishAckNone PublishAck = iota
+	// PublishAckLocal waits until this node's hub queued the message on a shard.
+	PublishAckLocal
+	// PublishAckQuorum waits until a majority of live cluster nodes queued the message.
+	PublishAckQuorum
+)
+
+// PublishAckRequest travels with a broadcast so receiving hubs can confirm it.
+type PublishAckRequest struct {
+	ID     string `json:"id"`
+	NodeID string `json:"node_id"`
+	Quorum bool   `json:"quorum,omitempty"`
+}
+
+// NodeAck is one node's confirmation that a broadcast entered a shard queue.
+type NodeAck struct {
+	NodeID string        `json:"node_id"`
+	Status PublishStatus `json:"status"`
+}
+
+// AckBroker is implemented by brokers that can carry acknowledgements back to the publishing node.
+type AckBroker interface {
+	SubscribeAcks(ctx context.Context, ackID string) (<-chan NodeAck, error)
+	AckPublish(ctx context.Context, request PublishAckRequest, ack NodeAck) error
+}
+
+// publishAcked publishes msg and waits for the acknowledgements requested by options.
+// A quorum on a clustered broker that cannot carry node acks is refused before
+// publishing.
+func (h *Hub) publishAcked(msg *BroadcastMessage, options PublishOptions) PublishStatus {
+	timeout := options.AckTimeout
+	if timeout <= 0 {
+		timeout = DefaultPublishAckTimeout
+	}
+	ctx, cancel := context.WithTimeout(h.ctx, timeout)
+	defer cancel()
+
+	request := &PublishAckRequest{ID: newRandomID(), NodeID: h.nodeID}
+	local := make(chan PublishStatus, 1)
+	h.pendingAcks.Store(request.ID, local)
+	defer h.pendingAcks.Delete(request.ID)
+
+	needed := 1
+	var remote <-chan NodeAck
+	if options.Ack == PublishAckQuorum {
+		acks, ackOK := h.broker.(AckBroker)
+		queries, queryOK := h.clusterQueryBroker()
+		if !ackOK || !queryOK {
+			if h.supportsClusterFanout() {
+				return h.ackFailure(PublishAckUnsupported, PublishAckUnsupported)
+			}
+		} else {
+			nodes, err := queries.ClusterNodes(ctx)
+			if err != nil {
+				h.logger.Warn("Hub: failed to list cluster nodes for publish quorum", zap.Error(err))
+				return PublishBrokerFailed
+			}
+			if len(nodes) > 1 {
+				needed = len(nodes)/2 + 1
+				request.Quorum = true
+				remote, err = acks.SubscribeAcks(ctx, request.ID)
+				if err != nil {
+					h.logger.Warn("Hub: failed to subscribe to publish acks", zap.Error(err))
+					return PublishBrokerFailed
+				}
+			}
+		}
+	}
+	msg.Ack = request
+
+	if status := h.publishToBroker(msg); status != PublishOK {
+		return status
+	}
+
+	acked := 0
+	failure := PublishOK
+	for acked < needed && (local != nil || remote != nil) {
+		var status PublishStatus
+		select {
+		case status = <-local:
+			local = nil
+		case ack, ok := <-remote:
+			if !ok {
+				remote = nil
+				continue
+			}
+			if ack.NodeID == h.nodeID {
+				continue
+			}
+			status = ack.Status
+		case <-ctx.Done():
+			return h.ackFailure(failure, PublishAckTimeout)
+		}
+		if status == PublishOK {
+			acked++
+		} else if failure == PublishOK {
+			failure = status
+		}
+	}
+	if acked < needed {
+		return h.ackFailure(failure, PublishAckTimeout)
+	}
+	return PublishOK
+}
+
+func (h *Hub) ackFailure(failure, fallback PublishStatus) PublishStatus {
+	if failure == PublishOK {
+		failure = fallback
+	}
+	if h.metrics != nil {
+		h.metrics.PublishFailures.WithLabelValues(h.AppID, publishStatusMetricReason(failure)).Inc()
+	}
+	return failure
+}
+
+// acknowledgePublish reports whether a broadcast entered a shard queue on this node.
+// It runs on the hub loop, so replies to other nodes are sent asynchronously.
+func (h *Hub) acknowledgePublish(request *PublishAckRequest, status PublishStatus) {
+	if request.NodeID == h.nodeID {
+		if pending, ok := h.pendingAcks.Load(request.ID); ok {
+			select {
+			case pending.(chan PublishStatus) <- status:
+			default:
+			}
+		}
+		return
+	}
+	if !request.Quorum {
+		return
+	}
+	acks, ok := h.broker.(AckBroker)
+	if !ok {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(h.ctx, DefaultPublishAckTimeout)
+		defer cancel()
+		if err := acks.AckPublish(ctx, *request, NodeAck{NodeID: h.nodeID, Status: status}); err != nil {
+			h.logger.Warn("Hub: failed to acknowledge publish", zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func TestAckedPublishWaitsForClusterNodes(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub1 := newRedisTestHub(t, ctx, mr.Addr())
+	hub2 := newRedisTestHub(t, ctx, mr.Addr())
+	time.Sleep(100 * time.Millisecond)
+
+	remote := &Client{ID: "2.1", send: make(chan any, 10)}
+	hub2.getShard("public-room").withSubscriptions(func(sm *SubscriptionManager) {
+		sm.Subscribe(remote, "public-room", nil)
+	})
+	drainClientMessage(t, remote)
+
+	for _, ack := range []PublishAck{PublishAckLocal, PublishAckQuorum} {
+		status := hub1.PublishWithOptions("public-room", "update", `{}`, PublishOptions{Ack: ack, AckTimeout: time.Second})
+		if status != PublishOK {
+			t.Fatalf("ack mode %d status = %v, want PublishOK", ack, status)
+		}
+		select {
+		case <-remote.send:
+		case <-time.After(time.Second):
+			t.Fatalf("ack mode %d: expected broadcast on the other node", ack)
+		}
+	}
+
+	// Nodes that hold a heartbeat but never answer keep the majority out of reach.
+	for _, nodeID := range []string{"ghost-1", "ghost-2"} {
+		if err := hub1.broker.(PresenceBroker).RefreshPresenceNode(ctx, nodeID, time.Minute); err != nil {
+			t.Fatalf("RefreshPresenceNode failed: %v", err)
+		}
+	}
+	start := time.Now()
+	status := hub1.PublishWithOptions("public-room", "update", `{}`, PublishOptions{Ack: PublishAckQuorum, AckTimeout: 100 * time.Millisecond})
+	if status != PublishAckTimeout {
+		t.Fatalf("quorum without majority status = %v, want PublishAckTimeout", status)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("quorum publish waited %s, want about the ack timeout", elapsed)
+	}
+	if got := counterValue(t, hub1.metrics.PublishFailures.WithLabelValues("test-app", "ack_timeout")); got != 1 {
+		t.Fatalf("ack_timeout failures = %v, want 1", got)
+	}
+}
+
+func TestAckedPublishReportsFullShardQueue(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := newRedisTestHub(t, ctx, mr.Addr())
+	time.Sleep(100 * time.Millisecond)
+
+	shard := hub.getShard("public-room")
+	blocked := make(chan struct{})
+	go shard.withSubscriptions(func(*SubscriptionManager) { <-blocked })
+	defer close(blocked)
+	time.Sleep(20 * time.Millisecond)
+	for len(shard.broadcast) < cap(shard.broadcast) {
+		shard.broadcast <- &BroadcastMessage{Channel: "public-room"}
+	}
+
+	status := hub.PublishWithOptions("public-room", "update", `{}`, PublishOptions{Ack: PublishAckLocal, AckTimeout: time.Second})
+	if status != PublishShardQueueFull {
+		t.Fatalf("status = %v, want PublishShardQueueFull", status)
+	}
+	if status := hub.PublishWithOptions("public-room", "update", `{}`, PublishOptions{}); status != PublishOK {
+		t.Fatalf("unacked status = %v, want PublishOK", status)
+	}
+}
+
+func TestAckedPublishReportsUnsupportedQuorum(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mesh := newMeshTestBroker(t, "secret")
+	meshHub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), nil, nil, mesh, 10000, 4, DefaultPingPeriod, DefaultDeliveryConfig())
+	go meshHub.Run()
+	if status := meshHub.PublishWithOptions("public-room", "update", `{}`, PublishOptions{Ack: PublishAckQuorum, AckTimeout: time.Second}); status != PublishAckUnsupported {
+		t.Fatalf("mesh quorum status = %v, want PublishAckUnsupported", status)
+	}
+	if status := meshHub.PublishWithOptions("public-room", "update", `{}`, PublishOptions{Ack: PublishAckLocal, AckTimeout: time.Second}); status != PublishOK {
+		t.Fatalf("mesh local ack status = %v, want PublishOK", status)
+	}
+
+}
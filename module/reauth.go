@@ -0,0 +1,239 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/y-l-g/websocket/module/internal/protocol"
+	"go.uber.org/zap"
+)
+
+// subscriptionGrant is what a client presented when it subscribed to a
+// private or presence channel.
+type subscriptionGrant struct {
+	auth        string
+	channelData string
+}
+
+type grantedSubscription struct {
+	client  *Client
+	channel string
+	grant   subscriptionGrant
+}
+
+func (sm *SubscriptionManager) recordGrant(client *Client, channel string, grant subscriptionGrant) {
+	grants, ok := sm.grants[channel]
+	if !ok {
+		grants = make(map[*Client]subscriptionGrant)
+		sm.grants[channel] = grants
+	}
+	grants[client] = grant
+}
+
+func (sm *SubscriptionManager) dropGrant(client *Client, channel string) {
+	if grants, ok := sm.grants[channel]; ok {
+		delete(grants, client)
+		if len(grants) == 0 {
+			delete(sm.grants, channel)
+		}
+	}
+}
+
+func (sm *SubscriptionManager) grantedSubscriptions() []grantedSubscription {
+	var granted []grantedSubscription
+	for channel, grants := range sm.grants {
+		for client, grant := range grants {
+			granted = append(granted, grantedSubscription{client: client, channel: channel, grant: grant})
+		}
+	}
+	return granted
+}
+
+// revoke removes the client from the channel and tells it why with a 4009 error.
+func (sm *SubscriptionManager) revoke(client *Client, channel string) {
+	sm.Unsubscribe(client, channel)
+	errMsg, _ := json.Marshal(map[string]interface{}{
+		"event": protocol.EventError,
+		"data": map[string]interface{}{
+			"code":    protocol.ErrorSubscriptionDenied,
+			"message": "Subscription to " + channel + " revoked",
+		},
+	})
+	client.Send(errMsg)
+}
+
+// maxConcurrentReauthorizations bounds the connections a re-authorization
+// pass checks at once.
+const maxConcurrentReauthorizations = 16
+
+// runReauthorization re-checks every authorized subscription each interval.
+// A pass that outlasts the interval delays the next one instead of overlapping.
+func (h *Hub) runReauthorization() {
+	ticker := time.NewTicker(h.reauthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.reauthorizeSubscriptions(h.reauthInterval)
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// reauthorizeSubscriptions asks the auth provider again for every private and
+// presence subscription, with the credentials it was granted with, and revokes
+// the ones that are now denied. Signatures never expire, so only JWTs and
+// worker or auth_url decisions can change. Denials caused by an unavailable
+// backend keep the subscription.
+//
+// Connections are checked a few at a time and their start times are spread
+// over spread, so a pass does not hit the auth backend in one burst. A
+// connection's channels are checked together, which lets a batching provider
+// send them as one request.
+func (h *Hub) reauthorizeSubscriptions(spread time.Duration) int {
+	type connectionGrants struct {
+		shard *HubShard
+		subs  []grantedSubscription
+	}
+	var connections []*connectionGrants
+	for _, shard := range h.shards {
+		byClient := make(map[*Client]*connectionGrants)
+		shard.withSubscriptions(func(sm *SubscriptionManager) {
+			for _, sub := range sm.grantedSubscriptions() {
+				grants, ok := byClient[sub.client]
+				if !ok {
+					grants = &connectionGrants{shard: shard}
+					byClient[sub.client] = grants
+					connections = append(connections, grants)
+				}
+				grants.subs = append(grants.subs, sub)
+			}
+		})
+	}
+
+	var gap time.Duration
+	if len(connections) > 0 {
+		gap = spread / time.Duration(len(connections))
+	}
+	batched := h.batchesAuth()
+	slots := make(chan struct{}, maxConcurrentReauthorizations)
+	var revoked atomic.Int64
+	var wg sync.WaitGroup
+	for i, connection := range connections {
+		if i > 0 && gap > 0 {
+			select {
+			case <-time.After(gap):
+			case <-h.ctx.Done():
+			}
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-h.ctx.Done():
+		}
+		if h.ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			var checks sync.WaitGroup
+			for _, sub := range connection.subs {
+				check := func() {
+					if h.reauthorize(connection.shard, sub) {
+						revoked.Add(1)
+					}
+				}
+				if !batched {
+					check()
+					continue
+				}
+				checks.Add(1)
+				go func() {
+					defer checks.Done()
+					check()
+				}()
+			}
+			checks.Wait()
+		}()
+	}
+	wg.Wait()
+
+	count := int(revoked.Load())
+	if count > 0 {
+		if h.metrics != nil {
+			h.metrics.SubscriptionsRevoked.WithLabelValues("reauth").Add(float64(count))
+		}
+		h.logger.Info("Hub: revoked subscriptions on re-authorization", zap.Int("revoked", count))
+	}
+	return count
+}
+
+// reauthorize checks one subscription and reports whether it was revoked.
+func (h *Hub) reauthorize(shard *HubShard, sub grantedSubscription) bool {
+	if h.ctx.Err() != nil {
+		return false
+	}
+	result := h.Authorize(sub.client, sub.channel, sub.grant.auth, sub.grant.channelData)
+	if result.Allowed || result.Unavailable {
+		return false
+	}
+	revoked := false
+	shard.withSubscriptions(func(sm *SubscriptionManager) {
+		// The client may have left or re-subscribed with new credentials meanwhile.
+		if current, ok := sm.grants[sub.channel][sub.client]; !ok || current != sub.grant {
+			return
+		}
+		sm.revoke(sub.client, sub.channel)
+		revoked = true
+	})
+	return revoked
+}
+
+// ForceUnsubscribe removes the local connections of a user, or a single
+// socket, from a channel and reports how many were removed.
+func (h *Hub) ForceUnsubscribe(channel, userID, socketID string) int {
+	if channel == "" || (userID == "" && socketID == "") {
+		return 0
+	}
+
+	removed := 0
+	h.getShard(channel).withSubscriptions(func(sm *SubscriptionManager) {
+		var matched []*Client
+		for client := range sm.channels[channel] {
+			if (socketID != "" && client.ID == socketID) || (userID != "" && sm.connectionUserID(channel, client) == userID) {
+				matched = append(matched, client)
+			}
+		}
+		for _, client := range matched {
+			sm.revoke(client, channel)
+		}
+		removed = len(matched)
+	})
+
+	if removed > 0 && h.metrics != nil {
+		h.metrics.SubscriptionsRevoked.WithLabelValues("forced").Add(float64(removed))
+	}
+	return removed
+}
+
+// unsubscribeOnHubs force-unsubscribes on every cluster node and reports how
+// many connections were removed, and whether a node did not answer.
+func unsubscribeOnHubs(ctx context.Context, hubs []*Hub, channel, userID, socketID string) (int, bool) {
+	snapshots, partial := collectClusterSnapshots(ctx, hubs, ClusterQuery{
+		Kind:     ClusterUnsubscribe,
+		Channel:  channel,
+		UserID:   userID,
+		SocketID: socketID,
+	})
+	unsubscribed := 0
+	for _, snapshot := range snapshots {
+		unsubscribed += snapshot.Unsubscribed
+	}
+	return unsubscribed, partial
+}
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type revocableAuth struct {
+	MockAuthProvider
+	mu          sync.Mutex
+	denied      map[string]bool
+	unavailable bool
+	calls       int
+}
+
+func (a *revocableAuth) Authorize(client *Client, channel string, auth string, channelData string) AuthResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls++
+	if a.unavailable {
+		return AuthResult{Unavailable: true}
+	}
+	if a.denied[client.ID] {
+		return AuthResult{}
+	}
+	return AuthResult{Allowed: true}
+}
+
+func drainSent(client *Client) []string {
+	var messages []string
+	for {
+		select {
+		case msg := <-client.send:
+			if raw, ok := msg.([]byte); ok {
+				messages = append(messages, string(raw))
+			}
+		default:
+			return messages
+		}
+	}
+}
+
+func TestHubReauthorizationRevokesDeniedSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	auth := &revocableAuth{denied: map[string]bool{}}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	hub := NewHub("test-app", zap.NewNop(), ctx, metrics, auth, nil, &MockBroker{}, 100, 1, DefaultPingPeriod, DefaultDeliveryConfig())
+
+	alice := &Client{ID: "1.1", send: make(chan any, 8)}
+	bob := &Client{ID: "2.2", send: make(chan any, 8)}
+	hub.getShard("private-orders").withSubscriptions(func(sm *SubscriptionManager) {
+		for _, client := range []*Client{alice, bob} {
+			sm.Subscribe(client, "private-orders", nil)
+			sm.recordGrant(client, "private-orders", subscriptionGrant{})
+		}
+		sm.Subscribe(alice, "public-news", nil)
+	})
+	drainSent(alice)
+	drainSent(bob)
+
+	auth.unavailable = true
+	if revoked := hub.reauthorizeSubscriptions(0); revoked != 0 {
+		t.Fatalf("revoked = %d while the auth backend is unavailable, want 0", revoked)
+	}
+
+	auth.unavailable = false
+	auth.denied["1.1"] = true
+	auth.calls = 0
+	if revoked := hub.reauthorizeSubscriptions(0); revoked != 1 {
+		t.Fatalf("revoked = %d, want 1", revoked)
+	}
+	if auth.calls != 2 {
+		t.Fatalf("auth calls = %d, want only the two private subscriptions re-checked", auth.calls)
+	}
+
+	messages := drainSent(alice)
+	if len(messages) != 1 || !strings.Contains(messages[0], `"code":4009`) || !strings.Contains(messages[0], "private-orders") {
+		t.Fatalf("revoked client received %v, want a 4009 pusher:error", messages)
+	}
+	snapshot := hub.ChannelSnapshot("private-orders")
+	if snapshot.SubscriptionCount != 1 || hub.ChannelSnapshot("public-news").SubscriptionCount != 1 {
+		t.Fatalf("private-orders has %d subscribers after revocation, want 1", snapshot.SubscriptionCount)
+	}
+	if got := counterValue(t, metrics.SubscriptionsRevoked.WithLabelValues("reauth")); got != 1 {
+		t.Fatalf("revoked metric = %v, want 1", got)
+	}
+}
+
+func TestHubReauthorizationBatchesConnectionChannels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker := &batchWorker{}
+	auth := NewWorkerAuthProvider(zap.NewNop(), NewMetrics(prometheus.NewRegistry()), worker, "test-key", "/auth", 4096, 10, "secret")
+	auth.EnableBatching(AuthBatchConfig{Path: "/auth/batch", Window: 20 * time.Millisecond})
+	hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), auth, nil, &MockBroker{}, 100, 1, DefaultPingPeriod, DefaultDeliveryConfig())
+
+	client := &Client{ID: "1.1", send: make(chan any, 8), Headers: make(http.Header)}
+	hub.getShard("private-a").withSubscriptions(func(sm *SubscriptionManager) {
+		for _, channel := range []string{"private-a", "private-b", "private-forbidden"} {
+			sm.Subscribe(client, channel, nil)
+			sm.recordGrant(client, channel, subscriptionGrant{})
+		}
+	})
+	drainSent(client)
+
+	if revoked := hub.reauthorizeSubscriptions(0); revoked != 1 {
+		t.Fatalf("revoked = %d, want only private-forbidden", revoked)
+	}
+	if paths := worker.paths(); len(paths) != 1 || paths[0] != "/auth/batch" {
+		t.Fatalf("worker requests = %v, want the connection's channels in one batch", paths)
+	}
+}
+
+func TestHubReauthorizationSpreadsConnectionsOverThePass(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	auth := &revocableAuth{denied: map[string]bool{}}
+	hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), auth, nil, &MockBroker{}, 100, 1, DefaultPingPeriod, DefaultDeliveryConfig())
+
+	hub.getShard("private-orders").withSubscriptions(func(sm *SubscriptionManager) {
+		for _, id := range []string{"1.1", "2.2", "3.3"} {
+			client := &Client{ID: id, send: make(chan any, 8)}
+			sm.Subscribe(client, "private-orders", nil)
+			sm.recordGrant(client, "private-orders", subscriptionGrant{})
+		}
+	})
+
+	start := time.Now()
+	hub.reauthorizeSubscriptions(300 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("pass took %s, want the three connections spread over 300ms", elapsed)
+	}
+	if auth.calls != 3 {
+		t.Fatalf("auth calls = %d, want 3", auth.calls)
+	}
+}
+
+func TestPusherHTTPAPIForceUnsubscribes(t *testing.T) {
+	module, _, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+
+	alice := &Client{ID: "1.1", send: make(chan any, 8)}
+	aliceTab := &Client{ID: "1.2", send: make(chan any, 8)}
+	bob := &Client{ID: "2.1", send: make(chan any, 8)}
+	module.hub.getShard("presence-room").withSubscriptions(func(sm *SubscriptionManager) {
+		for _, member := range []struct {
+			client *Client
+			userID string
+		}{{alice, "alice"}, {aliceTab, "alice"}, {bob, "bob"}} {
+			channelData, _ := json.Marshal(map[string]string{"user_id": member.userID})
+			userData, _ := json.Marshal(PresenceAuthResponse{ChannelData: string(channelData)})
+			sm.Subscribe(member.client, "presence-room", userData)
+		}
+	})
+
+	rr := performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/channels/presence-room/unsubscribe", []byte(`{"user_id":"alice"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unsubscribe status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Unsubscribed int `json:"unsubscribed"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode unsubscribe response: %v", err)
+	}
+	if response.Unsubscribed != 2 {
+		t.Fatalf("unsubscribed = %d, want both of alice's connections", response.Unsubscribed)
+	}
+	snapshot := module.hub.ChannelSnapshot("presence-room")
+	if snapshot.SubscriptionCount != 1 || len(snapshot.UserIDs) != 1 || snapshot.UserIDs[0] != "bob" {
+		t.Fatalf("presence-room after unsubscribe = %+v", snapshot)
+	}
+
+	rr = performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/channels/presence-room/unsubscribe", []byte(`{"socket_id":"2.1"}`))
+	if rr.Code != http.StatusOK || module.hub.ChannelSnapshot("presence-room").SubscriptionCount != 0 {
+		t.Fatalf("socket unsubscribe status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+
+	rr = performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/channels/presence-room/unsubscribe", []byte(`{}`))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unsubscribe without a target status = %d, want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -3,23 +3,85 @@ package websocket
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
-	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
-const RedisChannelName = "frankenphp:cluster:broadcast"
+const redisHealthCheckInterval = 2 * time.Second
+
+const (
+	RedisChannelName     = "frankenphp:cluster:broadcast"
+	RedisPresenceKeyName = "frankenphp:cluster:presence"
+	RedisNodeKeyName     = "frankenphp:cluster:node"
+	RedisReplyChannel    = "frankenphp:cluster:reply"
+	RedisScheduleKeyName = "frankenphp:cluster:schedule"
+	RedisIdempotencyKey  = "frankenphp:cluster:idempotency"
+)
+
+// redisRemovePresenceScript drops one entry from its channel and its node's
+// index, returning the removed entry or an empty string.
+var redisRemovePresenceScript = redis.NewScript(`
+redis.call('SREM', KEYS[3], ARGV[3])
+local value = redis.call('HGET', KEYS[1], ARGV[1])
+if not value then
+	return ''
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+if redis.call('HLEN', KEYS[1]) == 0 then
+	redis.call('SREM', KEYS[2], ARGV[2])
+end
+return value
+`)
+
+// redisForgetPresenceNodeScript drops a node from the expiry index once its
+// member index is empty, unless it sent a heartbeat in the meantime.
+var redisForgetPresenceNodeScript = redis.NewScript(`
+local expiry = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if expiry and tonumber(expiry) <= tonumber(ARGV[2]) and redis.call('EXISTS', KEYS[2]) == 0 then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// redisClaimScheduleScript removes due publishes atomically, so each is delivered by one node.
+var redisClaimScheduleScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+local jobs = {}
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	local job = redis.call('HGET', KEYS[2], id)
+	redis.call('HDEL', KEYS[2], id)
+	if job then
+		table.insert(jobs, job)
+	end
+end
+return jobs
+`)
 
 type RedisBroker struct {
 	client      *redis.Client
+	shared      *sharedRedis
+	metrics     *Metrics
 	logger      *zap.Logger
 	appID       string
 	channelName string
 	scope       string
 	queueSize   int
+	envelope    string
+	compressAt  int
+	outbox      *publishOutbox
+	closed      chan struct{}
+	closeOnce   sync.Once
+	onState     func(BrokerState)
+	state       atomic.Value
 }
 
 func NewRedisBroker(logger *zap.Logger, appID, addr, password string, db int, useTLS bool, queueSize ...int) *RedisBroker {
@@ -43,17 +105,74 @@ func NewRedisBroker(logger *zap.Logger, appID, addr, password string, db int, us
 		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
 	}
 
-	rdb := redis.NewClient(opts)
+	// Brokers for the same server share one client and Pub/Sub connection.
+	shared := acquireSharedRedis(logger, opts)
 	channelName := redisChannelName(appID)
 
 	return &RedisBroker{
-		client:      rdb,
+		client:      shared.client,
+		shared:      shared,
 		logger:      logger,
 		appID:       appID,
 		channelName: channelName,
 		scope:       fmt.Sprintf("redis:%s:%d:%s", addr, db, channelName),
 		queueSize:   size,
+		closed:      make(chan struct{}),
+	}
+}
+
+// WithEnvelope selects the broker transport format. Binary envelopes deflate
+// payloads of at least compressThreshold bytes when it is positive.
+func (r *RedisBroker) WithEnvelope(format string, compressThreshold int) *RedisBroker {
+	r.envelope = format
+	r.compressAt = compressThreshold
+	return r
+}
+
+// WithOutbox buffers up to size publishes in memory while Redis is unavailable
+// instead of retrying in the caller. Buffered messages older than maxAge are dropped.
+func (r *RedisBroker) WithOutbox(size int, maxAge time.Duration, metrics *Metrics) *RedisBroker {
+	if size <= 0 {
+		return r
+	}
+	r.outbox = newPublishOutbox(r.appID, size, maxAge, metrics)
+	go r.flushOutbox()
+	return r
+}
+
+// outboxMaxAge is how long an outbox may resend a message, and so how long
+// subscribers remember its publish ID. Nodes are expected to share it.
+func (r *RedisBroker) outboxMaxAge() time.Duration {
+	if r.outbox != nil {
+		return r.outbox.maxAge
+	}
+	return DefaultOutboxMaxAge
+}
+
+// WithMetrics counts messages dropped when this app's subscriber falls behind.
+func (r *RedisBroker) WithMetrics(metrics *Metrics) *RedisBroker {
+	r.metrics = metrics
+	return r
+}
+
+func (r *RedisBroker) SetStateHandler(handler func(BrokerState)) {
+	r.onState = handler
+}
+
+func (r *RedisBroker) reportState(state BrokerState) {
+	if previous, _ := r.state.Swap(state).(BrokerState); previous == state {
+		return
+	}
+	if r.onState != nil {
+		r.onState(state)
+	}
+}
+
+func (r *RedisBroker) encode(msg *BroadcastMessage) ([]byte, error) {
+	if r.envelope == EnvelopeFormatBinary {
+		return EncodeBroadcastEnvelope(msg, r.compressAt)
 	}
+	return SerializeBroadcast(msg)
 }
 
 func redisChannelName(appID string) string {
@@ -67,7 +186,19 @@ func (r *RedisBroker) Publish(ctx context.Context, msg *BroadcastMessage) error
 		msg = &copy
 	}
 
-	data, err := SerializeBroadcast(msg)
+	// Presence, ack and cluster query messages answer to state that may be
+	// gone by the time the outbox flushes, so they are never buffered.
+	if r.outbox != nil && !msg.isControl() {
+		copy := *msg
+		copy.PublishID = newRandomID()
+		data, err := r.encode(&copy)
+		if err != nil {
+			return err
+		}
+		return r.publishOrQueue(ctx, data)
+	}
+
+	data, err := r.encode(msg)
 	if err != nil {
 		return err
 	}
@@ -96,97 +227,406 @@ func (r *RedisBroker) Publish(ctx context.Context, msg *BroadcastMessage) error
 	return fmt.Errorf("redis publish failed after 4 attempts: %w", lastErr)
 }
 
-func (r *RedisBroker) Subscribe(ctx context.Context) (<-chan *BroadcastMessage, error) {
-	out := make(chan *BroadcastMessage, r.queueSize)
+// publishOrQueue publishes directly only while Redis is connected and the
+// outbox is empty, so buffered messages are never overtaken by newer ones, and
+// bounds that attempt so a publish never stalls on dial timeouts and retries.
+// A failed attempt may still have reached Redis; data carries a publish ID so
+// subscribers drop the copy the outbox sends again.
+func (r *RedisBroker) publishOrQueue(ctx context.Context, data []byte) error {
+	if state, _ := r.state.Load().(BrokerState); state == BrokerConnected && r.outbox.empty() {
+		directCtx, cancel := context.WithTimeout(ctx, outboxDirectPublishTimeout)
+		err := r.client.Publish(directCtx, r.channelName, data).Err()
+		cancel()
+		if err == nil {
+			return nil
+		}
+		r.logger.Warn("Redis: publish failed, queueing in outbox", zap.Error(err))
+	}
+	return r.outbox.push(data)
+}
 
+func (r *RedisBroker) flushOutbox() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
-		defer close(out)
+		<-r.closed
+		cancel()
+	}()
+
+	ticker := time.NewTicker(outboxRetryInterval)
+	defer ticker.Stop()
 
-		attempt := 0
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-r.outbox.wake:
+		case <-ticker.C:
+		}
 
 		for {
-			// Check context before reconnecting
-			select {
-			case <-ctx.Done():
-				return
-			default:
+			data, ok := r.outbox.front()
+			if !ok {
+				break
+			}
+			if err := r.client.Publish(ctx, r.channelName, data).Err(); err != nil {
+				break
 			}
+			r.outbox.pop()
+		}
+		r.outbox.observe()
+	}
+}
 
-			pubsub := r.client.Subscribe(ctx, r.channelName)
-			if _, err := pubsub.Receive(ctx); err != nil {
-				_ = pubsub.Close()
+func (r *RedisBroker) Subscribe(ctx context.Context) (<-chan *BroadcastMessage, error) {
+	r.reportState(BrokerConnecting)
+	return r.shared.subscribe(ctx, r), nil
+}
 
-				sleepDuration := time.Duration(math.Pow(2, float64(attempt))) * time.Second
-				if sleepDuration > 30*time.Second {
-					sleepDuration = 30 * time.Second
-				}
+func (r *RedisBroker) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		err = r.shared.release()
+	})
+	return err
+}
 
-				r.logger.Error("Redis: connection failed, retrying...",
-					zap.Error(err),
-					zap.Duration("backoff", sleepDuration))
+func (r *RedisBroker) SupportsClusterFanout() bool {
+	return true
+}
 
-				timer := time.NewTimer(sleepDuration)
-				select {
-				case <-timer.C:
-				case <-ctx.Done():
-					timer.Stop()
-					return
-				}
-				attempt++
+func (r *RedisBroker) PublishScope() string {
+	return r.scope
+}
+
+func (r *RedisBroker) presenceChannelsKey() string {
+	return RedisPresenceKeyName + ":" + r.appID + ":channels"
+}
+
+func (r *RedisBroker) presenceKey(channel string) string {
+	return RedisPresenceKeyName + ":" + r.appID + ":channel:" + channel
+}
+
+func (r *RedisBroker) nodeKey(nodeID string) string {
+	return RedisNodeKeyName + ":" + r.appID + ":" + nodeID
+}
+
+// nodesKey is a sorted set of node IDs scored by heartbeat expiry (unix ms).
+func (r *RedisBroker) nodesKey() string {
+	return RedisPresenceKeyName + ":" + r.appID + ":nodes"
+}
+
+// presenceExpiryKey scores the nodes that hold presence members by heartbeat
+// expiry. Unlike nodesKey, it keeps expired nodes until they are reaped.
+func (r *RedisBroker) presenceExpiryKey() string {
+	return RedisPresenceKeyName + ":" + r.appID + ":expiry"
+}
+
+// presenceNodeKey indexes a node's presence entries as "socket|channel".
+func (r *RedisBroker) presenceNodeKey(nodeID string) string {
+	return RedisPresenceKeyName + ":" + r.appID + ":node:" + nodeID
+}
+
+func presenceNodeEntry(update PresenceUpdate) string {
+	return update.SocketID + "|" + update.Channel
+}
+
+func (r *RedisBroker) AddPresenceMember(ctx context.Context, update PresenceUpdate) error {
+	value, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.presenceKey(update.Channel), update.entryKey(), value)
+		pipe.SAdd(ctx, r.presenceChannelsKey(), update.Channel)
+		pipe.SAdd(ctx, r.presenceNodeKey(update.NodeID), presenceNodeEntry(update))
+		// A node that never sent a heartbeat counts as expired.
+		pipe.ZAddNX(ctx, r.presenceExpiryKey(), redis.Z{Score: float64(time.Now().UnixMilli()), Member: update.NodeID})
+		return nil
+	})
+	return err
+}
+
+func (r *RedisBroker) RemovePresenceMember(ctx context.Context, update PresenceUpdate) (bool, error) {
+	value, err := r.removePresenceEntry(ctx, update)
+	return value != "", err
+}
+
+func (r *RedisBroker) removePresenceEntry(ctx context.Context, update PresenceUpdate) (string, error) {
+	return redisRemovePresenceScript.Run(ctx, r.client,
+		[]string{r.presenceKey(update.Channel), r.presenceChannelsKey(), r.presenceNodeKey(update.NodeID)},
+		update.entryKey(), update.Channel, presenceNodeEntry(update),
+	).Text()
+}
+
+func (r *RedisBroker) PresenceMembers(ctx context.Context) ([]PresenceUpdate, error) {
+	channels, err := r.client.SMembers(ctx, r.presenceChannelsKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	members := []PresenceUpdate{}
+	for _, channel := range channels {
+		entries, err := r.client.HGetAll(ctx, r.presenceKey(channel)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for field, value := range entries {
+			var update PresenceUpdate
+			if err := json.Unmarshal([]byte(value), &update); err != nil {
+				r.logger.Warn("Redis: invalid presence entry", zap.String("channel", channel), zap.String("field", field), zap.Error(err))
+				continue
+			}
+			if nodeID, socketID, ok := strings.Cut(field, "|"); ok {
+				update.NodeID = nodeID
+				update.SocketID = socketID
+			}
+			update.Channel = channel
+			members = append(members, update)
+		}
+	}
+	return members, nil
+}
+
+func (r *RedisBroker) RefreshPresenceNode(ctx context.Context, nodeID string, ttl time.Duration) error {
+	now := time.Now()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, r.nodeKey(nodeID), now.Unix(), ttl)
+		pipe.ZAdd(ctx, r.nodesKey(), redis.Z{Score: float64(now.Add(ttl).UnixMilli()), Member: nodeID})
+		pipe.ZAdd(ctx, r.presenceExpiryKey(), redis.Z{Score: float64(now.Add(ttl).UnixMilli()), Member: nodeID})
+		return nil
+	})
+	return err
+}
+
+func (r *RedisBroker) RemovePresenceNode(ctx context.Context, nodeID string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.nodeKey(nodeID))
+		pipe.ZRem(ctx, r.nodesKey(), nodeID)
+		pipe.ZRem(ctx, r.presenceExpiryKey(), nodeID)
+		return nil
+	})
+	return err
+}
+
+// ReapPresenceMembers removes the members of nodes whose heartbeat expired,
+// reading only those nodes' entries from their member index.
+func (r *RedisBroker) ReapPresenceMembers(ctx context.Context) ([]PresenceUpdate, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	nodes, err := r.client.ZRangeByScore(ctx, r.presenceExpiryKey(), &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []PresenceUpdate{}
+	for _, nodeID := range nodes {
+		entries, err := r.client.SMembers(ctx, r.presenceNodeKey(nodeID)).Result()
+		if err != nil {
+			return removed, err
+		}
+		for _, entry := range entries {
+			socketID, channel, _ := strings.Cut(entry, "|")
+			update := PresenceUpdate{Channel: channel, NodeID: nodeID, SocketID: socketID}
+			value, err := r.removePresenceEntry(ctx, update)
+			if err != nil {
+				return removed, err
+			}
+			if value == "" {
 				continue
 			}
+			if err := json.Unmarshal([]byte(value), &update); err != nil {
+				r.logger.Warn("Redis: invalid presence entry", zap.String("channel", channel), zap.String("node_id", nodeID), zap.Error(err))
+			}
+			update.Channel, update.NodeID, update.SocketID = channel, nodeID, socketID
+			removed = append(removed, update)
+		}
+		if err := redisForgetPresenceNodeScript.Run(ctx, r.client,
+			[]string{r.presenceExpiryKey(), r.presenceNodeKey(nodeID)}, nodeID, now,
+		).Err(); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
 
-			// Connection established, reset attempts
-			attempt = 0
-			r.logger.Info("Redis: subscribed to broadcast channel", zap.String("channel", r.channelName))
+func (r *RedisBroker) replyChannel(queryID string) string {
+	return RedisReplyChannel + ":" + r.appID + ":" + queryID
+}
+
+// ClusterNodes lists nodes whose heartbeat has not expired, pruning the rest
+// from the node index, so its cost follows the node count, not the keyspace.
+func (r *RedisBroker) ClusterNodes(ctx context.Context) ([]string, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	var live *redis.StringSliceCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, r.nodesKey(), "-inf", "("+now)
+		live = pipe.ZRangeByScore(ctx, r.nodesKey(), &redis.ZRangeBy{Min: now, Max: "+inf"})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return live.Val(), nil
+}
+
+func (r *RedisBroker) QueryCluster(ctx context.Context, msg *BroadcastMessage, expected int) ([]ClusterSnapshot, error) {
+	pubsub := r.client.Subscribe(ctx, r.replyChannel(msg.Query.ID))
+	defer func() { _ = pubsub.Close() }()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return nil, err
+	}
+	// Never through the outbox: a query replayed after the caller got its
+	// answer would still terminate or unsubscribe connections.
+	data, err := r.encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.client.Publish(ctx, r.channelName, data).Err(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]ClusterSnapshot, 0, expected)
+	ch := pubsub.Channel()
+	for len(replies) < expected {
+		select {
+		case redisMsg, ok := <-ch:
+			if !ok {
+				return replies, nil
+			}
+			var reply ClusterSnapshot
+			if err := json.Unmarshal([]byte(redisMsg.Payload), &reply); err != nil {
+				r.logger.Error("Redis: invalid cluster query reply", zap.Error(err))
+				continue
+			}
+			replies = append(replies, reply)
+		case <-ctx.Done():
+			return replies, nil
+		}
+	}
+	return replies, nil
+}
+
+func (r *RedisBroker) ReplyClusterQuery(ctx context.Context, query ClusterQuery, reply ClusterSnapshot) error {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, r.replyChannel(query.ID), data).Err()
+}
+
+func (r *RedisBroker) SubscribeAcks(ctx context.Context, ackID string) (<-chan NodeAck, error) {
+	pubsub := r.client.Subscribe(ctx, r.replyChannel(ackID))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
 
-			ch := pubsub.Channel()
+	out := make(chan NodeAck, 16)
+	go func() {
+		defer close(out)
+		defer func() { _ = pubsub.Close() }()
 
-			// Inner loop: Read messages
-		readLoop:
-			for {
+		ch := pubsub.Channel()
+		for {
+			select {
+			case redisMsg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var ack NodeAck
+				if err := json.Unmarshal([]byte(redisMsg.Payload), &ack); err != nil {
+					r.logger.Error("Redis: invalid publish ack", zap.Error(err))
+					continue
+				}
 				select {
-				case redisMsg, ok := <-ch:
-					if !ok {
-						break readLoop
-					}
-
-					msg, err := DeserializeBroadcast([]byte(redisMsg.Payload))
-					if err != nil {
-						r.logger.Error("Redis: deserialize error", zap.Error(err))
-						continue
-					}
-					if msg.AppID != "" && msg.AppID != r.appID {
-						continue
-					}
-					if msg.AppID == "" {
-						msg.AppID = r.appID
-					}
-
-					select {
-					case out <- msg:
-					case <-ctx.Done():
-						_ = pubsub.Close()
-						return
-					}
+				case out <- ack:
 				case <-ctx.Done():
-					_ = pubsub.Close()
 					return
 				}
+			case <-ctx.Done():
+				return
 			}
-
-			_ = pubsub.Close()
-			r.logger.Warn("Redis: connection lost, reconnecting")
 		}
 	}()
-
 	return out, nil
 }
 
-func (r *RedisBroker) Close() error {
-	return r.client.Close()
+func (r *RedisBroker) AckPublish(ctx context.Context, request PublishAckRequest, ack NodeAck) error {
+	data, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, r.replyChannel(request.ID), data).Err()
 }
 
-func (r *RedisBroker) PublishScope() string {
-	return r.scope
+func (r *RedisBroker) scheduleKey() string {
+	return RedisScheduleKeyName + ":" + r.appID
+}
+
+func (r *RedisBroker) scheduleJobsKey() string {
+	return RedisScheduleKeyName + ":" + r.appID + ":jobs"
+}
+
+func (r *RedisBroker) SchedulePublish(ctx context.Context, job ScheduledPublish) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.scheduleJobsKey(), job.ID, data)
+		pipe.ZAdd(ctx, r.scheduleKey(), redis.Z{Score: float64(job.DeliverAt), Member: job.ID})
+		return nil
+	})
+	return err
+}
+
+func (r *RedisBroker) CancelScheduledPublish(ctx context.Context, id string) (bool, error) {
+	var removed *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, r.scheduleKey(), id)
+		pipe.HDel(ctx, r.scheduleJobsKey(), id)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed.Val() > 0, nil
+}
+
+func (r *RedisBroker) ClaimDuePublishes(ctx context.Context, now time.Time, limit int) ([]ScheduledPublish, error) {
+	values, err := redisClaimScheduleScript.Run(ctx, r.client,
+		[]string{r.scheduleKey(), r.scheduleJobsKey()},
+		now.UnixMilli(), limit,
+	).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]ScheduledPublish, 0, len(values))
+	for _, value := range values {
+		var job ScheduledPublish
+		if err := json.Unmarshal([]byte(value), &job); err != nil {
+			r.logger.Warn("Redis: invalid scheduled publish", zap.Error(err))
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (r *RedisBroker) PendingScheduledPublishes(ctx context.Context) (int64, error) {
+	return r.client.ZCard(ctx, r.scheduleKey()).Result()
+}
+
+func (r *RedisBroker) idempotencyKey(key string) string {
+	return RedisIdempotencyKey + ":" + r.appID + ":" + key
+}
+
+func (r *RedisBroker) ClaimIdempotencyKey(ctx context.Context, key string, window time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.idempotencyKey(key), 1, window).Result()
+}
+
+func (r *RedisBroker) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.idempotencyKey(key)).Err()
 }
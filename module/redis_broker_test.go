@@ -3,10 +3,15 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -161,3 +166,420 @@ func TestRedisBroker_IsolatesApps(t *testing.T) {
 	case <-time.After(100 * time.Millisecond):
 	}
 }
+
+func TestRedisBroker_SharesOneSubscriptionAcrossApps(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := zap.NewNop()
+	const apps = 10
+	brokers := make([]*RedisBroker, apps)
+	subs := make([]<-chan *BroadcastMessage, apps)
+	for i := range brokers {
+		brokers[i] = NewRedisBroker(logger, fmt.Sprintf("app-%d", i), mr.Addr(), "", 0, false)
+		defer func() { _ = brokers[i].Close() }()
+		if brokers[i].client != brokers[0].client {
+			t.Fatal("Expected brokers for the same server to share one Redis client")
+		}
+		if subs[i], err = brokers[i].Subscribe(ctx); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for i, broker := range brokers {
+		if err := broker.Publish(ctx, &BroadcastMessage{Channel: "room", Event: broker.appID, Data: json.RawMessage(`{}`)}); err != nil {
+			t.Fatalf("Publish for app %d failed: %v", i, err)
+		}
+	}
+	for i, sub := range subs {
+		select {
+		case msg := <-sub:
+			if msg.AppID != brokers[i].appID || msg.Event != brokers[i].appID {
+				t.Fatalf("app %d received %+v", i, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for app %d message", i)
+		}
+		select {
+		case msg := <-sub:
+			t.Fatalf("app %d received another app's message: %+v", i, msg)
+		default:
+		}
+	}
+
+	if conns := mr.CurrentConnectionCount(); conns >= apps {
+		t.Fatalf("Expected apps to share Redis connections, got %d connections for %d apps", conns, apps)
+	}
+	if got := len(mr.PubSubChannels("")); got != apps {
+		t.Fatalf("Expected one Pub/Sub channel per app, got %d", got)
+	}
+
+	cancel()
+	for _, broker := range brokers[1:] {
+		_ = broker.Close()
+	}
+	if err := brokers[0].client.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("Expected shared client to stay open while a broker uses it: %v", err)
+	}
+}
+
+func TestRedisBroker_JoiningLiveSharedClientReportsConnected(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := NewRedisBroker(zap.NewNop(), "first-app", mr.Addr(), "", 0, false)
+	defer func() { _ = first.Close() }()
+	if _, err := first.Subscribe(ctx); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for state, _ := first.state.Load().(BrokerState); state != BrokerConnected; state, _ = first.state.Load().(BrokerState) {
+		if time.Now().After(deadline) {
+			t.Fatalf("first broker state = %v, want connected", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A second app, or a hub rebuilt by a reload, joins the already live connection.
+	second := NewRedisBroker(zap.NewNop(), "second-app", mr.Addr(), "", 0, false)
+	defer func() { _ = second.Close() }()
+	if second.client != first.client {
+		t.Fatal("Expected brokers for the same server to share one Redis client")
+	}
+	if _, err := second.Subscribe(ctx); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if state, _ := second.state.Load().(BrokerState); state != BrokerConnected {
+		t.Fatalf("second broker state = %v, want connected", state)
+	}
+
+	// The health monitor promotes a broker out of any state once Redis answers.
+	second.reportState(BrokerConnecting)
+	deadline = time.Now().Add(2 * redisHealthCheckInterval)
+	for state, _ := second.state.Load().(BrokerState); state != BrokerConnected; state, _ = second.state.Load().(BrokerState) {
+		if time.Now().After(deadline) {
+			t.Fatalf("second broker state = %v, want the monitor to promote it", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisBroker_SlowAppDropsOnlyPlainBroadcasts(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	slow := NewRedisBroker(zap.NewNop(), "slow-app", mr.Addr(), "", 0, false, 1).WithMetrics(metrics)
+	defer func() { _ = slow.Close() }()
+	fast := NewRedisBroker(zap.NewNop(), "fast-app", mr.Addr(), "", 0, false, 1)
+	defer func() { _ = fast.Close() }()
+	slowSub, err := slow.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	fastSub, err := fast.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	const broadcasts = 5
+	const updates = 20
+	for i := 0; i < broadcasts; i++ {
+		if err := slow.Publish(ctx, &BroadcastMessage{Channel: "room", Event: "broadcast", Data: json.RawMessage(`{}`)}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	for i := 0; i < updates; i++ {
+		if err := slow.Publish(ctx, &BroadcastMessage{Channel: "presence-room", Presence: &PresenceUpdate{Action: "add", Channel: "presence-room", UserID: strconv.Itoa(i)}}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+
+	// Presence traffic queued behind the stalled app must not hold up another app.
+	if err := fast.Publish(ctx, &BroadcastMessage{Channel: "presence-room", Presence: &PresenceUpdate{Action: "add", Channel: "presence-room", UserID: "fast"}}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	select {
+	case msg := <-fastSub:
+		if msg.Presence == nil || msg.Presence.UserID != "fast" {
+			t.Fatalf("fast app received %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the other app to receive its update while one app is stalled")
+	}
+
+	received, presence := 0, 0
+	for presence < updates {
+		select {
+		case msg := <-slowSub:
+			if msg.Presence != nil {
+				if msg.Presence.UserID != strconv.Itoa(presence) {
+					t.Fatalf("presence update %d arrived as %q", presence, msg.Presence.UserID)
+				}
+				presence++
+			} else if presence > 0 {
+				t.Fatal("broadcast arrived after later presence updates")
+			} else {
+				received++
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout after %d of %d presence updates", presence, updates)
+		}
+	}
+	dropped := counterValue(t, metrics.BrokerDropped.WithLabelValues("slow-app", "subscriber_queue_full"))
+	if dropped == 0 || received+int(dropped) != broadcasts {
+		t.Fatalf("received %d broadcasts and dropped %v, want %d in total with some dropped", received, dropped, broadcasts)
+	}
+}
+
+func TestRedisBroker_BinaryEnvelope(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	logger := zap.NewNop()
+	publisher := NewRedisBroker(logger, "test-app", mr.Addr(), "", 0, false).WithEnvelope(EnvelopeFormatBinary, 16)
+	defer func() { _ = publisher.Close() }()
+	subscriber := NewRedisBroker(logger, "test-app", mr.Addr(), "", 0, false)
+	defer func() { _ = subscriber.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subCh, err := subscriber.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	data := json.RawMessage(`{"text":"` + strings.Repeat("x", 256) + `"}`)
+	if err := publisher.Publish(ctx, &BroadcastMessage{Channel: "public", Event: "big", Data: data}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	select {
+	case received := <-subCh:
+		if received.AppID != "test-app" || string(received.Data) != string(data) {
+			t.Fatalf("Unexpected message from binary envelope: %+v", received)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for message")
+	}
+}
+
+func TestRedisBroker_OutboxFlushesInOrderAfterOutage(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	logger := zap.NewNop()
+	metrics := NewMetrics(prometheus.NewRegistry())
+	publisher := NewRedisBroker(logger, "test-app", mr.Addr(), "", 0, false)
+	defer func() { _ = publisher.Close() }()
+	// The flusher is started by hand so the subscriber is ready before the outbox drains.
+	publisher.outbox = newPublishOutbox("test-app", 10, time.Minute, metrics)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mr.Close()
+	start := time.Now()
+	for _, event := range []string{"first", "second", "third"} {
+		if err := publisher.Publish(ctx, &BroadcastMessage{Channel: "outbox", Event: event, Data: json.RawMessage(`{}`)}); err != nil {
+			t.Fatalf("Publish during outage returned error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Publish during outage blocked for %s", elapsed)
+	}
+	if depth := gaugeValue(t, metrics.OutboxDepth.WithLabelValues("test-app")); depth != 3 {
+		t.Fatalf("outbox depth = %v, want 3", depth)
+	}
+
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("Failed to restart miniredis: %v", err)
+	}
+	subscriber := NewRedisBroker(logger, "test-app", mr.Addr(), "", 0, false)
+	defer func() { _ = subscriber.Close() }()
+	subCh, err := subscriber.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	go publisher.flushOutbox()
+
+	for _, want := range []string{"first", "second", "third"} {
+		select {
+		case msg := <-subCh:
+			if msg.Event != want {
+				t.Fatalf("flushed event = %q, want %q", msg.Event, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for flushed event %q", want)
+		}
+	}
+}
+
+func TestRedisBroker_OutboxBoundsDirectPublishDuringOutage(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	publisher := NewRedisBroker(zap.NewNop(), "test-app", mr.Addr(), "", 0, false)
+	defer func() { _ = publisher.Close() }()
+	publisher.outbox = newPublishOutbox("test-app", 10, time.Minute, nil)
+	mr.Close()
+
+	// Redis went away but the monitor has not noticed yet.
+	publisher.reportState(BrokerConnected)
+	start := time.Now()
+	if err := publisher.Publish(context.Background(), &BroadcastMessage{Channel: "outbox", Event: "first", Data: json.RawMessage(`{}`)}); err != nil {
+		t.Fatalf("Publish returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("direct publish blocked for %s before queueing", elapsed)
+	}
+
+	// Once something is queued, or Redis is known to be down, publishes queue at once.
+	for _, state := range []BrokerState{BrokerConnected, BrokerReconnecting} {
+		publisher.reportState(state)
+		start = time.Now()
+		if err := publisher.Publish(context.Background(), &BroadcastMessage{Channel: "outbox", Event: "next", Data: json.RawMessage(`{}`)}); err != nil {
+			t.Fatalf("Publish returned error: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Fatalf("publish in state %v took %s, want it queued immediately", state, elapsed)
+		}
+	}
+}
+
+func TestRedisBroker_OutboxResendIsDeliveredOnce(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	subscriber := NewRedisBroker(zap.NewNop(), "test-app", mr.Addr(), "", 0, false).WithMetrics(metrics)
+	defer func() { _ = subscriber.Close() }()
+	subCh, err := subscriber.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	publisher := NewRedisBroker(zap.NewNop(), "test-app", mr.Addr(), "", 0, false).WithEnvelope(EnvelopeFormatBinary, 0).WithOutbox(10, time.Minute, nil)
+	defer func() { _ = publisher.Close() }()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := publisher.Publish(ctx, &BroadcastMessage{Channel: "outbox", Event: "first", Data: json.RawMessage(`{}`)}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	var first *BroadcastMessage
+	select {
+	case first = <-subCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the published message")
+	}
+	if first.PublishID == "" {
+		t.Fatal("Expected a publish through the outbox path to carry a publish ID")
+	}
+
+	// The direct attempt reached Redis but reported an error, so the outbox sends it again.
+	data, err := publisher.encode(first)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := publisher.client.Publish(ctx, publisher.channelName, data).Err(); err != nil {
+		t.Fatalf("resend failed: %v", err)
+	}
+	if err := publisher.Publish(ctx, &BroadcastMessage{Channel: "outbox", Event: "second", Data: json.RawMessage(`{}`)}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	select {
+	case msg := <-subCh:
+		if msg.Event != "second" {
+			t.Fatalf("received %q, want the resent message dropped", msg.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the second message")
+	}
+	if got := counterValue(t, metrics.BrokerDropped.WithLabelValues("test-app", "duplicate_publish")); got != 1 {
+		t.Fatalf("duplicate_publish drops = %v, want 1", got)
+	}
+}
+
+func TestRedisBroker_ControlMessagesBypassOutbox(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	publisher := NewRedisBroker(zap.NewNop(), "test-app", mr.Addr(), "", 0, false)
+	defer func() { _ = publisher.Close() }()
+	publisher.outbox = newPublishOutbox("test-app", 10, time.Minute, nil)
+	mr.Close()
+	publisher.reportState(BrokerReconnecting)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	for _, msg := range []*BroadcastMessage{
+		{Channel: "presence-room", Event: "pusher_internal:member_added", Data: json.RawMessage(`{}`), Presence: &PresenceUpdate{Action: PresenceActionAdd}},
+		{Channel: "public-room", Event: "update", Data: json.RawMessage(`{}`), Ack: &PublishAckRequest{ID: "ack"}},
+	} {
+		if err := publisher.Publish(ctx, msg); err == nil {
+			t.Fatalf("Expected %s to fail while Redis is down instead of being buffered", msg.Event)
+		}
+	}
+	if !publisher.outbox.empty() {
+		t.Fatal("Expected presence and ack messages to stay out of the outbox")
+	}
+}
+
+func TestPublishOutboxDropsExpiredAndRejectsWhenFull(t *testing.T) {
+	metrics := NewMetrics(prometheus.NewRegistry())
+	outbox := newPublishOutbox("test-app", 1, 20*time.Millisecond, metrics)
+
+	if err := outbox.push([]byte("a")); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if err := outbox.push([]byte("b")); !errors.Is(err, ErrBrokerQueueFull) {
+		t.Fatalf("push to full outbox error = %v, want ErrBrokerQueueFull", err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := outbox.front(); ok {
+		t.Fatal("Expected expired entry to be dropped")
+	}
+	if got := counterValue(t, metrics.BrokerDropped.WithLabelValues("test-app", "outbox_expired")); got != 1 {
+		t.Fatalf("outbox_expired drops = %v, want 1", got)
+	}
+	if got := counterValue(t, metrics.BrokerDropped.WithLabelValues("test-app", "outbox_full")); got != 1 {
+		t.Fatalf("outbox_full drops = %v, want 1", got)
+	}
+}
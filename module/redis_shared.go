@@ -0,0 +1,390 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// sharedRedis is the Redis client and Pub/Sub connection used by every
+// RedisBroker in the process that points at the same server and database.
+// Apps are multiplexed by subscribing one connection to each app's channel.
+type sharedRedis struct {
+	key    string
+	client *redis.Client
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	refs   int
+	routes map[string]map[*redisRoute]struct{}
+	pubsub *redis.PubSub
+	live   bool
+	cancel context.CancelFunc
+}
+
+// redisRoute forwards one app's messages to its hub. The shared receive loop
+// only appends to pending, so a slow app never stalls the other apps.
+type redisRoute struct {
+	broker *RedisBroker
+	out    chan *BroadcastMessage
+	done   chan struct{}
+	wake   chan struct{}
+	seen   *recentPublishIDs
+
+	mu      sync.Mutex
+	pending []*BroadcastMessage
+}
+
+// push queues msg for the hub. Plain broadcasts are dropped once the app is a
+// full queue behind; presence, ack and cluster query traffic always queues.
+func (r *redisRoute) push(msg *BroadcastMessage) bool {
+	r.mu.Lock()
+	if !msg.isControl() && len(r.pending) >= r.broker.queueSize {
+		r.mu.Unlock()
+		return false
+	}
+	r.pending = append(r.pending, msg)
+	r.mu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// forward hands pending messages to the hub in order until the route is done.
+func (r *redisRoute) forward() {
+	defer close(r.out)
+	for {
+		r.mu.Lock()
+		var msg *BroadcastMessage
+		if len(r.pending) > 0 {
+			msg = r.pending[0]
+			r.pending[0] = nil
+			r.pending = r.pending[1:]
+		}
+		r.mu.Unlock()
+
+		if msg == nil {
+			select {
+			case <-r.wake:
+				continue
+			case <-r.done:
+				return
+			}
+		}
+		select {
+		case r.out <- msg:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+var sharedRedisClients = struct {
+	sync.Mutex
+	clients map[string]*sharedRedis
+}{clients: make(map[string]*sharedRedis)}
+
+func sharedRedisKey(opts *redis.Options) string {
+	return fmt.Sprintf("%s|%d|%t|%s", opts.Addr, opts.DB, opts.TLSConfig != nil, opts.Password)
+}
+
+func acquireSharedRedis(logger *zap.Logger, opts *redis.Options) *sharedRedis {
+	key := sharedRedisKey(opts)
+
+	sharedRedisClients.Lock()
+	defer sharedRedisClients.Unlock()
+
+	shared, ok := sharedRedisClients.clients[key]
+	if !ok {
+		shared = &sharedRedis{
+			key:    key,
+			client: redis.NewClient(opts),
+			logger: logger,
+			routes: make(map[string]map[*redisRoute]struct{}),
+		}
+		sharedRedisClients.clients[key] = shared
+	}
+	shared.refs++
+	return shared
+}
+
+// release drops one broker's reference and closes the client after the last one.
+func (s *sharedRedis) release() error {
+	sharedRedisClients.Lock()
+	s.refs--
+	last := s.refs == 0
+	if last {
+		delete(sharedRedisClients.clients, s.key)
+	}
+	sharedRedisClients.Unlock()
+
+	if !last {
+		return nil
+	}
+	s.mu.Lock()
+	s.stopLocked()
+	s.mu.Unlock()
+	return s.client.Close()
+}
+
+// subscribe routes messages published on broker's channel to the returned
+// channel until ctx is done.
+func (s *sharedRedis) subscribe(ctx context.Context, broker *RedisBroker) <-chan *BroadcastMessage {
+	route := &redisRoute{
+		broker: broker,
+		out:    make(chan *BroadcastMessage),
+		done:   make(chan struct{}),
+		wake:   make(chan struct{}, 1),
+		seen:   newRecentPublishIDs(broker.outboxMaxAge(), recentPublishIDLimit),
+	}
+	go route.forward()
+
+	s.mu.Lock()
+	routes, subscribed := s.routes[broker.channelName]
+	if !subscribed {
+		routes = make(map[*redisRoute]struct{})
+		s.routes[broker.channelName] = routes
+	}
+	routes[route] = struct{}{}
+	pubsub, live := s.pubsub, s.live
+	if s.cancel == nil {
+		runCtx, cancel := context.WithCancel(context.Background())
+		s.cancel = cancel
+		go s.run(runCtx)
+		go s.monitor(runCtx)
+	}
+	s.mu.Unlock()
+
+	if pubsub != nil && !subscribed {
+		if err := pubsub.Subscribe(ctx, broker.channelName); err != nil {
+			s.logger.Warn("Redis: subscribe failed, retrying on reconnect", zap.String("channel", broker.channelName), zap.Error(err))
+			live = false
+		}
+	}
+	if live {
+		// The shared connection is already up and will not report it again.
+		s.setBrokerState(broker, BrokerConnected)
+	}
+
+	go func() {
+		<-ctx.Done()
+		close(route.done)
+		s.unsubscribe(route)
+	}()
+	return route.out
+}
+
+func (s *sharedRedis) unsubscribe(route *redisRoute) {
+	channel := route.broker.channelName
+
+	s.mu.Lock()
+	routes := s.routes[channel]
+	delete(routes, route)
+
+	var pubsub *redis.PubSub
+	if len(routes) == 0 {
+		delete(s.routes, channel)
+		pubsub = s.pubsub
+	}
+	if len(s.routes) == 0 {
+		s.stopLocked()
+		pubsub = nil
+	}
+	s.mu.Unlock()
+
+	if pubsub != nil {
+		_ = pubsub.Unsubscribe(context.Background(), channel)
+	}
+}
+
+func (s *sharedRedis) stopLocked() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.cancel = nil
+	s.live = false
+	if s.pubsub != nil {
+		_ = s.pubsub.Close()
+		s.pubsub = nil
+	}
+}
+
+func (s *sharedRedis) channelsLocked() []string {
+	channels := make([]string, 0, len(s.routes))
+	for channel := range s.routes {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (s *sharedRedis) brokers() []*RedisBroker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	brokers := []*RedisBroker{}
+	for _, routes := range s.routes {
+		for route := range routes {
+			brokers = append(brokers, route.broker)
+		}
+	}
+	return brokers
+}
+
+func (s *sharedRedis) reportState(state BrokerState) {
+	s.mu.Lock()
+	s.live = state == BrokerConnected
+	s.mu.Unlock()
+	for _, broker := range s.brokers() {
+		s.setBrokerState(broker, state)
+	}
+}
+
+func (s *sharedRedis) setBrokerState(broker *RedisBroker, state BrokerState) {
+	broker.reportState(state)
+	if state == BrokerConnected && broker.outbox != nil {
+		broker.outbox.notify()
+	}
+}
+
+// monitor pings Redis because the Pub/Sub channel reconnects silently.
+func (s *sharedRedis) monitor(ctx context.Context) {
+	ticker := time.NewTicker(redisHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, redisHealthCheckInterval)
+			err := s.client.Ping(pingCtx).Err()
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			for _, broker := range s.brokers() {
+				if err != nil {
+					broker.reportState(BrokerReconnecting)
+				} else if state, _ := broker.state.Load().(BrokerState); state != BrokerConnected {
+					s.setBrokerState(broker, BrokerConnected)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *sharedRedis) run(ctx context.Context) {
+	attempt := 0
+	for ctx.Err() == nil {
+		pubsub := s.client.Subscribe(ctx)
+		s.mu.Lock()
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+			_ = pubsub.Close()
+			return
+		}
+		s.pubsub = pubsub
+		channels := s.channelsLocked()
+		s.mu.Unlock()
+
+		err := pubsub.Subscribe(ctx, channels...)
+		if err == nil {
+			_, err = pubsub.Receive(ctx)
+		}
+		if err != nil {
+			s.mu.Lock()
+			if s.pubsub == pubsub {
+				s.pubsub = nil
+			}
+			s.mu.Unlock()
+			_ = pubsub.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			s.reportState(BrokerReconnecting)
+
+			sleepDuration := time.Duration(math.Pow(2, float64(attempt))) * time.Second
+			if sleepDuration > 30*time.Second {
+				sleepDuration = 30 * time.Second
+			}
+
+			s.logger.Error("Redis: connection failed, retrying...",
+				zap.Error(err),
+				zap.Duration("backoff", sleepDuration))
+
+			timer := time.NewTimer(sleepDuration)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			attempt++
+			continue
+		}
+
+		attempt = 0
+		s.reportState(BrokerConnected)
+		s.logger.Info("Redis: subscribed to broadcast channels", zap.Strings("channels", channels))
+
+		for redisMsg := range pubsub.Channel() {
+			s.route(redisMsg)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		s.reportState(BrokerReconnecting)
+		s.logger.Warn("Redis: connection lost, reconnecting")
+	}
+}
+
+func (s *sharedRedis) route(redisMsg *redis.Message) {
+	msg, err := DeserializeBroadcast([]byte(redisMsg.Payload))
+	if err != nil {
+		s.logger.Error("Redis: deserialize error", zap.Error(err))
+		return
+	}
+
+	s.mu.Lock()
+	routes := make([]*redisRoute, 0, len(s.routes[redisMsg.Channel]))
+	for route := range s.routes[redisMsg.Channel] {
+		routes = append(routes, route)
+	}
+	s.mu.Unlock()
+
+	for _, route := range routes {
+		appID := route.broker.appID
+		if msg.AppID != "" && msg.AppID != appID {
+			continue
+		}
+		delivered := msg
+		if len(routes) > 1 {
+			copy := *msg
+			delivered = &copy
+		}
+		if delivered.AppID == "" {
+			delivered.AppID = appID
+		}
+		if delivered.PublishID != "" && route.seen.repeated(delivered.PublishID, time.Now()) {
+			if metrics := route.broker.metrics; metrics != nil {
+				metrics.BrokerDropped.WithLabelValues(appID, "duplicate_publish").Inc()
+			}
+			continue
+		}
+
+		if !route.push(delivered) {
+			if metrics := route.broker.metrics; metrics != nil {
+				metrics.BrokerDropped.WithLabelValues(appID, "subscriber_queue_full").Inc()
+			}
+		}
+	}
+}
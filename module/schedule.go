@@ -0,0 +1,249 @@
+package websocket
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	MaxScheduleDelay        = 7 * 24 * time.Hour
+	DefaultMaxScheduled     = 10000
+	schedulePollInterval    = 200 * time.Millisecond
+	scheduleBatchSize       = 100
+	scheduleStorageTimeout  = time.Second
+	scheduledCancelKeyLimit = 200
+)
+
+// validCancelKey keeps cancel keys to characters that are safe in a URL path
+// segment, so every key can be cancelled through the HTTP API.
+var validCancelKey = regexp.MustCompile(`^[a-zA-Z0-9_\-=@,.;:]+$`)
+
+func validScheduleCancelKey(cancelKey string) bool {
+	return cancelKey == "" || (len(cancelKey) <= scheduledCancelKeyLimit && validCancelKey.MatchString(cancelKey))
+}
+
+// ScheduledPublish is a publish held back until DeliverAt.
+type ScheduledPublish struct {
+	ID             string        `json:"id"`
+	Channel        string        `json:"channel"`
+	Event          string        `json:"event"`
+	Data           string        `json:"data"`
+	ExceptSocketID string        `json:"socket_id,omitempty"`
+	Target         PublishTarget `json:"target,omitempty"`
+	DeliverAt      int64         `json:"deliver_at_ms"`
+}
+
+// ScheduleBroker is implemented by brokers that keep scheduled publishes in
+// shared storage, so any node can deliver them and any node can cancel them.
+type ScheduleBroker interface {
+	SchedulePublish(ctx context.Context, job ScheduledPublish) error
+	CancelScheduledPublish(ctx context.Context, id string) (bool, error)
+	ClaimDuePublishes(ctx context.Context, now time.Time, limit int) ([]ScheduledPublish, error)
+	PendingScheduledPublishes(ctx context.Context) (int64, error)
+}
+
+// publishScheduler delays publishes with in-process timers, or through the
+// broker's shared storage when it implements ScheduleBroker.
+type publishScheduler struct {
+	hub   *Hub
+	store ScheduleBroker
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newPublishScheduler(hub *Hub) *publishScheduler {
+	store, _ := hub.broker.(ScheduleBroker)
+	return &publishScheduler{
+		hub:    hub,
+		store:  store,
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+// scheduledPublishID keeps caller-chosen cancellation keys apart from generated IDs.
+func scheduledPublishID(cancelKey string) string {
+	if cancelKey != "" {
+		return "key:" + cancelKey
+	}
+	return "id:" + newRandomID()
+}
+
+// schedule stores job, replacing any pending publish with the same ID.
+func (s *publishScheduler) schedule(job ScheduledPublish) PublishStatus {
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(s.hub.ctx, scheduleStorageTimeout)
+		defer cancel()
+		if err := s.store.SchedulePublish(ctx, job); err != nil {
+			s.hub.logger.Error("Hub: failed to store scheduled publish", zap.Error(err))
+			return PublishBrokerFailed
+		}
+		s.count("scheduled")
+		return PublishOK
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if previous, ok := s.timers[job.ID]; ok {
+		previous.Stop()
+	} else if len(s.timers) >= DefaultMaxScheduled {
+		return PublishBrokerQueueFull
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(time.UnixMilli(job.DeliverAt)), func() {
+		s.mu.Lock()
+		current := s.timers[job.ID] == timer
+		if current {
+			delete(s.timers, job.ID)
+		}
+		s.observeLocked()
+		s.mu.Unlock()
+		if current {
+			s.deliver(job)
+		}
+	})
+	s.timers[job.ID] = timer
+	s.observeLocked()
+	s.count("scheduled")
+	return PublishOK
+}
+
+func (s *publishScheduler) cancel(id string) bool {
+	cancelled := false
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(s.hub.ctx, scheduleStorageTimeout)
+		defer cancel()
+		var err error
+		cancelled, err = s.store.CancelScheduledPublish(ctx, id)
+		if err != nil {
+			s.hub.logger.Error("Hub: failed to cancel scheduled publish", zap.Error(err))
+			return false
+		}
+	} else {
+		s.mu.Lock()
+		if timer, ok := s.timers[id]; ok {
+			cancelled = timer.Stop()
+			delete(s.timers, id)
+		}
+		s.observeLocked()
+		s.mu.Unlock()
+	}
+
+	if cancelled {
+		s.count("cancelled")
+	}
+	return cancelled
+}
+
+func (s *publishScheduler) deliver(job ScheduledPublish) {
+	status := s.hub.publishWithOptions(job.Channel, job.Event, job.Data, PublishOptions{ExceptSocketID: job.ExceptSocketID, Target: job.Target})
+	if status != PublishOK {
+		s.hub.logger.Warn("Hub: scheduled publish failed",
+			zap.String("channel", job.Channel),
+			zap.String("reason", publishStatusReason(status)))
+		s.count("failed")
+		return
+	}
+	s.count("delivered")
+}
+
+// run polls shared storage for due publishes, or stops local timers on
+// shutdown. Publishes still pending in local timers are lost and counted.
+func (s *publishScheduler) run(ctx context.Context) {
+	if s.store == nil {
+		<-ctx.Done()
+		dropped := 0
+		s.mu.Lock()
+		for id, timer := range s.timers {
+			if timer.Stop() {
+				dropped++
+			}
+			delete(s.timers, id)
+		}
+		s.observeLocked()
+		s.mu.Unlock()
+		if dropped > 0 {
+			if s.hub.metrics != nil {
+				s.hub.metrics.ScheduledEvents.WithLabelValues(s.hub.AppID, "dropped").Add(float64(dropped))
+			}
+			s.hub.logger.Warn("Hub: dropped scheduled publishes on shutdown", zap.String("app_id", s.hub.AppID), zap.Int("dropped", dropped))
+		}
+		return
+	}
+
+	ticker := time.NewTicker(schedulePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.poll(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *publishScheduler) poll(ctx context.Context) {
+	for {
+		jobs, err := s.store.ClaimDuePublishes(ctx, time.Now(), scheduleBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.hub.logger.Warn("Hub: failed to claim scheduled publishes", zap.Error(err))
+			}
+			return
+		}
+		for _, job := range jobs {
+			s.deliver(job)
+		}
+		if len(jobs) < scheduleBatchSize {
+			break
+		}
+	}
+
+	if s.hub.metrics != nil {
+		if pending, err := s.store.PendingScheduledPublishes(ctx); err == nil {
+			s.hub.metrics.ScheduledPending.WithLabelValues(s.hub.AppID).Set(float64(pending))
+		}
+	}
+}
+
+func (s *publishScheduler) observeLocked() {
+	if s.hub.metrics != nil {
+		s.hub.metrics.ScheduledPending.WithLabelValues(s.hub.AppID).Set(float64(len(s.timers)))
+	}
+}
+
+func (s *publishScheduler) count(result string) {
+	if s.hub.metrics != nil {
+		s.hub.metrics.ScheduledEvents.WithLabelValues(s.hub.AppID, result).Inc()
+	}
+}
+
+// CancelScheduledPublish cancels the pending publish scheduled with cancelKey.
+func (h *Hub) CancelScheduledPublish(cancelKey string) bool {
+	if cancelKey == "" {
+		return false
+	}
+	return h.scheduler.cancel(scheduledPublishID(cancelKey))
+}
+
+func cancelScheduledOnActiveHubs(hubs []*Hub, cancelKey string) bool {
+	cancelled := false
+	cancelledScopes := make(map[string]struct{}, len(hubs))
+	for _, hub := range hubs {
+		scope := hub.publishScope()
+		if _, seen := cancelledScopes[scope]; seen {
+			continue
+		}
+		cancelledScopes[scope] = struct{}{}
+		if hub.CancelScheduledPublish(cancelKey) {
+			cancelled = true
+		}
+	}
+	return cancelled
+}
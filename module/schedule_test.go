@@ -0,0 +1,199 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func TestScheduledPublishDeliversAfterDelay(t *testing.T) {
+	module, broker, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+	hub := module.hub
+
+	status := hub.PublishWithOptions("public-room", "later", `{}`, PublishOptions{DeliverAt: time.Now().Add(100 * time.Millisecond)})
+	if status != PublishOK {
+		t.Fatalf("schedule status = %v, want PublishOK", status)
+	}
+	select {
+	case <-broker.published:
+		t.Fatal("scheduled publish was delivered immediately")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := gaugeValue(t, hub.metrics.ScheduledPending.WithLabelValues("test-app")); got != 1 {
+		t.Fatalf("scheduled_pending = %v, want 1", got)
+	}
+
+	msg := readPublishedMessage(t, broker.published)
+	if msg.Channel != "public-room" || msg.Event != "later" {
+		t.Fatalf("delivered %s/%s, want public-room/later", msg.Channel, msg.Event)
+	}
+	if got := counterValue(t, hub.metrics.ScheduledEvents.WithLabelValues("test-app", "delivered")); got != 1 {
+		t.Fatalf("delivered scheduled events = %v, want 1", got)
+	}
+	if got := gaugeValue(t, hub.metrics.ScheduledPending.WithLabelValues("test-app")); got != 0 {
+		t.Fatalf("scheduled_pending after delivery = %v, want 0", got)
+	}
+
+	tooLate := time.Now().Add(MaxScheduleDelay + time.Hour)
+	if status := hub.PublishWithOptions("public-room", "later", `{}`, PublishOptions{DeliverAt: tooLate}); status != PublishInvalidSchedule {
+		t.Fatalf("schedule beyond max delay status = %v, want PublishInvalidSchedule", status)
+	}
+}
+
+func TestScheduledPublishCancelKey(t *testing.T) {
+	module, broker, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+	hub := module.hub
+
+	options := PublishOptions{DeliverAt: time.Now().Add(100 * time.Millisecond), CancelKey: "reminder-1"}
+	if status := hub.PublishWithOptions("public-room", "first", `{}`, options); status != PublishOK {
+		t.Fatalf("schedule status = %v, want PublishOK", status)
+	}
+	// Scheduling again with the same key replaces the pending publish.
+	if status := hub.PublishWithOptions("public-room", "second", `{}`, options); status != PublishOK {
+		t.Fatalf("reschedule status = %v, want PublishOK", status)
+	}
+	msg := readPublishedMessage(t, broker.published)
+	if msg.Event != "second" {
+		t.Fatalf("delivered event %q, want second", msg.Event)
+	}
+
+	options.DeliverAt = time.Now().Add(100 * time.Millisecond)
+	if status := hub.PublishWithOptions("public-room", "third", `{}`, options); status != PublishOK {
+		t.Fatalf("schedule status = %v, want PublishOK", status)
+	}
+	if !hub.CancelScheduledPublish("reminder-1") {
+		t.Fatal("expected pending publish to be cancelled")
+	}
+	if hub.CancelScheduledPublish("reminder-1") {
+		t.Fatal("expected second cancel to report nothing pending")
+	}
+	select {
+	case msg := <-broker.published:
+		t.Fatalf("cancelled publish delivered event %q", msg.Event)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// A key with a slash could never be cancelled through the HTTP path.
+	options.CancelKey = "orders/1"
+	if status := hub.PublishWithOptions("public-room", "fourth", `{}`, options); status != PublishInvalidSchedule {
+		t.Fatalf("schedule with a slash in the cancel key status = %v, want PublishInvalidSchedule", status)
+	}
+}
+
+func TestScheduledPublishCountsLocalTimersDroppedOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	hub := NewHub("test-app", zap.NewNop(), ctx, NewMetrics(prometheus.NewRegistry()), nil, nil, &MockBroker{}, 100, 1, DefaultPingPeriod, DefaultDeliveryConfig())
+	go hub.Run()
+
+	if status := hub.PublishWithOptions("public-room", "tomorrow", `{}`, PublishOptions{DeliverAt: time.Now().Add(24 * time.Hour)}); status != PublishOK {
+		t.Fatalf("schedule status = %v, want PublishOK", status)
+	}
+	cancel()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for counterValue(t, hub.metrics.ScheduledEvents.WithLabelValues("test-app", "dropped")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the pending local timer to be counted as dropped on shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScheduledPublishRedisDeliversOnce(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub1 := newRedisTestHub(t, ctx, mr.Addr())
+	hub2 := newRedisTestHub(t, ctx, mr.Addr())
+	time.Sleep(100 * time.Millisecond)
+
+	client := &Client{ID: "1.1", send: make(chan any, 10)}
+	hub1.getShard("public-room").withSubscriptions(func(sm *SubscriptionManager) {
+		sm.Subscribe(client, "public-room", nil)
+	})
+	drainClientMessage(t, client)
+
+	deliverAt := time.Now().Add(300 * time.Millisecond)
+	if status := hub1.PublishWithOptions("public-room", "later", `{}`, PublishOptions{DeliverAt: deliverAt}); status != PublishOK {
+		t.Fatalf("schedule status = %v, want PublishOK", status)
+	}
+	if status := hub1.PublishWithOptions("public-room", "cancelled", `{}`, PublishOptions{DeliverAt: deliverAt, CancelKey: "job-7"}); status != PublishOK {
+		t.Fatalf("schedule status = %v, want PublishOK", status)
+	}
+	// Any node can cancel a publish scheduled by another.
+	if !hub2.CancelScheduledPublish("job-7") {
+		t.Fatal("expected publish scheduled on hub1 to be cancelled through hub2")
+	}
+
+	select {
+	case <-client.send:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected scheduled broadcast")
+	}
+	select {
+	case msg := <-client.send:
+		t.Fatalf("scheduled publish delivered more than once: %v", msg)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	delivered := counterValue(t, hub1.metrics.ScheduledEvents.WithLabelValues("test-app", "delivered")) +
+		counterValue(t, hub2.metrics.ScheduledEvents.WithLabelValues("test-app", "delivered"))
+	if delivered != 1 {
+		t.Fatalf("delivered scheduled events = %v, want 1", delivered)
+	}
+}
+
+func TestPusherHTTPAPIScheduledEvent(t *testing.T) {
+	module, broker, cleanup := newHTTPAPITestModule(t)
+	defer cleanup()
+
+	deliverAt := strconv.FormatFloat(float64(time.Now().Add(time.Minute).UnixMilli())/1000, 'f', 3, 64)
+	body := []byte(`{"name":"later","data":"{}","channel":"public-room","deliver_at":` + deliverAt + `,"cancel_key":"job-1"}`)
+	rr := performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/events", body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("schedule status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+
+	body = []byte(`{"name":"later","data":"{}","channels":["a","b"],"deliver_at":` + deliverAt + `,"cancel_key":"job-1"}`)
+	rr = performSignedPusherRequest(t, module, http.MethodPost, "/apps/test-app/events", body)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("cancel_key with several channels status = %d, want 422", rr.Code)
+	}
+
+	for _, want := range []bool{true, false} {
+		rr = performSignedPusherRequest(t, module, http.MethodDelete, "/apps/test-app/scheduled_events/job-1", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("cancel status = %d, body = %s", rr.Code, rr.Body.String())
+		}
+		var response struct {
+			Cancelled bool `json:"cancelled"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to decode cancel response: %v", err)
+		}
+		if response.Cancelled != want {
+			t.Fatalf("cancelled = %v, want %v", response.Cancelled, want)
+		}
+	}
+
+	select {
+	case msg := <-broker.published:
+		t.Fatalf("scheduled event published immediately: %v", msg)
+	default:
+	}
+}
@@ -17,6 +17,7 @@ type HubShard struct {
 	clientMsg   chan *ClientMessageWrapper
 	cleanup     chan *Client
 	manage      chan subscriptionOperation
+	relay       func(*ClientMessageWrapper)
 	logger      *zap.Logger
 	metrics     *Metrics
 	ctx         context.Context
@@ -47,14 +48,16 @@ func NewHubShard(id int, appID string, logger *zap.Logger, ctx context.Context,
 	}
 }
 
-func (s *HubShard) enqueueBroadcast(msg *BroadcastMessage) {
+func (s *HubShard) enqueueBroadcast(msg *BroadcastMessage) PublishStatus {
 	select {
 	case s.broadcast <- msg:
+		return PublishOK
 	default:
 		if s.metrics != nil {
 			s.metrics.BrokerDropped.WithLabelValues(s.appID, "shard_queue_full").Inc()
 		}
 		trySendPublishResult(msg, PublishShardQueueFull)
+		return PublishShardQueueFull
 	}
 }
 
@@ -85,6 +88,22 @@ func (s *HubShard) EnqueueClientMessage(msg *ClientMessageWrapper) bool {
 	}
 }
 
+// queueSaturation reports the fill ratio of the fullest shard queue.
+func (s *HubShard) queueSaturation() float64 {
+	saturation := 0.0
+	for _, depth := range [][2]int{
+		{len(s.broadcast), cap(s.broadcast)},
+		{len(s.subscribe), cap(s.subscribe)},
+		{len(s.unsubscribe), cap(s.unsubscribe)},
+		{len(s.clientMsg), cap(s.clientMsg)},
+	} {
+		if depth[1] > 0 {
+			saturation = max(saturation, float64(depth[0])/float64(depth[1]))
+		}
+	}
+	return saturation
+}
+
 func (s *HubShard) withSubscriptions(fn func(*SubscriptionManager)) bool {
 	done := make(chan struct{})
 	op := subscriptionOperation{fn: fn, done: done}
@@ -139,7 +158,9 @@ func (s *HubShard) Run() {
 
 		case sub := <-s.subscribe:
 			sub.Client.AddShard(s.id)
-			s.subs.Subscribe(sub.Client, sub.Channel, sub.AuthData)
+			if s.subs.Subscribe(sub.Client, sub.Channel, sub.AuthData) && sub.Grant != nil {
+				s.subs.recordGrant(sub.Client, sub.Channel, *sub.Grant)
+			}
 
 		case sub := <-s.unsubscribe:
 			s.subs.Unsubscribe(sub.Client, sub.Channel)
@@ -155,10 +176,16 @@ func (s *HubShard) Run() {
 				s.metrics.HubToShardDelay.Observe(delay.Seconds())
 				msg.ShardBroadcastAt = now
 			}
+			if msg.Presence != nil {
+				s.subs.ApplyPresenceUpdate(*msg.Presence)
+				continue
+			}
 			s.subs.BroadcastToChannel(msg)
 
 		case cMsg := <-s.clientMsg:
-			s.subs.BroadcastToOthers(cMsg.Client, cMsg.Channel, cMsg.Event, cMsg.Data)
+			if s.subs.BroadcastToOthers(cMsg.Client, cMsg.Channel, cMsg.Event, cMsg.Data) && s.relay != nil {
+				s.relay(cMsg)
+			}
 
 		case <-s.ctx.Done():
 			return
@@ -22,13 +22,25 @@ type channelEventPayload struct {
 }
 
 type SubscriptionManager struct {
-	channels     map[string]map[*Client]bool
-	clients      map[*Client]map[string]bool
-	presence     map[string]map[string]Member
-	clientToUser map[string]map[*Client]string
-	logger       *zap.Logger
-	webhook      *WebhookManager
-	metrics      *Metrics
+	channels       map[string]map[*Client]bool
+	clients        map[*Client]map[string]bool
+	presence       map[string]map[string]Member
+	clientToUser   map[string]map[*Client]string
+	remotePresence map[string]map[string]map[string]Member
+	grants         map[string]map[*Client]subscriptionGrant
+	limits         subscriptionLimits
+	policy         *ChannelPolicy
+	replicator     presenceReplicator
+	logger         *zap.Logger
+	webhook        *WebhookManager
+	metrics        *Metrics
+}
+
+// subscriptionLimits caps subscriptions per connection and distinct members
+// per presence channel; zero means unlimited.
+type subscriptionLimits struct {
+	perConnection   int
+	presenceMembers int
 }
 
 type ChannelSnapshot struct {
@@ -38,15 +50,22 @@ type ChannelSnapshot struct {
 	Presence          bool
 }
 
+// occupied reports whether the channel has local subscribers or presence members on other nodes.
+func (s ChannelSnapshot) occupied() bool {
+	return s.SubscriptionCount > 0 || len(s.UserIDs) > 0
+}
+
 func NewSubscriptionManager(logger *zap.Logger, metrics *Metrics, webhook *WebhookManager) *SubscriptionManager {
 	return &SubscriptionManager{
-		channels:     make(map[string]map[*Client]bool),
-		clients:      make(map[*Client]map[string]bool),
-		presence:     make(map[string]map[string]Member),
-		clientToUser: make(map[string]map[*Client]string),
-		logger:       logger,
-		webhook:      webhook,
-		metrics:      metrics,
+		channels:       make(map[string]map[*Client]bool),
+		clients:        make(map[*Client]map[string]bool),
+		presence:       make(map[string]map[string]Member),
+		clientToUser:   make(map[string]map[*Client]string),
+		remotePresence: make(map[string]map[string]map[string]Member),
+		grants:         make(map[string]map[*Client]subscriptionGrant),
+		logger:         logger,
+		webhook:        webhook,
+		metrics:        metrics,
 	}
 }
 
@@ -73,27 +92,87 @@ func (sm *SubscriptionManager) BroadcastToChannel(msg *BroadcastMessage) {
 	pm, err := websocket.NewPreparedMessage(websocket.TextMessage, payload)
 	if err != nil {
 		sm.logger.Error("PreparedMessage error", zap.Error(err))
-		sm.fanout(clients, payload, msg.ExceptSocketID)
+		sm.fanout(msg.Channel, clients, payload, msg.ExceptSocketID, msg.Target)
 		return
 	}
 
-	sm.fanout(clients, pm, msg.ExceptSocketID)
+	sm.fanout(msg.Channel, clients, pm, msg.ExceptSocketID, msg.Target)
 }
 
-func (sm *SubscriptionManager) fanout(clients map[*Client]bool, payload any, exceptSocketID string) {
+func (sm *SubscriptionManager) fanout(channel string, clients map[*Client]bool, payload any, exceptSocketID string, target *PublishTarget) {
+	filter := newFanoutFilter(target)
 	for client := range clients {
 		if exceptSocketID != "" && client.ID == exceptSocketID {
 			continue
 		}
+		if filter != nil && !filter.allows(client, sm.connectionUserID(channel, client)) {
+			continue
+		}
 		client.Send(payload)
 	}
 }
 
+// connectionUserID is the user a client joined a presence channel as, or the
+// user it signed in as.
+func (sm *SubscriptionManager) connectionUserID(channel string, client *Client) string {
+	if userID, ok := sm.clientToUser[channel][client]; ok {
+		return userID
+	}
+	return client.UserID()
+}
+
+type fanoutFilter struct {
+	exceptSockets map[string]struct{}
+	exceptUsers   map[string]struct{}
+	onlyUsers     map[string]struct{}
+}
+
+func newFanoutFilter(target *PublishTarget) *fanoutFilter {
+	if target == nil || target.empty() {
+		return nil
+	}
+	return &fanoutFilter{
+		exceptSockets: stringSet(target.ExceptSocketIDs),
+		exceptUsers:   stringSet(target.ExceptUserIDs),
+		onlyUsers:     stringSet(target.UserIDs),
+	}
+}
+
+func (f *fanoutFilter) allows(client *Client, userID string) bool {
+	if _, excluded := f.exceptSockets[client.ID]; excluded {
+		return false
+	}
+	if userID != "" {
+		if _, excluded := f.exceptUsers[userID]; excluded {
+			return false
+		}
+	}
+	if f.onlyUsers != nil {
+		_, listed := f.onlyUsers[userID]
+		return listed && userID != ""
+	}
+	return true
+}
+
+func stringSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
+
 func (sm *SubscriptionManager) Subscribe(client *Client, channel string, userData json.RawMessage) bool {
 	if strings.HasPrefix(channel, protocol.ChannelPrefixPresence) {
 		return sm.handlePresenceSubscribe(client, channel, userData)
 	}
 
+	if !sm.admit(client, channel) {
+		return false
+	}
 	sm.addSubscription(client, channel)
 	msg, _ := json.Marshal(channelEventPayload{
 		Event:   protocol.EventSubscriptionSucceeded,
@@ -104,6 +183,56 @@ func (sm *SubscriptionManager) Subscribe(client *Client, channel string, userDat
 	return true
 }
 
+// admit reserves a subscription slot for a new subscription of the client, or
+// rejects it with a 4305 error when the channel policy's subscriber cap is
+// reached and a 4303 error when the connection is at its limit.
+func (sm *SubscriptionManager) admit(client *Client, channel string) bool {
+	if _, subscribed := sm.channels[channel][client]; subscribed {
+		return true
+	}
+	if limit := sm.policy.MaxSubscribers(channel); limit > 0 && len(sm.channels[channel]) >= limit {
+		if sm.metrics != nil {
+			sm.metrics.PolicyRejections.WithLabelValues("max_subscribers").Inc()
+		}
+		sendPusherError(client, protocol.ErrorChannelFull, "Subscriber limit reached for "+channel)
+		return false
+	}
+	if client.reserveSubscription(sm.limits.perConnection) {
+		return true
+	}
+	sm.rejectLimit(client, "subscriptions", protocol.ErrorSubscriptionLimit, "Subscription limit reached for "+channel)
+	return false
+}
+
+func (sm *SubscriptionManager) rejectLimit(client *Client, limit string, code int, message string) {
+	if sm.metrics != nil {
+		sm.metrics.LimitRejections.WithLabelValues(limit).Inc()
+	}
+	sendPusherError(client, code, message)
+}
+
+func sendPusherError(client *Client, code int, message string) {
+	errMsg, _ := json.Marshal(map[string]interface{}{
+		"event": protocol.EventError,
+		"data": map[string]interface{}{
+			"code":    code,
+			"message": message,
+		},
+	})
+	client.Send(errMsg)
+}
+
+// presenceMemberCount counts distinct users on a presence channel across nodes.
+func (sm *SubscriptionManager) presenceMemberCount(channel string) int {
+	count := len(sm.presence[channel])
+	for userID := range sm.remotePresence[channel] {
+		if _, local := sm.presence[channel][userID]; !local {
+			count++
+		}
+	}
+	return count
+}
+
 func (sm *SubscriptionManager) addSubscription(client *Client, channel string) bool {
 	isNewChannel := false
 	if _, ok := sm.channels[channel]; !ok {
@@ -128,12 +257,14 @@ func (sm *SubscriptionManager) addSubscription(client *Client, channel string) b
 	return alreadySubscribed
 }
 
-func (sm *SubscriptionManager) BroadcastToOthers(sender *Client, channel, event string, data json.RawMessage) {
+// BroadcastToOthers delivers a client event to the sender's local peers and
+// reports whether the sender was allowed to emit it on the channel.
+func (sm *SubscriptionManager) BroadcastToOthers(sender *Client, channel, event string, data json.RawMessage) bool {
 	if !strings.HasPrefix(channel, protocol.ChannelPrefixPrivate) && !strings.HasPrefix(channel, protocol.ChannelPrefixPresence) {
-		return
+		return false
 	}
 	if chans, ok := sm.clients[sender]; !ok || !chans[channel] {
-		return
+		return false
 	}
 
 	payload, err := json.Marshal(channelEventPayload{
@@ -142,12 +273,12 @@ func (sm *SubscriptionManager) BroadcastToOthers(sender *Client, channel, event
 		Data:    string(data),
 	})
 	if err != nil {
-		return
+		return false
 	}
 
 	pm, err := websocket.NewPreparedMessage(websocket.TextMessage, payload)
 	if err != nil {
-		return
+		return false
 	}
 
 	clients := sm.channels[channel]
@@ -157,6 +288,7 @@ func (sm *SubscriptionManager) BroadcastToOthers(sender *Client, channel, event
 		}
 		client.Send(pm)
 	}
+	return true
 }
 
 type PresenceAuthResponse struct {
@@ -199,6 +331,13 @@ func (sm *SubscriptionManager) handlePresenceSubscribe(client *Client, channel s
 	}
 
 	member := Member{UserID: userID, UserInfo: userInfo}
+	if limit := sm.limits.presenceMembers; limit > 0 && !sm.memberPresent(channel, userID) && sm.presenceMemberCount(channel) >= limit {
+		sm.rejectLimit(client, "presence_members", protocol.ErrorPresenceLimit, "Presence member limit reached for "+channel)
+		return false
+	}
+	if !sm.admit(client, channel) {
+		return false
+	}
 	sm.addSubscription(client, channel)
 
 	if _, ok := sm.presence[channel]; !ok {
@@ -206,13 +345,16 @@ func (sm *SubscriptionManager) handlePresenceSubscribe(client *Client, channel s
 		sm.clientToUser[channel] = make(map[*Client]string)
 	}
 
-	_, alreadyPresent := sm.presence[channel][userID]
+	alreadyPresent := sm.memberPresent(channel, userID)
 	sm.presence[channel][userID] = member
 	sm.clientToUser[channel][client] = userID
+	if sm.replicator != nil {
+		sm.replicator.MemberAdded(channel, client.ID, member)
+	}
 
 	ids := []string{}
 	hash := make(map[string]interface{})
-	for uid, m := range sm.presence[channel] {
+	for uid, m := range sm.presenceMembers(channel) {
 		ids = append(ids, uid)
 		var info interface{}
 		_ = json.Unmarshal(m.UserInfo, &info)
@@ -234,36 +376,177 @@ func (sm *SubscriptionManager) handlePresenceSubscribe(client *Client, channel s
 	client.Send(successMsg)
 
 	if !alreadyPresent {
-		addedData := map[string]interface{}{
-			"user_id":   userID,
-			"user_info": member.UserInfo,
+		sm.sendMemberAdded(channel, member, client)
+	}
+	return true
+}
+
+func (sm *SubscriptionManager) sendMemberAdded(channel string, member Member, except *Client) {
+	addedData := map[string]interface{}{
+		"user_id":   member.UserID,
+		"user_info": member.UserInfo,
+	}
+	addedDataBytes, _ := json.Marshal(addedData)
+	addedMsg, _ := json.Marshal(channelEventPayload{
+		Event:   protocol.EventMemberAdded,
+		Channel: channel,
+		Data:    string(addedDataBytes),
+	})
+	sm.sendToChannel(channel, addedMsg, except)
+}
+
+func (sm *SubscriptionManager) sendMemberRemoved(channel, userID string) {
+	removedData := map[string]interface{}{
+		"user_id": userID,
+	}
+	removedDataBytes, _ := json.Marshal(removedData)
+	removedMsg, _ := json.Marshal(channelEventPayload{
+		Event:   protocol.EventMemberRemoved,
+		Channel: channel,
+		Data:    string(removedDataBytes),
+	})
+	sm.sendToChannel(channel, removedMsg, nil)
+}
+
+func (sm *SubscriptionManager) sendToChannel(channel string, msg []byte, except *Client) {
+	pm, _ := websocket.NewPreparedMessage(websocket.TextMessage, msg)
+
+	for sub := range sm.channels[channel] {
+		if sub == except {
+			continue
+		}
+		if pm != nil {
+			sub.Send(pm)
+		} else {
+			sub.Send(msg)
+		}
+	}
+}
+
+// ApplyPresenceUpdate records a presence connection owned by another node.
+func (sm *SubscriptionManager) ApplyPresenceUpdate(update PresenceUpdate) {
+	if !strings.HasPrefix(update.Channel, protocol.ChannelPrefixPresence) || update.UserID == "" {
+		return
+	}
+
+	switch update.Action {
+	case PresenceActionAdd:
+		wasPresent := sm.memberPresent(update.Channel, update.UserID)
+		users, ok := sm.remotePresence[update.Channel]
+		if !ok {
+			users = make(map[string]map[string]Member)
+			sm.remotePresence[update.Channel] = users
+		}
+		entries, ok := users[update.UserID]
+		if !ok {
+			entries = make(map[string]Member)
+			users[update.UserID] = entries
+		}
+		member := update.member()
+		entries[update.entryKey()] = member
+		if !wasPresent {
+			sm.sendMemberAdded(update.Channel, member, nil)
+		}
+
+	case PresenceActionRemove:
+		users := sm.remotePresence[update.Channel]
+		entries := users[update.UserID]
+		if _, ok := entries[update.entryKey()]; !ok {
+			return
+		}
+		delete(entries, update.entryKey())
+		if len(entries) == 0 {
+			delete(users, update.UserID)
+		}
+		if len(users) == 0 {
+			delete(sm.remotePresence, update.Channel)
+		}
+		if !sm.memberPresent(update.Channel, update.UserID) {
+			sm.sendMemberRemoved(update.Channel, update.UserID)
+		}
+	}
+}
+
+// LocalPresence lists the presence connections held on this shard as add
+// updates owned by nodeID.
+func (sm *SubscriptionManager) LocalPresence(nodeID string) []PresenceUpdate {
+	var updates []PresenceUpdate
+	for channel, userByClient := range sm.clientToUser {
+		for client, userID := range userByClient {
+			updates = append(updates, PresenceUpdate{
+				Action:   PresenceActionAdd,
+				Channel:  channel,
+				NodeID:   nodeID,
+				SocketID: client.ID,
+				UserID:   userID,
+				UserInfo: sm.presence[channel][userID].UserInfo,
+			})
 		}
-		addedDataBytes, _ := json.Marshal(addedData)
-		addedMsg, _ := json.Marshal(channelEventPayload{
-			Event:   protocol.EventMemberAdded,
-			Channel: channel,
-			Data:    string(addedDataBytes),
-		})
-
-		pm, _ := websocket.NewPreparedMessage(websocket.TextMessage, addedMsg)
-
-		for otherClient := range sm.channels[channel] {
-			if otherClient != client {
-				if pm != nil {
-					otherClient.Send(pm)
-				} else {
-					otherClient.Send(addedMsg)
+	}
+	return updates
+}
+
+// SyncRemotePresence replaces this shard's remote members with members,
+// announcing only the users who joined or left as a result.
+func (sm *SubscriptionManager) SyncRemotePresence(members []PresenceUpdate) {
+	current := make(map[string]bool, len(members))
+	for _, update := range members {
+		current[update.Channel+"|"+update.entryKey()] = true
+	}
+
+	var stale []PresenceUpdate
+	for channel, users := range sm.remotePresence {
+		for userID, entries := range users {
+			for key := range entries {
+				if current[channel+"|"+key] {
+					continue
 				}
+				nodeID, socketID, _ := strings.Cut(key, "|")
+				stale = append(stale, PresenceUpdate{
+					Action:   PresenceActionRemove,
+					Channel:  channel,
+					NodeID:   nodeID,
+					SocketID: socketID,
+					UserID:   userID,
+				})
 			}
 		}
 	}
-	return true
+	for _, update := range stale {
+		sm.ApplyPresenceUpdate(update)
+	}
+	for _, update := range members {
+		update.Action = PresenceActionAdd
+		sm.ApplyPresenceUpdate(update)
+	}
+}
+
+func (sm *SubscriptionManager) memberPresent(channel, userID string) bool {
+	if _, ok := sm.presence[channel][userID]; ok {
+		return true
+	}
+	return len(sm.remotePresence[channel][userID]) > 0
+}
+
+func (sm *SubscriptionManager) presenceMembers(channel string) map[string]Member {
+	members := make(map[string]Member, len(sm.presence[channel])+len(sm.remotePresence[channel]))
+	for userID, entries := range sm.remotePresence[channel] {
+		for _, member := range entries {
+			members[userID] = member
+			break
+		}
+	}
+	for userID, member := range sm.presence[channel] {
+		members[userID] = member
+	}
+	return members
 }
 
 func (sm *SubscriptionManager) Unsubscribe(client *Client, channel string) {
 	if clients, ok := sm.channels[channel]; ok {
 		if _, subscribed := clients[client]; subscribed {
 			delete(clients, client)
+			client.releaseSubscription()
 			if sm.metrics != nil {
 				sm.metrics.Subscriptions.Dec()
 			}
@@ -278,6 +561,7 @@ func (sm *SubscriptionManager) Unsubscribe(client *Client, channel string) {
 	if chans, ok := sm.clients[client]; ok {
 		delete(chans, channel)
 	}
+	sm.dropGrant(client, channel)
 	if strings.HasPrefix(channel, protocol.ChannelPrefixPresence) {
 		sm.handlePresenceUnsubscribe(client, channel)
 	}
@@ -287,6 +571,9 @@ func (sm *SubscriptionManager) handlePresenceUnsubscribe(client *Client, channel
 	if clientMap, ok := sm.clientToUser[channel]; ok {
 		if userID, ok := clientMap[client]; ok {
 			delete(clientMap, client)
+			if sm.replicator != nil {
+				sm.replicator.MemberRemoved(channel, client.ID, userID)
+			}
 			stillPresent := false
 			for _, uid := range clientMap {
 				if uid == userID {
@@ -296,26 +583,8 @@ func (sm *SubscriptionManager) handlePresenceUnsubscribe(client *Client, channel
 			}
 			if !stillPresent {
 				delete(sm.presence[channel], userID)
-				removedData := map[string]interface{}{
-					"user_id": userID,
-				}
-				removedDataBytes, _ := json.Marshal(removedData)
-				removedMsg, _ := json.Marshal(channelEventPayload{
-					Event:   protocol.EventMemberRemoved,
-					Channel: channel,
-					Data:    string(removedDataBytes),
-				})
-
-				pm, _ := websocket.NewPreparedMessage(websocket.TextMessage, removedMsg)
-
-				if clients, ok := sm.channels[channel]; ok {
-					for sub := range clients {
-						if pm != nil {
-							sub.Send(pm)
-						} else {
-							sub.Send(removedMsg)
-						}
-					}
+				if !sm.memberPresent(channel, userID) {
+					sm.sendMemberRemoved(channel, userID)
 				}
 			}
 			if len(clientMap) == 0 {
@@ -352,6 +621,15 @@ func (sm *SubscriptionManager) SnapshotChannels(filterByPrefix string) []Channel
 		}
 		snapshots = append(snapshots, sm.SnapshotChannel(channel))
 	}
+	for channel := range sm.remotePresence {
+		if len(sm.channels[channel]) > 0 {
+			continue
+		}
+		if filterByPrefix != "" && !strings.HasPrefix(channel, filterByPrefix) {
+			continue
+		}
+		snapshots = append(snapshots, sm.SnapshotChannel(channel))
+	}
 	return snapshots
 }
 
@@ -362,7 +640,10 @@ func (sm *SubscriptionManager) SnapshotChannel(channel string) ChannelSnapshot {
 		SubscriptionCount: len(clients),
 	}
 
-	if members, ok := sm.presence[channel]; ok {
+	_, local := sm.presence[channel]
+	_, remote := sm.remotePresence[channel]
+	if local || remote {
+		members := sm.presenceMembers(channel)
 		snapshot.Presence = true
 		snapshot.UserIDs = make([]string, 0, len(members))
 		for userID := range members {
@@ -247,3 +247,124 @@ func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
 	}
 	return metric.GetGauge().GetValue()
 }
+
+func TestRemotePresenceMembersMergeWithLocalState(t *testing.T) {
+	sm := newTestSubManager()
+	channel := "presence-room"
+	c1 := &Client{ID: "c1", send: make(chan any, 10)}
+
+	if !sm.Subscribe(c1, channel, []byte(`{"channel_data": "{\"user_id\":\"A\"}"}`)) {
+		t.Fatal("Expected presence subscribe to succeed")
+	}
+	drainClientMessage(t, c1)
+
+	remote := PresenceUpdate{Action: PresenceActionAdd, Channel: channel, NodeID: "node-2", SocketID: "2.1", UserID: "B"}
+	sm.ApplyPresenceUpdate(remote)
+	drainClientMessage(t, c1)
+
+	second := remote
+	second.SocketID = "2.2"
+	sm.ApplyPresenceUpdate(second)
+	if len(c1.send) != 0 {
+		t.Fatal("Expected no member_added for a second connection of the same remote user")
+	}
+
+	snapshot := sm.SnapshotChannel(channel)
+	if len(snapshot.UserIDs) != 2 || snapshot.UserIDs[0] != "A" || snapshot.UserIDs[1] != "B" {
+		t.Fatalf("Expected merged user IDs [A B], got %v", snapshot.UserIDs)
+	}
+
+	remote.Action = PresenceActionRemove
+	sm.ApplyPresenceUpdate(remote)
+	if len(c1.send) != 0 {
+		t.Fatal("Expected no member_removed while the remote user has another connection")
+	}
+
+	second.Action = PresenceActionRemove
+	sm.ApplyPresenceUpdate(second)
+	drainClientMessage(t, c1)
+
+	if _, ok := sm.remotePresence[channel]; ok {
+		t.Fatal("Expected remote presence map to be removed")
+	}
+}
+
+func TestLocalUnsubscribeKeepsUserPresentOnOtherNode(t *testing.T) {
+	sm := newTestSubManager()
+	channel := "presence-room"
+	c1 := &Client{ID: "c1", send: make(chan any, 10)}
+	c2 := &Client{ID: "c2", send: make(chan any, 10)}
+
+	sm.ApplyPresenceUpdate(PresenceUpdate{Action: PresenceActionAdd, Channel: channel, NodeID: "node-2", SocketID: "2.1", UserID: "A"})
+
+	if !sm.Subscribe(c1, channel, []byte(`{"channel_data": "{\"user_id\":\"A\"}"}`)) {
+		t.Fatal("Expected first presence subscribe to succeed")
+	}
+	drainClientMessage(t, c1)
+	if !sm.Subscribe(c2, channel, []byte(`{"channel_data": "{\"user_id\":\"B\"}"}`)) {
+		t.Fatal("Expected second presence subscribe to succeed")
+	}
+	drainClientMessage(t, c2)
+	drainClientMessage(t, c1)
+
+	sm.Unsubscribe(c1, channel)
+
+	if len(c2.send) != 0 {
+		t.Fatal("Expected no member_removed while user A is present on another node")
+	}
+	if snapshot := sm.SnapshotChannel(channel); len(snapshot.UserIDs) != 2 {
+		t.Fatalf("Expected users A and B to remain present, got %v", snapshot.UserIDs)
+	}
+}
+
+func TestBroadcastToChannelAppliesTarget(t *testing.T) {
+	sm := newTestSubManager()
+	channel := "presence-room"
+	author := &Client{ID: "1.1", send: make(chan any, 10)}
+	authorPhone := &Client{ID: "1.2", send: make(chan any, 10)}
+	reader := &Client{ID: "1.3", send: make(chan any, 10)}
+	guest := &Client{ID: "1.4", send: make(chan any, 10)}
+	guest.SetUserID("C")
+
+	for client, userID := range map[*Client]string{author: "A", authorPhone: "A", reader: "B"} {
+		if !sm.Subscribe(client, channel, []byte(`{"channel_data": "{\"user_id\":\"`+userID+`\"}"}`)) {
+			t.Fatalf("Expected presence subscribe for %s to succeed", client.ID)
+		}
+	}
+	// Public subscribers are matched by the user they signed in as.
+	sm.addSubscription(guest, channel)
+	for _, client := range []*Client{author, authorPhone, reader} {
+		for len(client.send) > 0 {
+			<-client.send
+		}
+	}
+
+	tests := []struct {
+		name   string
+		target PublishTarget
+		want   []*Client
+	}{
+		{"except user", PublishTarget{ExceptUserIDs: []string{"A"}}, []*Client{reader, guest}},
+		{"except sockets", PublishTarget{ExceptSocketIDs: []string{"1.2", "1.3"}}, []*Client{author, guest}},
+		{"only users", PublishTarget{UserIDs: []string{"A", "C"}}, []*Client{author, authorPhone, guest}},
+		{"only users except socket", PublishTarget{UserIDs: []string{"A"}, ExceptSocketIDs: []string{"1.1"}}, []*Client{authorPhone}},
+	}
+	for _, tt := range tests {
+		target := tt.target
+		sm.BroadcastToChannel(&BroadcastMessage{Channel: channel, Event: "update", Data: json.RawMessage(`{}`), Target: &target})
+
+		want := make(map[*Client]bool, len(tt.want))
+		for _, client := range tt.want {
+			want[client] = true
+		}
+		for _, client := range []*Client{author, authorPhone, reader, guest} {
+			got := len(client.send) == 1
+			if got != want[client] {
+				t.Fatalf("%s: client %s received = %v, want %v", tt.name, client.ID, got, want[client])
+			}
+			for len(client.send) > 0 {
+				<-client.send
+			}
+		}
+	}
+}
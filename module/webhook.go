@@ -3,12 +3,10 @@ package websocket
 import (
 	"bytes"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,7 +15,7 @@ import (
 
 type WebhookManager struct {
 	url     string
-	secret  string
+	keys    *KeyRing
 	client  *http.Client
 	logger  *zap.Logger
 	metrics *Metrics
@@ -51,7 +49,7 @@ func NewWebhookManager(logger *zap.Logger, url, secret string, metrics ...*Metri
 	ctx, cancel := context.WithCancel(context.Background())
 	wm := &WebhookManager{
 		url:     url,
-		secret:  secret,
+		keys:    NewKeyRing(m, AppCredential{Secret: secret}),
 		logger:  logger,
 		metrics: m,
 		jobs:    make(chan WebhookEvent, defaultWebhookQueueSize),
@@ -125,11 +123,12 @@ func (wm *WebhookManager) deliver(ctx context.Context, event WebhookEvent) {
 		return
 	}
 
-	signature := ""
-	if wm.secret != "" {
-		mac := hmac.New(sha256.New, []byte(wm.secret))
-		mac.Write(jsonBytes)
-		signature = hex.EncodeToString(mac.Sum(nil))
+	// The primary secret signs X-Pusher-Signature. While previous secrets are
+	// still active, every signature is also listed so receivers can rotate.
+	signatures := wm.keys.Signatures(jsonBytes)
+	versioned := make([]string, 0, len(signatures))
+	for _, signature := range signatures {
+		versioned = append(versioned, signature.Version+"="+signature.Signature)
 	}
 
 	var lastErr error
@@ -156,9 +155,12 @@ func (wm *WebhookManager) deliver(ctx context.Context, event WebhookEvent) {
 			return
 		}
 		req.Header.Set("Content-Type", "application/json")
-		if signature != "" {
+		if len(signatures) > 0 {
 			req.Header.Set("X-Pusher-Key", "frankenphp")
-			req.Header.Set("X-Pusher-Signature", signature)
+			req.Header.Set("X-Pusher-Signature", signatures[0].Signature)
+		}
+		if len(signatures) > 1 {
+			req.Header.Set("X-FrankenPHP-WS-Signatures", strings.Join(versioned, ","))
 		}
 
 		resp, err := wm.client.Do(req)
@@ -183,6 +185,12 @@ func (wm *WebhookManager) deliver(ctx context.Context, event WebhookEvent) {
 		zap.String("channel", event.Channel))
 }
 
+// SetKeyRing signs webhooks with a rotation key ring instead of a single
+// secret. Call it before the first notification.
+func (wm *WebhookManager) SetKeyRing(keys *KeyRing) {
+	wm.keys = keys
+}
+
 func (wm *WebhookManager) Close() {
 	if wm.cancel == nil {
 		return
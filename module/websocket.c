@@ -3,6 +3,8 @@
 #include <Zend/zend_hash.h>
 #include <Zend/zend_types.h>
 #include <stddef.h>
+#include <ext/json/php_json.h>
+#include <Zend/zend_smart_str.h>
 
 #include "websocket.h"
 #include "websocket_arginfo.h"
@@ -30,13 +32,22 @@ PHP_FUNCTION(pogo_websocket_publish)
     zend_string *channel = NULL;
     zend_string *event = NULL;
     zend_string *data = NULL;
-    ZEND_PARSE_PARAMETERS_START(4, 4)
+    zend_long ack = 0;
+    zend_long ackTimeoutMs = 0;
+    zend_string *idempotencyKey = NULL;
+    zend_string *target = NULL;
+    ZEND_PARSE_PARAMETERS_START(4, 8)
         Z_PARAM_STR(appId)
         Z_PARAM_STR(channel)
         Z_PARAM_STR(event)
         Z_PARAM_STR(data)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_LONG(ack)
+        Z_PARAM_LONG(ackTimeoutMs)
+        Z_PARAM_STR_OR_NULL(idempotencyKey)
+        Z_PARAM_STR_OR_NULL(target)
     ZEND_PARSE_PARAMETERS_END();
-    int result = pogo_websocket_publish(appId, channel, event, data);
+    int result = pogo_websocket_publish(appId, channel, event, data, ack, ackTimeoutMs, idempotencyKey, target);
     RETURN_LONG(result);
 }
 
@@ -46,12 +57,170 @@ PHP_FUNCTION(pogo_websocket_broadcast_multi)
     zend_string *channels = NULL;
     zend_string *event = NULL;
     zend_string *data = NULL;
-    ZEND_PARSE_PARAMETERS_START(4, 4)
+    zend_long ack = 0;
+    zend_long ackTimeoutMs = 0;
+    zend_string *idempotencyKey = NULL;
+    zend_string *target = NULL;
+    ZEND_PARSE_PARAMETERS_START(4, 8)
         Z_PARAM_STR(appId)
         Z_PARAM_STR(channels)
         Z_PARAM_STR(event)
         Z_PARAM_STR(data)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_LONG(ack)
+        Z_PARAM_LONG(ackTimeoutMs)
+        Z_PARAM_STR_OR_NULL(idempotencyKey)
+        Z_PARAM_STR_OR_NULL(target)
     ZEND_PARSE_PARAMETERS_END();
-    int result = pogo_websocket_broadcast_multi(appId, channels, event, data);
+    int result = pogo_websocket_broadcast_multi(appId, channels, event, data, ack, ackTimeoutMs, idempotencyKey, target);
     RETURN_LONG(result);
 }
+
+PHP_FUNCTION(pogo_websocket_schedule)
+{
+    zend_string *appId = NULL;
+    zend_string *channel = NULL;
+    zend_string *event = NULL;
+    zend_string *data = NULL;
+    zend_long deliverAtMs = 0;
+    zend_string *cancelKey = NULL;
+    ZEND_PARSE_PARAMETERS_START(5, 6)
+        Z_PARAM_STR(appId)
+        Z_PARAM_STR(channel)
+        Z_PARAM_STR(event)
+        Z_PARAM_STR(data)
+        Z_PARAM_LONG(deliverAtMs)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_STR_OR_NULL(cancelKey)
+    ZEND_PARSE_PARAMETERS_END();
+    int result = pogo_websocket_schedule(appId, channel, event, data, deliverAtMs, cancelKey);
+    RETURN_LONG(result);
+}
+
+PHP_FUNCTION(pogo_websocket_cancel_scheduled)
+{
+    zend_string *appId = NULL;
+    zend_string *cancelKey = NULL;
+    ZEND_PARSE_PARAMETERS_START(2, 2)
+        Z_PARAM_STR(appId)
+        Z_PARAM_STR(cancelKey)
+    ZEND_PARSE_PARAMETERS_END();
+    int result = pogo_websocket_cancel_scheduled(appId, cancelKey);
+    RETURN_BOOL(result);
+}
+
+PHP_FUNCTION(pogo_websocket_invalidate_auth_cache)
+{
+    zend_string *appId = NULL;
+    zend_string *channel = NULL;
+    zend_string *userId = NULL;
+    ZEND_PARSE_PARAMETERS_START(1, 3)
+        Z_PARAM_STR(appId)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_STR_OR_NULL(channel)
+        Z_PARAM_STR_OR_NULL(userId)
+    ZEND_PARSE_PARAMETERS_END();
+    zend_long result = pogo_websocket_invalidate_auth_cache(appId, channel, userId);
+    RETURN_LONG(result);
+}
+
+PHP_FUNCTION(pogo_websocket_unsubscribe)
+{
+    zend_string *appId = NULL;
+    zend_string *channel = NULL;
+    zend_string *userId = NULL;
+    zend_string *socketId = NULL;
+    ZEND_PARSE_PARAMETERS_START(2, 4)
+        Z_PARAM_STR(appId)
+        Z_PARAM_STR(channel)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_STR_OR_NULL(userId)
+        Z_PARAM_STR_OR_NULL(socketId)
+    ZEND_PARSE_PARAMETERS_END();
+    zend_long result = pogo_websocket_unsubscribe(appId, channel, userId, socketId);
+    RETURN_LONG(result);
+}
+
+static zend_string *pogo_websocket_json_encode(zval *value)
+{
+    smart_str buf = {0};
+    if (php_json_encode(&buf, value, 0) == FAILURE) {
+        smart_str_free(&buf);
+        return NULL;
+    }
+    smart_str_0(&buf);
+    return buf.s;
+}
+
+PHP_FUNCTION(pogo_websocket_publish_with_options)
+{
+    zend_string *appId = NULL;
+    zval *channels = NULL;
+    zend_string *event = NULL;
+    zend_string *data = NULL;
+    zval *options = NULL;
+    ZEND_PARSE_PARAMETERS_START(4, 5)
+        Z_PARAM_STR(appId)
+        Z_PARAM_ARRAY(channels)
+        Z_PARAM_STR(event)
+        Z_PARAM_STR(data)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ARRAY(options)
+    ZEND_PARSE_PARAMETERS_END();
+
+    HashTable *channelList = Z_ARRVAL_P(channels);
+    zval *channel;
+    ZEND_HASH_FOREACH_VAL(channelList, channel) {
+        ZVAL_DEREF(channel);
+        if (Z_TYPE_P(channel) != IS_STRING) {
+            zend_argument_type_error(2, "must contain only strings");
+            RETURN_THROWS();
+        }
+    } ZEND_HASH_FOREACH_END();
+
+    uint32_t count = zend_hash_num_elements(channelList);
+    if (count == 0) {
+        RETURN_EMPTY_ARRAY();
+    }
+
+    /* Keys are ignored: a list always encodes as a JSON array. */
+    zval list;
+    array_init_size(&list, count);
+    ZEND_HASH_FOREACH_VAL(channelList, channel) {
+        ZVAL_DEREF(channel);
+        Z_TRY_ADDREF_P(channel);
+        add_next_index_zval(&list, channel);
+    } ZEND_HASH_FOREACH_END();
+    zend_string *channelsJson = pogo_websocket_json_encode(&list);
+    zval_ptr_dtor(&list);
+    if (channelsJson == NULL) {
+        zend_argument_value_error(2, "must be JSON encodable");
+        RETURN_THROWS();
+    }
+    zend_string *optionsJson = NULL;
+    if (options != NULL && zend_hash_num_elements(Z_ARRVAL_P(options)) > 0) {
+        optionsJson = pogo_websocket_json_encode(options);
+        if (optionsJson == NULL) {
+            zend_string_release(channelsJson);
+            zend_argument_value_error(5, "must be JSON encodable");
+            RETURN_THROWS();
+        }
+    }
+
+    int *statuses = safe_emalloc(count, sizeof(int), 0);
+    pogo_websocket_publish_with_options(appId, channelsJson, event, data, optionsJson, statuses, (int) count);
+
+    array_init_size(return_value, count);
+    uint32_t i = 0;
+    ZEND_HASH_FOREACH_VAL(channelList, channel) {
+        ZVAL_DEREF(channel);
+        add_assoc_long_ex(return_value, Z_STRVAL_P(channel), Z_STRLEN_P(channel), statuses[i]);
+        i++;
+    } ZEND_HASH_FOREACH_END();
+
+    efree(statuses);
+    zend_string_release(channelsJson);
+    if (optionsJson != NULL) {
+        zend_string_release(optionsJson);
+    }
+}
@@ -4,6 +4,8 @@ package websocket
 // #include "websocket.h"
 import "C"
 import (
+	"context"
+	"time"
 	"unsafe"
 
 	"encoding/json"
@@ -16,7 +18,7 @@ func init() {
 }
 
 //export pogo_websocket_publish
-func pogo_websocket_publish(appId *C.zend_string, channel *C.zend_string, event *C.zend_string, data *C.zend_string) C.int {
+func pogo_websocket_publish(appId *C.zend_string, channel *C.zend_string, event *C.zend_string, data *C.zend_string, ack C.zend_long, ackTimeoutMs C.zend_long, idempotencyKey *C.zend_string, target *C.zend_string) C.int {
 	goAppID := frankenphp.GoString(unsafe.Pointer(appId))
 	hubs := GetHubs(goAppID)
 	if len(hubs) == 0 {
@@ -26,12 +28,16 @@ func pogo_websocket_publish(appId *C.zend_string, channel *C.zend_string, event
 	goChannel := frankenphp.GoString(unsafe.Pointer(channel))
 	goEvent := frankenphp.GoString(unsafe.Pointer(event))
 	goData := frankenphp.GoString(unsafe.Pointer(data))
+	options, ok := nativePublishOptions(ack, ackTimeoutMs, idempotencyKey, target)
+	if !ok {
+		return C.int(PublishInvalidTarget)
+	}
 
-	return C.int(publishToActiveHubs(hubs, goChannel, goEvent, goData))
+	return C.int(publishToActiveHubsWithOptions(hubs, goChannel, goEvent, goData, options))
 }
 
 //export pogo_websocket_broadcast_multi
-func pogo_websocket_broadcast_multi(appId *C.zend_string, channels *C.zend_string, event *C.zend_string, data *C.zend_string) C.int {
+func pogo_websocket_broadcast_multi(appId *C.zend_string, channels *C.zend_string, event *C.zend_string, data *C.zend_string, ack C.zend_long, ackTimeoutMs C.zend_long, idempotencyKey *C.zend_string, target *C.zend_string) C.int {
 	goAppID := frankenphp.GoString(unsafe.Pointer(appId))
 	hubs := GetHubs(goAppID)
 	if len(hubs) == 0 {
@@ -47,12 +53,118 @@ func pogo_websocket_broadcast_multi(appId *C.zend_string, channels *C.zend_strin
 		return C.int(PublishInvalidChannelsJSON)
 	}
 
+	options, ok := nativePublishOptions(ack, ackTimeoutMs, idempotencyKey, target)
+	if !ok {
+		return C.int(PublishInvalidTarget)
+	}
 	status := PublishOK
 	for _, ch := range channelList {
-		if result := publishToActiveHubs(hubs, ch, goEvent, goData); result != PublishOK && status == PublishOK {
-			status = result
-		}
+		status = mergePublishStatus(status, publishToActiveHubsWithOptions(hubs, ch, goEvent, goData, options))
 	}
 
 	return C.int(status)
 }
+
+//export pogo_websocket_schedule
+func pogo_websocket_schedule(appId *C.zend_string, channel *C.zend_string, event *C.zend_string, data *C.zend_string, deliverAtMs C.zend_long, cancelKey *C.zend_string) C.int {
+	goAppID := frankenphp.GoString(unsafe.Pointer(appId))
+	hubs := GetHubs(goAppID)
+	if len(hubs) == 0 {
+		return C.int(PublishHubMissing)
+	}
+
+	goChannel := frankenphp.GoString(unsafe.Pointer(channel))
+	goEvent := frankenphp.GoString(unsafe.Pointer(event))
+	goData := frankenphp.GoString(unsafe.Pointer(data))
+	options := PublishOptions{DeliverAt: time.UnixMilli(int64(deliverAtMs))}
+	if cancelKey != nil {
+		options.CancelKey = frankenphp.GoString(unsafe.Pointer(cancelKey))
+	}
+
+	return C.int(publishToActiveHubsWithOptions(hubs, goChannel, goEvent, goData, options))
+}
+
+//export pogo_websocket_cancel_scheduled
+func pogo_websocket_cancel_scheduled(appId *C.zend_string, cancelKey *C.zend_string) C.int {
+	goAppID := frankenphp.GoString(unsafe.Pointer(appId))
+	hubs := GetHubs(goAppID)
+	if len(hubs) == 0 {
+		return 0
+	}
+	if cancelScheduledOnActiveHubs(hubs, frankenphp.GoString(unsafe.Pointer(cancelKey))) {
+		return 1
+	}
+	return 0
+}
+
+//export pogo_websocket_invalidate_auth_cache
+func pogo_websocket_invalidate_auth_cache(appId *C.zend_string, channel *C.zend_string, userId *C.zend_string) C.zend_long {
+	goChannel, goUserID := "", ""
+	if channel != nil {
+		goChannel = frankenphp.GoString(unsafe.Pointer(channel))
+	}
+	if userId != nil {
+		goUserID = frankenphp.GoString(unsafe.Pointer(userId))
+	}
+
+	hubs := GetHubs(frankenphp.GoString(unsafe.Pointer(appId)))
+	invalidated, _ := invalidateAuthCacheOnHubs(context.Background(), hubs, goChannel, goUserID)
+	return C.zend_long(invalidated)
+}
+
+//export pogo_websocket_unsubscribe
+func pogo_websocket_unsubscribe(appId *C.zend_string, channel *C.zend_string, userId *C.zend_string, socketId *C.zend_string) C.zend_long {
+	goUserID, goSocketID := "", ""
+	if userId != nil {
+		goUserID = frankenphp.GoString(unsafe.Pointer(userId))
+	}
+	if socketId != nil {
+		goSocketID = frankenphp.GoString(unsafe.Pointer(socketId))
+	}
+
+	hubs := GetHubs(frankenphp.GoString(unsafe.Pointer(appId)))
+	unsubscribed, _ := unsubscribeOnHubs(context.Background(), hubs, frankenphp.GoString(unsafe.Pointer(channel)), goUserID, goSocketID)
+	return C.zend_long(unsubscribed)
+}
+
+//export pogo_websocket_publish_with_options
+func pogo_websocket_publish_with_options(appId *C.zend_string, channels *C.zend_string, event *C.zend_string, data *C.zend_string, options *C.zend_string, statuses *C.int, count C.int) {
+	goOptions := ""
+	if options != nil {
+		goOptions = frankenphp.GoString(unsafe.Pointer(options))
+	}
+
+	results := publishChannelsWithOptions(
+		frankenphp.GoString(unsafe.Pointer(appId)),
+		frankenphp.GoString(unsafe.Pointer(channels)),
+		frankenphp.GoString(unsafe.Pointer(event)),
+		frankenphp.GoString(unsafe.Pointer(data)),
+		goOptions,
+	)
+
+	out := unsafe.Slice(statuses, int(count))
+	for i := range out {
+		if i < len(results) {
+			out[i] = C.int(results[i])
+		} else {
+			out[i] = C.int(PublishInvalidChannelsJSON)
+		}
+	}
+}
+
+// nativePublishOptions reports false when target is not a JSON PublishTarget object.
+func nativePublishOptions(ack C.zend_long, ackTimeoutMs C.zend_long, idempotencyKey *C.zend_string, target *C.zend_string) (PublishOptions, bool) {
+	options := PublishOptions{
+		Ack:        PublishAck(ack),
+		AckTimeout: time.Duration(ackTimeoutMs) * time.Millisecond,
+	}
+	if idempotencyKey != nil {
+		options.IdempotencyKey = frankenphp.GoString(unsafe.Pointer(idempotencyKey))
+	}
+	if target != nil {
+		if err := json.Unmarshal([]byte(frankenphp.GoString(unsafe.Pointer(target))), &options.Target); err != nil {
+			return options, false
+		}
+	}
+	return options, true
+}
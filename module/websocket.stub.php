@@ -2,6 +2,24 @@
 
 /** @generate-class-entries */
 
-function pogo_websocket_publish(string $appId, string $channel, string $event, string $data): int {}
+function pogo_websocket_publish(string $appId, string $channel, string $event, string $data, int $ack = 0, int $ackTimeoutMs = 0, ?string $idempotencyKey = null, ?string $target = null): int {}
 
-function pogo_websocket_broadcast_multi(string $appId, string $channels, string $event, string $data): int {}
+function pogo_websocket_broadcast_multi(string $appId, string $channels, string $event, string $data, int $ack = 0, int $ackTimeoutMs = 0, ?string $idempotencyKey = null, ?string $target = null): int {}
+
+function pogo_websocket_schedule(string $appId, string $channel, string $event, string $data, int $deliverAtMs, ?string $cancelKey = null): int {}
+
+function pogo_websocket_cancel_scheduled(string $appId, string $cancelKey): bool {}
+
+/** @return int number of cached auth results removed across the cluster */
+function pogo_websocket_invalidate_auth_cache(string $appId, ?string $channel = null, ?string $userId = null): int {}
+
+/** @return int number of connections removed from the channel across the cluster */
+function pogo_websocket_unsubscribe(string $appId, string $channel, ?string $userId = null, ?string $socketId = null): int {}
+
+/**
+ * @param array<string> $channels
+ * @param array<string, mixed> $options socket_id, ack, ack_timeout_ms, idempotency_key,
+ *        deliver_at_ms, cancel_key, except_socket_ids, except_user_ids, user_ids
+ * @return array<string, int> status code per channel
+ */
+function pogo_websocket_publish_with_options(string $appId, array $channels, string $event, string $data, array $options = []): array {}